@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/igvaquero18/magnifibot/controller"
+	"github.com/igvaquero18/magnifibot/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	verboseEnv                = "MAGNIFIBOT_VERBOSE"
+	awsRegionEnv              = "MAGNIFIBOT_AWS_REGION"
+	sqsEndpointEnv            = "MAGNIFIBOT_SQS_ENDPOINT"
+	sqsQueueNameEnv           = "MAGNIFIBOT_SQS_QUEUE_NAME"
+	sqsDeadLetterQueueNameEnv = "MAGNIFIBOT_SQS_DEAD_LETTER_QUEUE_NAME"
+	dynamoDBEndpointEnv       = "MAGNIFIBOT_DYNAMODB_ENDPOINT"
+	dynamoDBDeliveryTableEnv  = "MAGNIFIBOT_DYNAMODB_DELIVERY_TABLE"
+)
+
+const (
+	verboseFlag                = "logging.verbose"
+	awsRegionFlag              = "aws.region"
+	sqsEndpointFlag            = "aws.sqs.endpoint"
+	sqsQueueNameFlag           = "aws.sqs.queue_name"
+	sqsDeadLetterQueueNameFlag = "aws.sqs.dead_letter_queue_name"
+	dynamoDBEndpointFlag       = "aws.dynamodb.endpoint"
+	dynamoDBDeliveryTableFlag  = "aws.dynamodb.tables.delivery"
+)
+
+const (
+	inspectAction = "inspect"
+	replayAction  = "replay"
+	deleteAction  = "delete"
+
+	defaultMax = 100
+)
+
+var (
+	c     controller.MagnifibotInterface
+	sugar *zap.SugaredLogger
+)
+
+// Event selects the dead letters to act upon. Dead letters are selected by
+// chat ID and by a substring of their failure reason. Replaying or deleting
+// requires at least one filter, or All to be set explicitly.
+//
+// The function is meant to be invoked by an operator, for instance:
+//
+// sls invoke -f deadletters -d '{"action":"replay","reason":"Too Many Requests"}'
+type Event struct {
+	Action  string   `json:"action,omitempty"`
+	ChatIDs []string `json:"chat_ids,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+	All     bool     `json:"all,omitempty"`
+	Max     int      `json:"max,omitempty"`
+}
+
+// Report summarizes the dead letters found, grouped by failure reason and by
+// chat, and the ones that have been replayed or deleted.
+type Report struct {
+	Total    int            `json:"total"`
+	Selected int            `json:"selected"`
+	ByReason map[string]int `json:"by_reason"`
+	ByChat   map[string]int `json:"by_chat"`
+	Replayed []string       `json:"replayed,omitempty"`
+	Deleted  []string       `json:"deleted,omitempty"`
+	Errors   []string       `json:"errors,omitempty"`
+}
+
+func init() {
+	viper.SetDefault(verboseFlag, false)
+	viper.SetDefault(awsRegionFlag, "eu-west-3")
+	viper.SetDefault(sqsEndpointFlag, "")
+	viper.SetDefault(sqsQueueNameFlag, controller.DefaultQueueName)
+	viper.SetDefault(sqsDeadLetterQueueNameFlag, controller.DefaultDeadLetterQueueName)
+	viper.SetDefault(dynamoDBEndpointFlag, "")
+	viper.SetDefault(dynamoDBDeliveryTableFlag, controller.DefaultDeliveryTable)
+	viper.BindEnv(verboseFlag, verboseEnv)
+	viper.BindEnv(awsRegionFlag, awsRegionEnv)
+	viper.BindEnv(sqsEndpointFlag, sqsEndpointEnv)
+	viper.BindEnv(sqsQueueNameFlag, sqsQueueNameEnv)
+	viper.BindEnv(sqsDeadLetterQueueNameFlag, sqsDeadLetterQueueNameEnv)
+	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
+	viper.BindEnv(dynamoDBDeliveryTableFlag, dynamoDBDeliveryTableEnv)
+
+	var err error
+
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+	if err != nil {
+		fmt.Printf("error when initializing logger: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	region := viper.GetString(awsRegionFlag)
+	sqsEndpoint := viper.GetString(sqsEndpointFlag)
+	dynamoDBEndpoint := viper.GetString(dynamoDBEndpointFlag)
+
+	sugar.Infow("creating SQS client", "region", region, "url", sqsEndpoint)
+	sqsClient, err := utils.InitSQSClient(region, sqsEndpoint)
+	if err != nil {
+		sugar.Fatalw("error creating SQS client", "error", err.Error())
+	}
+
+	queueURL, err := sqsClient.GetQueueUrl(context.TODO(), &sqs.GetQueueUrlInput{
+		QueueName: aws.String(viper.GetString(sqsQueueNameFlag)),
+	})
+	if err != nil {
+		sugar.Fatalw(
+			"error getting the queue URL",
+			"queue_name",
+			viper.GetString(sqsQueueNameFlag),
+			"error",
+			err.Error(),
+		)
+	}
+
+	deadLetterQueueURL, err := sqsClient.GetQueueUrl(context.TODO(), &sqs.GetQueueUrlInput{
+		QueueName: aws.String(viper.GetString(sqsDeadLetterQueueNameFlag)),
+	})
+	if err != nil {
+		sugar.Fatalw(
+			"error getting the dead-letter queue URL",
+			"queue_name",
+			viper.GetString(sqsDeadLetterQueueNameFlag),
+			"error",
+			err.Error(),
+		)
+	}
+
+	sugar.Infow("creating DynamoDB client", "region", region, "url", dynamoDBEndpoint)
+	dynamoClient, err := utils.InitDynamoClient(region, dynamoDBEndpoint)
+	if err != nil {
+		sugar.Fatalw("error creating DynamoDB client", "error", err.Error())
+	}
+
+	c = controller.NewMagnifibot(
+		controller.SetConfig(&controller.MagnifibotConfig{
+			QueueURL:           *queueURL.QueueUrl,
+			DeadLetterQueueURL: *deadLetterQueueURL.QueueUrl,
+			DeliveryTable:      viper.GetString(dynamoDBDeliveryTableFlag),
+		}),
+		controller.SetSQSClient(sqsClient),
+		controller.SetSQSDeadLetterClient(sqsClient),
+		controller.SetDynamoDBClient(dynamoClient),
+	)
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call
+func Handler(ctx context.Context, event Event) (*Report, error) {
+	if event.Action == "" {
+		event.Action = inspectAction
+	}
+	if event.Max <= 0 {
+		event.Max = defaultMax
+	}
+	sugar.Infow(
+		"received dead letters event",
+		"action",
+		event.Action,
+		"chat_ids",
+		event.ChatIDs,
+		"reason",
+		event.Reason,
+	)
+
+	if event.Action != inspectAction && event.Action != replayAction && event.Action != deleteAction {
+		return nil, fmt.Errorf(
+			"invalid action %q, must be one of %s, %s or %s",
+			event.Action,
+			inspectAction,
+			replayAction,
+			deleteAction,
+		)
+	}
+
+	filtered := len(event.ChatIDs) > 0 || event.Reason != ""
+	if event.Action != inspectAction && !filtered && !event.All {
+		return nil, fmt.Errorf("refusing to %s every dead letter without setting \"all\"", event.Action)
+	}
+
+	deadLetters, err := c.ReceiveDeadLetters(ctx, event.Max)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Total:    len(deadLetters),
+		ByReason: map[string]int{},
+		ByChat:   map[string]int{},
+	}
+
+	for _, deadLetter := range deadLetters {
+		report.ByReason[deadLetter.Reason]++
+		report.ByChat[deadLetter.ChatID]++
+
+		if !selected(event, deadLetter) {
+			continue
+		}
+		report.Selected++
+
+		switch event.Action {
+		case replayAction:
+			messageID, err := c.ReplayDeadLetter(ctx, deadLetter)
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			sugar.Debugw("replayed dead letter", "sqs_message_id", deadLetter.MessageID, "new_sqs_message_id", messageID)
+			report.Replayed = append(report.Replayed, deadLetter.MessageID)
+		case deleteAction:
+			if err := c.DeleteDeadLetter(ctx, deadLetter); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			report.Deleted = append(report.Deleted, deadLetter.MessageID)
+		}
+	}
+
+	sugar.Infow(
+		"dead letters report",
+		"total",
+		report.Total,
+		"selected",
+		report.Selected,
+		"by_reason",
+		report.ByReason,
+		"by_chat",
+		report.ByChat,
+		"replayed",
+		len(report.Replayed),
+		"deleted",
+		len(report.Deleted),
+	)
+	return report, nil
+}
+
+// selected checks whether a dead letter matches the filters in the event
+func selected(event Event, deadLetter controller.DeadLetter) bool {
+	if event.Reason != "" && !strings.Contains(deadLetter.Reason, event.Reason) {
+		return false
+	}
+	if len(event.ChatIDs) == 0 {
+		return true
+	}
+	for _, chatID := range event.ChatIDs {
+		if chatID == deadLetter.ChatID {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	lambda.Start(Handler)
+}
@@ -2,12 +2,13 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"sync"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -21,27 +22,40 @@ import (
 )
 
 const (
-	verboseEnv           = "MAGNIFIBOT_VERBOSE"
-	awsRegionEnv         = "MAGNIFIBOT_AWS_REGION"
-	sqsEndpointEnv       = "MAGNIFIBOT_SQS_ENDPOINT"
-	sqsQueueNameEnv      = "MAGNIFIBOT_SQS_QUEUE_NAME"
-	dynamoDBEndpointEnv  = "MAGNIFIBOT_DYNAMODB_ENDPOINT"
-	dynamoDBUserTableEnv = "MAGNIFIBOT_DYNAMODB_USER_TABLE"
+	verboseEnv            = "MAGNIFIBOT_VERBOSE"
+	awsRegionEnv          = "MAGNIFIBOT_AWS_REGION"
+	sqsEndpointEnv        = "MAGNIFIBOT_SQS_ENDPOINT"
+	sqsQueueNameEnv       = "MAGNIFIBOT_SQS_QUEUE_NAME"
+	sqsConcurrencyEnv     = "MAGNIFIBOT_SQS_CONCURRENCY"
+	dynamoDBEndpointEnv   = "MAGNIFIBOT_DYNAMODB_ENDPOINT"
+	dynamoDBUserTableEnv  = "MAGNIFIBOT_DYNAMODB_USER_TABLE"
+	subscriberStoreEnv    = "MAGNIFIBOT_SUBSCRIBER_STORE"
+	subscriberStoreDSNEnv = "MAGNIFIBOT_SUBSCRIBER_STORE_DSN"
+	dynamoDBSegmentsEnv   = "MAGNIFIBOT_DYNAMODB_SCAN_SEGMENTS"
+	timezoneEnv           = "MAGNIFIBOT_TIMEZONE"
+	previewWeekdayEnv     = "MAGNIFIBOT_PREVIEW_WEEKDAY"
 )
 
 const (
-	verboseFlag           = "logging.verbose"
-	awsRegionFlag         = "aws.region"
-	sqsEndpointFlag       = "aws.sqs.endpoint"
-	sqsQueueNameFlag      = "aws.sqs.queue_name"
-	dynamoDBEndpointFlag  = "aws.dynamodb.endpoint"
-	dynamoDBUserTableFlag = "aws.dynamodb.tables.user"
+	verboseFlag            = "logging.verbose"
+	awsRegionFlag          = "aws.region"
+	sqsEndpointFlag        = "aws.sqs.endpoint"
+	sqsQueueNameFlag       = "aws.sqs.queue_name"
+	sqsConcurrencyFlag     = "aws.sqs.concurrency"
+	dynamoDBEndpointFlag   = "aws.dynamodb.endpoint"
+	dynamoDBUserTableFlag  = "aws.dynamodb.tables.user"
+	subscriberStoreFlag    = "subscribers.store"
+	subscriberStoreDSNFlag = "subscribers.dsn"
+	dynamoDBSegmentsFlag   = "aws.dynamodb.scan_segments"
+	timezoneFlag           = "timezone"
+	previewWeekdayFlag     = "preview.weekday"
 )
 
 var (
-	c     controller.MagnifibotInterface
-	a     archimadrid.Archimadrid
-	sugar *zap.SugaredLogger
+	c        controller.MagnifibotInterface
+	a        archimadrid.Archimadrid
+	location *time.Location
+	sugar    *zap.SugaredLogger
 )
 
 // Response is of type CloudWatchEvent since we're leveraging the
@@ -55,14 +69,26 @@ func init() {
 	viper.SetDefault(awsRegionFlag, "eu-west-3")
 	viper.SetDefault(sqsEndpointFlag, "")
 	viper.SetDefault(sqsQueueNameFlag, controller.DefaultQueueName)
+	viper.SetDefault(sqsConcurrencyFlag, controller.DefaultQueueConcurrency)
 	viper.SetDefault(dynamoDBEndpointFlag, "")
 	viper.SetDefault(dynamoDBUserTableFlag, controller.DefaultUserTable)
+	viper.SetDefault(subscriberStoreFlag, controller.DefaultSubscriberStore)
+	viper.SetDefault(subscriberStoreDSNFlag, "")
+	viper.SetDefault(dynamoDBSegmentsFlag, controller.DefaultScanSegments)
+	viper.SetDefault(timezoneFlag, controller.DefaultTimezone)
+	viper.SetDefault(previewWeekdayFlag, int(controller.DefaultPreviewWeekday))
 	viper.BindEnv(verboseFlag, verboseEnv)
 	viper.BindEnv(awsRegionFlag, awsRegionEnv)
 	viper.BindEnv(sqsEndpointFlag, sqsEndpointEnv)
 	viper.BindEnv(sqsQueueNameFlag, sqsQueueNameEnv)
+	viper.BindEnv(sqsConcurrencyFlag, sqsConcurrencyEnv)
 	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
 	viper.BindEnv(dynamoDBUserTableFlag, dynamoDBUserTableEnv)
+	viper.BindEnv(subscriberStoreFlag, subscriberStoreEnv)
+	viper.BindEnv(subscriberStoreDSNFlag, subscriberStoreDSNEnv)
+	viper.BindEnv(dynamoDBSegmentsFlag, dynamoDBSegmentsEnv)
+	viper.BindEnv(timezoneFlag, timezoneEnv)
+	viper.BindEnv(previewWeekdayFlag, previewWeekdayEnv)
 
 	var err error
 
@@ -102,112 +128,219 @@ func init() {
 		sugar.Fatalw("error creating DynamoDB client", "error", err.Error())
 	}
 
+	subscriberStore := viper.GetString(subscriberStoreFlag)
+	sugar.Infow("creating subscriber store", "store", subscriberStore)
+	subscribers, err := controller.OpenSubscriberStore(
+		context.Background(),
+		subscriberStore,
+		viper.GetString(subscriberStoreDSNFlag),
+	)
+	if err != nil {
+		sugar.Fatalw("error creating subscriber store", "store", subscriberStore, "error", err.Error())
+	}
+
 	c = controller.NewMagnifibot(
 		controller.SetConfig(&controller.MagnifibotConfig{
-			UserTable: viper.GetString(dynamoDBUserTableFlag),
-			QueueURL:  *queueURL.QueueUrl,
+			UserTable:        viper.GetString(dynamoDBUserTableFlag),
+			ScanSegments:     viper.GetInt(dynamoDBSegmentsFlag),
+			QueueURL:         *queueURL.QueueUrl,
+			QueueConcurrency: viper.GetInt(sqsConcurrencyFlag),
 		}),
 		controller.SetSQSClient(sqsClient),
 		controller.SetDynamoDBClient(dynamoClient),
+		controller.SetSubscriberStore(subscribers),
 	)
 
 	a = archimadrid.NewClient()
+
+	location, err = time.LoadLocation(viper.GetString(timezoneFlag))
+	if err != nil {
+		sugar.Fatalw("error loading timezone", "timezone", viper.GetString(timezoneFlag), "error", err.Error())
+	}
 }
 
 // Handler is our lambda handler invoked by the `lambda.Start` function call
 func Handler(ctx context.Context, event Event) error {
 	sugar.Infow("received cloudwatch event", "time", event.Time)
-	sugar.Debugw("getting gospel for day", "day", time.Now().Format("2006-01-02"))
-	day := time.Now()
 
-	gospel, err := a.GetGospel(ctx, day)
-	if err != nil {
-		sugar.Fatalw("error getting gospel", "error", err.Error())
-	}
-	firstLecture, err := a.GetFirstLecture(ctx, day)
-	if err != nil {
-		sugar.Fatalw("error getting first lecture", "error", err.Error())
-	}
-	psalm, err := a.GetPsalm(ctx, day)
-	if err != nil {
-		sugar.Fatalw("error getting psalm", "error", err.Error())
+	// The job runs every hour and only notifies the chats that chose to
+	// receive the Gospel at the current hour of the configured timezone
+	day := time.Now().In(location)
+	hour := day.Hour()
+
+	// Paused chats are skipped until their pause ends, and chats with a
+	// schedule only get the days it includes
+	weekday := day.Weekday()
+	filter := controller.ChatFilter{
+		DeliveryHour: &hour,
+		NotPausedAt:  &day,
+		Weekday:      &weekday,
 	}
-	secondLecture, err := a.GetSecondLecture(ctx, day)
+
+	// Most hours no chat is due, so the lectures are only got when some chat may
+	// get them. Until the rank of the day is known, it is taken as a feast, which
+	// includes every chat that may get the lectures.
+	due := filter
+	due.Feast = true
+	found, err := c.HasChats(ctx, due)
 	if err != nil {
-		sugar.Fatalw("error getting second lecture", "error", err.Error())
+		return err
 	}
 
-	magnificat := &archimadrid.Magnificat{
-		Day:          gospel.Day,
-		FirstLecture: firstLecture,
-		Psalm:        psalm,
-		Gosp:         gospel,
-	}
+	enqueued, failed := 0, map[string]error{}
+	var scanErr error
+	if found {
+		sugar.Debugw("getting gospel for day", "day", day.Format("2006-01-02"), "hour", hour)
+
+		// Without the Gospel there is nothing to deliver, but if only another lecture
+		// fails, every chat still gets the rest of the lectures of the day
+		magnificat, err := archimadrid.GetMagnificat(ctx, a, day)
+		if errors.Is(err, archimadrid.ErrMissingLectures) {
+			sugar.Warnw("delivering the lectures that could be got", "day", magnificat.Date, "error", err.Error())
+		} else if err != nil {
+			return fmt.Errorf("error getting lectures of %s: %w", day.Format("2006-01-02"), err)
+		}
 
-	if len(secondLecture.Content) > 0 {
-		magnificat.SecondLecture = secondLecture
+		filter.Feast = magnificat.Rank() >= archimadrid.FeastRank
+		enqueued, failed, scanErr = enqueue(ctx, magnificat, filter)
+	} else {
+		sugar.Debugw("no chats due at this hour", "day", day.Format("2006-01-02"), "hour", hour)
 	}
 
-	magnificatMessage, err := json.Marshal(magnificat)
-	if err != nil {
-		sugar.Fatalw("error converting message into JSON", "error", err.Error())
+	// The chats that opted in get the readings of the next Sunday in advance
+	if weekday == time.Weekday(viper.GetInt(previewWeekdayFlag)) {
+		previewed, previewFailed, previewErr := enqueuePreview(ctx, day, hour)
+		enqueued += previewed
+		for chatID, err := range previewFailed {
+			failed[chatID] = err
+		}
+		if scanErr == nil {
+			scanErr = previewErr
+		}
 	}
 
-	chatIDs, err := c.GetChatIDs(ctx)
-	if err != nil {
-		return err
+	if scanErr != nil || len(failed) > 0 {
+		reasons := []string{}
+		if scanErr != nil {
+			reasons = append(reasons, scanErr.Error())
+		}
+		for chatID, err := range failed {
+			reasons = append(reasons, fmt.Sprintf("chat %s: %s", chatID, err.Error()))
+		}
+		return fmt.Errorf(
+			"errors while sending messages to queue after enqueueing %d chats: %v",
+			enqueued,
+			strings.Join(reasons, "\n"),
+		)
 	}
 
-	wg := &sync.WaitGroup{}
-	errCh := make(chan error)
-	doneCh := make(chan struct{})
-	wg.Add(len(chatIDs))
-
-	for _, chatID := range chatIDs {
-		go func(e chan<- error, id string) {
-			defer wg.Done()
-			sugar.Debugw("sending message to queue", "queue_url", c.GetConfig().QueueURL, "chat_id", id)
-
-			messageID, err := c.SendMessageToQueue(ctx, id, string(magnificatMessage))
+	sugar.Debugw(
+		"messages stored in SQS queue",
+		"queue_url",
+		c.GetConfig().QueueURL,
+		"chats",
+		enqueued,
+		"hour",
+		hour,
+	)
 
-			if err != nil {
-				e <- fmt.Errorf("error sending message to queue: %w", err)
-				return
-			}
+	if hour == controller.DefaultDeliveryHour {
+		reportChurnedChats(ctx)
+	}
+	return nil
+}
 
-			sugar.Debugw(
-				"message stored in SQS queue",
-				"queue_url",
-				viper.GetString(c.GetConfig().QueueURL),
-				"message_id",
-				messageID,
-			)
+// enqueuePreview enqueues the readings of the next Sunday for the chats that
+// opted in to the preview and receive the Gospel at this hour
+func enqueuePreview(ctx context.Context, day time.Time, hour int) (int, map[string]error, error) {
+	filter := controller.ChatFilter{
+		DeliveryHour: &hour,
+		NotPausedAt:  &day,
+		Preview:      true,
+	}
+	found, err := c.HasChats(ctx, filter)
+	if err != nil || !found {
+		return 0, map[string]error{}, err
+	}
 
-		}(errCh, chatID)
+	days := (7 - int(day.Weekday())) % 7
+	if days == 0 {
+		days = 7
+	}
+	sunday := day.AddDate(0, 0, days)
+	sugar.Debugw("getting preview of next sunday", "sunday", sunday.Format("2006-01-02"))
+
+	preview, err := archimadrid.GetMagnificat(ctx, a, sunday)
+	if errors.Is(err, archimadrid.ErrMissingLectures) {
+		sugar.Warnw("previewing the lectures that could be got", "sunday", preview.Date, "error", err.Error())
+	} else if err != nil {
+		return 0, map[string]error{}, fmt.Errorf("error getting preview of %s: %w", sunday.Format("2006-01-02"), err)
 	}
+	preview.Preview = true
 
-	go func(d chan<- struct{}) {
-		wg.Wait()
-		close(d)
-	}(doneCh)
+	return enqueue(ctx, preview, filter)
+}
 
-	done := false
-	errors := []string{}
+// enqueue sends the Magnificat to the queue for every chat matching the filter,
+// returning the number of chats enqueued and the ones that failed
+func enqueue(
+	ctx context.Context,
+	magnificat *archimadrid.Magnificat,
+	filter controller.ChatFilter,
+) (int, map[string]error, error) {
+	message, err := controller.NewMessage(controller.MagnificatKind, magnificat)
+	if err != nil {
+		return 0, map[string]error{}, err
+	}
 
-	for !done {
-		select {
-		case err := <-errCh:
-			errors = append(errors, err.Error())
-		case <-doneCh:
-			done = true
+	// Every page of subscribers is enqueued as soon as it is read, while
+	// the remaining segments of the table are still being scanned
+	var mu sync.Mutex
+	failed := map[string]error{}
+	enqueued := 0
+	scanErr := c.ScanChatIDs(ctx, filter, func(chatIDs []string) error {
+		sugar.Debugw("sending messages to queue", "queue_url", c.GetConfig().QueueURL, "chats", len(chatIDs))
+		pageFailed := c.SendMessagesToQueue(ctx, chatIDs, message)
+
+		mu.Lock()
+		defer mu.Unlock()
+		enqueued += len(chatIDs) - len(pageFailed)
+		for chatID, err := range pageFailed {
+			failed[chatID] = err
 		}
+		return nil
+	})
+	return enqueued, failed, scanErr
+}
+
+// reportChurnedChats logs a summary of the subscriptions that have been deactivated
+// because their chats became unreachable, grouped by the reason given by Telegram.
+func reportChurnedChats(ctx context.Context) {
+	inactive, err := c.GetInactiveSubscriptions(ctx)
+	if err != nil {
+		sugar.Warnw("error getting inactive subscriptions", "error", err.Error())
+		return
 	}
 
-	if len(errors) > 0 {
-		return fmt.Errorf("errors while sending messages to queue: %v", strings.Join(errors, "\n"))
+	yesterday := time.Now().Add(-24 * time.Hour).Unix()
+	reasons := map[string]int{}
+	recent := []int64{}
+	for _, subscription := range inactive {
+		reasons[subscription.Reason]++
+		if subscription.Since >= yesterday {
+			recent = append(recent, subscription.ChatID)
+		}
 	}
 
-	return nil
+	sugar.Infow(
+		"churned chats report",
+		"total",
+		len(inactive),
+		"by_reason",
+		reasons,
+		"last_24h",
+		recent,
+	)
 }
 
 func main() {
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/controller"
+	"github.com/spf13/viper"
+)
+
+// maxListedBroadcasts is the number of broadcasts shown by /admin_broadcasts
+const maxListedBroadcasts = 5
+
+var (
+	dateRegex = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+	// chatKinds are the types of chat in which the bot can be
+	chatKinds = []string{"private", "group", "supergroup", "channel"}
+)
+
+// adminCommands are the commands used to operate the bot. They are hidden
+// from the help and the menu, and only the configured admins can run them.
+func adminCommands() []*api.Command {
+	return []*api.Command{
+		{
+			Names:       api.Localized{api.Spanish: "admin_stats"},
+			Description: api.Localized{api.Spanish: "Ver el número de suscriptores", api.English: "Show the number of subscribers"},
+			Hidden:      true,
+			Handler:     adminOnly(adminStats),
+		},
+		{
+			Names:       api.Localized{api.Spanish: "admin_broadcast"},
+			Description: api.Localized{api.Spanish: "Preparar un anuncio para los suscriptores", api.English: "Draft an announcement for the subscribers"},
+			Args: []api.Arg{
+				{
+					Name:     api.Localized{api.Spanish: "texto", api.English: "text"},
+					Required: true,
+					Rest:     true,
+				},
+			},
+			Hidden:  true,
+			Handler: adminOnly(adminBroadcast),
+		},
+		{
+			Names:       api.Localized{api.Spanish: "admin_broadcasts"},
+			Description: api.Localized{api.Spanish: "Ver los últimos anuncios y su entrega", api.English: "Show the last announcements and their delivery"},
+			Hidden:      true,
+			Handler:     adminOnly(adminBroadcasts),
+		},
+		{
+			Names:       api.Localized{api.Spanish: "admin_resend"},
+			Description: api.Localized{api.Spanish: "Reenviar las lecturas de un día a quien no las recibió", api.English: "Send again the lectures of a day to those who missed them"},
+			Args: []api.Arg{
+				{
+					Name:     api.Localized{api.Spanish: "fecha", api.English: "date"},
+					Required: true,
+					Pattern:  dateRegex,
+				},
+			},
+			Hidden:  true,
+			Handler: adminOnly(adminResend),
+		},
+		{
+			Names:       api.Localized{api.Spanish: "admin_health"},
+			Description: api.Localized{api.Spanish: "Comprobar los servicios de los que depende el bot", api.English: "Check the services the bot depends on"},
+			Hidden:      true,
+			Handler:     adminOnly(adminHealth),
+		},
+	}
+}
+
+// adminOnly only lets the configured admins run a command. Anyone else gets the
+// same answer as for an unknown command, so that the commands are not disclosed.
+func adminOnly(handler api.HandlerFunc) api.HandlerFunc {
+	return func(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+		if !admins[req.UserID] {
+			sugar.Warnw("unauthorized admin command", "chat_id", req.ChatID, "user_id", req.UserID, "command", req.Command.Name())
+			return req.Reply(fmt.Sprintf(
+				"%s\n\n%s",
+				req.Language.Choose("Lo siento, no conozco ese comando.", "Sorry, I don't know that command."),
+				router.Help(req.Language),
+			)), nil
+		}
+		sugar.Infow("admin command", "chat_id", req.ChatID, "user_id", req.UserID, "command", req.Command.Name())
+		return handler(ctx, req)
+	}
+}
+
+func adminStats(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	stats, err := c.GetSubscriptionStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := []string{}
+	for kind := range stats.Active {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	lines := []string{fmt.Sprintf("Active subscriptions: %d", stats.Total())}
+	for _, kind := range kinds {
+		lines = append(lines, fmt.Sprintf("- %s: %d", kind, stats.Active[kind]))
+	}
+	lines = append(lines,
+		fmt.Sprintf("Paused: %d", stats.Paused),
+		fmt.Sprintf("Inactive: %d", stats.Inactive),
+	)
+	return req.Reply(strings.Join(lines, "\n")), nil
+}
+
+// adminBroadcast stores a draft of an announcement and shows how the subscribers
+// will get it, along with the buttons to send or discard it. The audience can be
+// narrowed at the beginning of the text, as in «/admin_broadcast kind=group lang=en Hello».
+func adminBroadcast(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	audience, text, err := parseAudience(req.Args["texto"])
+	if err != nil {
+		sugar.Infow("invalid broadcast audience", "chat_id", req.ChatID, "error", err.Error())
+		return req.Reply(fmt.Sprintf(
+			"The kind must be one of %s, and the language %s or %s.",
+			strings.Join(chatKinds, ", "),
+			api.Spanish,
+			api.English,
+		)), nil
+	}
+	if text == "" {
+		return req.Reply("The announcement is empty."), nil
+	}
+
+	recipients := 0
+	var mu sync.Mutex
+	if err := c.ScanChatIDs(ctx, audience.Filter(), func(chatIDs []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		recipients += len(chatIDs)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	b := &controller.Broadcast{
+		ID:        controller.NewBroadcastID(time.Now()),
+		Text:      text,
+		Audience:  audience,
+		CreatedBy: req.UserID,
+	}
+	if err := c.CreateBroadcast(ctx, b); err != nil {
+		return nil, err
+	}
+
+	send, err := router.Button(req.ChatID, fmt.Sprintf("Send to %d chats", recipients), broadcastAction, "send:"+b.ID)
+	if err != nil {
+		return nil, err
+	}
+	discard, err := router.Button(req.ChatID, "Discard", broadcastAction, "cancel:"+b.ID)
+	if err != nil {
+		return nil, err
+	}
+	return req.Reply(fmt.Sprintf(
+		"Draft %s for %s (%d chats):\n\n%s",
+		b.ID,
+		audienceText(audience),
+		recipients,
+		b.Text,
+	)).WithKeyboard([]api.InlineKeyboardButton{send, discard}), nil
+}
+
+// confirmBroadcast sends or discards a draft once an admin taps one of its buttons
+func confirmBroadcast(ctx context.Context, cb *api.Callback) (*api.TelegramWebhookSendMessage, error) {
+	if !admins[cb.UserID] {
+		sugar.Warnw("unauthorized broadcast confirmation", "chat_id", cb.ChatID, "user_id", cb.UserID)
+		cb.Notification = cb.Language.Choose("Este botón ya no es válido.", "This button is no longer valid.")
+		return nil, nil
+	}
+
+	parts := strings.SplitN(cb.Arg, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("error parsing broadcast button %s", cb.Arg)
+	}
+	operation, id := parts[0], parts[1]
+
+	b, err := c.GetBroadcast(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return cb.Edit(fmt.Sprintf("Broadcast %s no longer exists.", id)), nil
+	}
+
+	if operation != "send" {
+		err := c.CancelBroadcast(ctx, id)
+		if errors.Is(err, controller.ErrBroadcastNotDraft) {
+			cb.Notification = fmt.Sprintf("Broadcast %s is already %s", id, b.Status)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return cb.Edit(fmt.Sprintf("Broadcast %s discarded.", id)), nil
+	}
+
+	// Marking the draft first keeps it from being sent twice by impatient taps
+	err = c.StartBroadcast(ctx, id)
+	if errors.Is(err, controller.ErrBroadcastNotDraft) {
+		cb.Notification = fmt.Sprintf("Broadcast %s is already %s", id, b.Status)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sugar.Infow("broadcast operation", "broadcast_id", id, "user_id", cb.UserID, "kind", b.Audience.Kind, "language", b.Audience.Language)
+
+	// Enqueueing every chat takes longer than the webhook may, so it is left to the
+	// SendBroadcast function, which tells the operator the result when it is done
+	payload := map[string]interface{}{"action": "broadcast", "broadcast_id": id, "chat_id": cb.ChatID}
+	if err := invokeSendBroadcast(ctx, payload); err != nil {
+		sugar.Errorw("error starting broadcast", "broadcast_id", id, "error", err.Error())
+		if abortErr := c.AbortBroadcast(ctx, id); abortErr != nil {
+			sugar.Warnw("error cancelling broadcast", "broadcast_id", id, "error", abortErr.Error())
+		}
+		return cb.Edit(fmt.Sprintf("Broadcast %s could not be started and was discarded: %s", id, err.Error())), nil
+	}
+	cb.Notification = "Sending..."
+	return cb.Edit(fmt.Sprintf("Broadcast %s is being enqueued. I will tell you when it is done.", id)), nil
+}
+
+// adminBroadcasts lists the last broadcasts with the statistics of their delivery
+func adminBroadcasts(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	broadcasts, err := c.ListBroadcasts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(broadcasts) == 0 {
+		return req.Reply("There are no broadcasts."), nil
+	}
+	if len(broadcasts) > maxListedBroadcasts {
+		broadcasts = broadcasts[:maxListedBroadcasts]
+	}
+
+	lines := []string{}
+	for _, b := range broadcasts {
+		lines = append(lines, fmt.Sprintf(
+			"%s · %s · %s · %s\nenqueued %d (%d failed), delivered %d, unreachable %d\n%s",
+			b.ID,
+			time.Unix(b.CreatedAt, 0).In(location).Format("02/01/2006 15:04"),
+			b.Status,
+			audienceText(b.Audience),
+			b.Enqueued,
+			b.EnqueueFailed,
+			b.Delivered,
+			b.Failed,
+			truncate(b.Text, 60),
+		))
+	}
+	return req.Reply(strings.Join(lines, "\n\n")), nil
+}
+
+// parseAudience reads the options that narrow the audience at the beginning of the
+// text of a broadcast, returning the audience and the rest of the text
+func parseAudience(text string) (controller.Audience, string, error) {
+	audience := controller.Audience{}
+	for {
+		text = strings.TrimSpace(text)
+		word := text
+		if i := strings.IndexAny(text, " \n\t"); i >= 0 {
+			word = text[:i]
+		}
+		option := strings.SplitN(word, "=", 2)
+		if len(option) != 2 {
+			return audience, text, nil
+		}
+
+		switch value := strings.ToLower(option[1]); strings.ToLower(option[0]) {
+		case "kind", "tipo":
+			if !contains(chatKinds, value) {
+				return audience, "", fmt.Errorf("error parsing audience: invalid kind %s", value)
+			}
+			audience.Kind = value
+		case "lang", "language", "idioma":
+			if api.Language(value) != api.Spanish && api.Language(value) != api.English {
+				return audience, "", fmt.Errorf("error parsing audience: invalid language %s", value)
+			}
+			audience.Language = value
+		default:
+			return audience, text, nil
+		}
+		text = strings.TrimPrefix(text, word)
+	}
+}
+
+func audienceText(audience controller.Audience) string {
+	parts := []string{}
+	if audience.Kind != "" {
+		parts = append(parts, "kind="+audience.Kind)
+	}
+	if audience.Language != "" {
+		parts = append(parts, "lang="+audience.Language)
+	}
+	if len(parts) == 0 {
+		return "every subscriber"
+	}
+	return strings.Join(parts, " ")
+}
+
+// truncate returns the beginning of a text, up to the given number of characters
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length]) + "…"
+}
+
+// adminResend enqueues the lectures of a day again for the chats that should have got
+// them. The delivery ledger skips the chats that already did, which is why only the
+// days still kept in the ledger can be sent again.
+func adminResend(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	now := time.Now().In(location)
+	day, err := time.ParseInLocation("2006-01-02", req.Args["fecha"], location)
+	if err != nil || day.After(now) || now.Sub(day) > controller.DefaultDeliveryTTL {
+		return req.Reply(fmt.Sprintf(
+			"The date must be one of the last %d days.",
+			int(controller.DefaultDeliveryTTL.Hours()/24),
+		)), nil
+	}
+
+	// Like broadcasts, enqueueing every chat takes longer than the webhook may
+	date := day.Format("2006-01-02")
+	payload := map[string]interface{}{"action": "resend", "date": date, "chat_id": req.ChatID}
+	if err := invokeSendBroadcast(ctx, payload); err != nil {
+		return nil, err
+	}
+	return req.Reply(fmt.Sprintf("Lectures of %s are being enqueued. I will tell you when it is done.", date)), nil
+}
+
+// adminHealth checks that the services the bot depends on answer
+func adminHealth(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{
+			name: "DynamoDB",
+			check: func() error {
+				_, err := c.GetSubscription(ctx, req.ChatID)
+				return err
+			},
+		},
+		{
+			name: "SQS",
+			check: func() error {
+				if c.GetConfig().QueueURL == "" {
+					return fmt.Errorf("queue not found")
+				}
+				return nil
+			},
+		},
+		{
+			name: "Archimadrid",
+			check: func() error {
+				_, err := a.GetGospel(ctx, time.Now().In(location))
+				return err
+			},
+		},
+	}
+
+	lines := []string{}
+	for _, check := range checks {
+		start := time.Now()
+		if err := check.check(); err != nil {
+			lines = append(lines, fmt.Sprintf("❌ %s: %s", check.name, err.Error()))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("✅ %s (%s)", check.name, time.Since(start).Round(time.Millisecond)))
+	}
+	return req.Reply(strings.Join(lines, "\n")), nil
+}
+
+// invokeSendBroadcast asks the SendBroadcast function to enqueue a message for many
+// chats, which it reports to the chat of the operator when it is done
+func invokeSendBroadcast(ctx context.Context, payload map[string]interface{}) error {
+	lambdaFunctionName := viper.GetString(sendBroadcastLambdaFlag)
+	statusCode, err := c.Invoke(ctx, lambdaFunctionName, payload)
+	if err != nil {
+		return fmt.Errorf("error invoking lambda function %s: %w", lambdaFunctionName, err)
+	}
+	sugar.Debugw("successfully invoked Lambda function", "function_name", lambdaFunctionName, "status_code", statusCode)
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/controller"
+)
+
+// Actions of the buttons of the inline keyboards
+const (
+	unsuscribeAction = "unsub"
+	profileAction    = "profile"
+	dayAction        = api.DayCallbackAction
+	broadcastAction  = "bcast"
+)
+
+// confirmUnsuscribe removes the subscription once the user confirms it. Anyone in a
+// group can tap the button, so the permissions are checked again.
+func confirmUnsuscribe(ctx context.Context, cb *api.Callback) (*api.TelegramWebhookSendMessage, error) {
+	allowed, err := canManage(ctx, cb.ChatID, cb.UserID, cb.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		cb.Notification = forbidden(cb.Language)
+		return nil, nil
+	}
+
+	if cb.Arg != "yes" {
+		return cb.Edit(cb.Language.Choose(
+			"De acuerdo, seguiré enviándote el Evangelio.",
+			"All right, I will keep sending you the Gospel.",
+		)), nil
+	}
+
+	sugar.Infow("unsuscribe operation", "chat_id", cb.ChatID)
+	if err := c.Unsuscribe(ctx, cb.ChatID); err != nil {
+		return cb.Edit(fmt.Sprintf(
+			cb.Language.Choose("Lo siento, no he podido darte de baja: %s", "Sorry, I could not unsubscribe you: %s"),
+			err.Error(),
+		)), nil
+	}
+	return cb.Edit(cb.Language.Choose(
+		"¡Hecho! Ya no te enviaré más el Evangelio.",
+		"Done! I will not send you the Gospel anymore.",
+	)), nil
+}
+
+// toggleProfile changes the lectures that the chat receives every day
+func toggleProfile(ctx context.Context, cb *api.Callback) (*api.TelegramWebhookSendMessage, error) {
+	allowed, err := canManage(ctx, cb.ChatID, cb.UserID, cb.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		cb.Notification = forbidden(cb.Language)
+		return nil, nil
+	}
+
+	prefs, err := c.GetPreferences(ctx, cb.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	if prefs == nil {
+		cb.Notification = cb.Language.Choose("No estás suscrito. Usa /suscribirme.", "You are not subscribed. Use /subscribe.")
+		return nil, nil
+	}
+
+	prefs.Profile = cb.Arg
+	if err := c.SetPreferences(ctx, cb.ChatID, prefs); err != nil {
+		return nil, err
+	}
+
+	keyboard, err := profileKeyboard(cb.ChatID, cb.Language, prefs.Profile)
+	if err != nil {
+		return nil, err
+	}
+	cb.Notification = cb.Language.Choose("¡Hecho!", "Done!")
+	return cb.Edit(profileText(cb.Language, prefs.Profile)).WithKeyboard(keyboard), nil
+}
+
+// showDay sends the lectures of the day of the button
+func showDay(ctx context.Context, cb *api.Callback) (*api.TelegramWebhookSendMessage, error) {
+	day, err := time.Parse("2006-01-02", cb.Arg)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing date %s: %w", cb.Arg, err)
+	}
+
+	sugar.Infow("on demand operation", "chat_id", cb.ChatID, "date", cb.Arg)
+	if err := invokeOnDemand(ctx, cb.ChatID, cb.Arg, cb.Language); err != nil {
+		return nil, err
+	}
+	cb.Notification = fmt.Sprintf(
+		cb.Language.Choose("Te envío las lecturas del %s", "Sending you the readings of %s"),
+		day.Format("02/01/2006"),
+	)
+	return nil, nil
+}
+
+func profileText(lang api.Language, profile string) string {
+	if profile == controller.GospelProfile {
+		return lang.Choose(
+			"Recibirás solo el Evangelio. ¿Quieres recibir también las lecturas y el salmo?",
+			"You will only get the Gospel. Do you want to get the readings and the psalm as well?",
+		)
+	}
+	return lang.Choose(
+		"Recibirás el Evangelio, las lecturas y el salmo. ¿Prefieres recibir solo el Evangelio?",
+		"You will get the Gospel, the readings and the psalm. Would you rather get only the Gospel?",
+	)
+}
+
+// profileKeyboard returns the button that switches to the other profile
+func profileKeyboard(chatID int64, lang api.Language, profile string) ([]api.InlineKeyboardButton, error) {
+	text, other := lang.Choose("Solo el Evangelio", "Only the Gospel"), controller.GospelProfile
+	if profile == controller.GospelProfile {
+		text, other = lang.Choose("Evangelio y lecturas", "Gospel and readings"), controller.FullProfile
+	}
+
+	button, err := router.Button(chatID, text, profileAction, other)
+	if err != nil {
+		return nil, err
+	}
+	return []api.InlineKeyboardButton{button}, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/controller"
+	"github.com/spf13/viper"
+)
+
+// newRouter registers all the commands understood by the bot
+func newRouter(botName string) (*api.Router, error) {
+	r := api.NewRouter(botName)
+	r.HandleText(handleText)
+	err := r.Register(
+		&api.Command{
+			Names:       api.Localized{api.Spanish: "start"},
+			Description: api.Localized{api.Spanish: "Empezar a usar el bot", api.English: "Start using the bot"},
+			Args: []api.Arg{
+				{
+					Name:    api.Localized{api.Spanish: "enlace", api.English: "link"},
+					Pattern: deepLinkRegex,
+				},
+			},
+			Handler: start(r),
+		},
+		&api.Command{
+			Names:       api.Localized{api.Spanish: "suscribirme", api.English: "subscribe"},
+			Description: api.Localized{api.Spanish: "Recibir el Evangelio cada día", api.English: "Get the Gospel every day"},
+			Handler:     suscribe,
+		},
+		&api.Command{
+			Names:       api.Localized{api.Spanish: "baja", api.English: "unsubscribe"},
+			Description: api.Localized{api.Spanish: "Dejar de recibir el Evangelio", api.English: "Stop getting the Gospel"},
+			Handler:     unsuscribe,
+		},
+		&api.Command{
+			Names:       api.Localized{api.Spanish: "obtener", api.English: "gospel"},
+			Description: api.Localized{api.Spanish: "Recibir ahora el Evangelio de hoy", api.English: "Get today's Gospel now"},
+			Handler:     onDemand,
+		},
+		&api.Command{
+			Names:       api.Localized{api.Spanish: "dias", api.English: "days"},
+			Description: api.Localized{api.Spanish: "Elegir qué días recibir el Evangelio", api.English: "Choose the days to get the Gospel"},
+			Args: []api.Arg{
+				{
+					Name:    api.Localized{api.Spanish: "dias", api.English: "days"},
+					Rest:    true,
+					Pattern: scheduleRegex,
+				},
+			},
+			Handler: schedule,
+		},
+		&api.Command{
+			Names:       api.Localized{api.Spanish: "avance", api.English: "preview"},
+			Description: api.Localized{api.Spanish: "Recibir con antelación las lecturas del domingo", api.English: "Get the Sunday readings in advance"},
+			Handler:     preview,
+		},
+		&api.Command{
+			Names:       api.Localized{api.Spanish: "pausar", api.English: "pause"},
+			Description: api.Localized{api.Spanish: "Dejar de recibir el Evangelio unos días", api.English: "Stop getting the Gospel for a few days"},
+			Args: []api.Arg{
+				{
+					Name:     api.Localized{api.Spanish: "duracion", api.English: "duration"},
+					Required: true,
+					Rest:     true,
+					Pattern:  pauseRegex,
+				},
+			},
+			Handler: pause,
+		},
+		&api.Command{
+			Names:       api.Localized{api.Spanish: "reanudar", api.English: "resume"},
+			Description: api.Localized{api.Spanish: "Volver a recibir el Evangelio tras una pausa", api.English: "Get the Gospel again after a pause"},
+			Handler:     resume,
+		},
+		&api.Command{
+			Names:       api.Localized{api.Spanish: "estado", api.English: "status"},
+			Description: api.Localized{api.Spanish: "Ver el estado de tu suscripción", api.English: "Show the status of your subscription"},
+			Handler:     status,
+		},
+		&api.Command{
+			Names:       api.Localized{api.Spanish: "permisos", api.English: "permissions"},
+			Description: api.Localized{api.Spanish: "Elegir quién gestiona la suscripción del grupo", api.English: "Choose who manages the subscription of the group"},
+			Args: []api.Arg{
+				{
+					Name:    api.Localized{api.Spanish: "quien", api.English: "who"},
+					Pattern: policyRegex,
+				},
+			},
+			Handler: permissions,
+		},
+		&api.Command{
+			Names:       api.Localized{api.Spanish: "cancelar", api.English: "cancel"},
+			Description: api.Localized{api.Spanish: "Cancelar la conversación en curso", api.English: "Cancel the ongoing conversation"},
+			Handler:     cancel,
+		},
+		&api.Command{
+			Names:       api.Localized{api.Spanish: "ayuda", api.English: "help"},
+			Description: api.Localized{api.Spanish: "Ver los comandos disponibles", api.English: "List the available commands"},
+			Handler: func(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+				return req.Reply(r.Help(req.Language)), nil
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Register(adminCommands()...); err != nil {
+		return nil, err
+	}
+
+	for action, handler := range map[string]api.CallbackHandlerFunc{
+		unsuscribeAction: confirmUnsuscribe,
+		profileAction:    toggleProfile,
+		dayAction:        showDay,
+		broadcastAction:  confirmBroadcast,
+	} {
+		if err := r.HandleCallback(action, handler); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func suscribe(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	allowed, err := canManage(ctx, req.ChatID, req.UserID, req.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return req.Reply(forbidden(req.Language)), nil
+	}
+
+	sugar.Infow("suscribe operation", "chat_id", req.ChatID)
+	err = c.Suscribe(ctx, subscription(req, nil))
+	if errors.Is(err, controller.ErrAlreadySubscribed) {
+		return req.Reply(req.Language.Choose(
+			"¡Ya estás suscrito! Usa /estado para ver tu suscripción.",
+			"You are already subscribed! Use /status to check your subscription.",
+		)), nil
+	}
+	if err != nil {
+		return req.Reply(fmt.Sprintf(
+			req.Language.Choose("Lo siento, no he podido suscribirte: %s", "Sorry, I could not subscribe you: %s"),
+			err.Error(),
+		)), nil
+	}
+
+	// Reactivated subscriptions keep the profile they had
+	prefs, err := c.GetPreferences(ctx, req.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	if prefs == nil {
+		prefs = controller.DefaultPreferences()
+	}
+
+	keyboard, err := profileKeyboard(req.ChatID, req.Language, prefs.Profile)
+	if err != nil {
+		return nil, err
+	}
+	return req.Reply(fmt.Sprintf(
+		"%s\n\n%s",
+		req.Language.Choose("¡Hecho! Te enviaré el Evangelio cada día.", "Done! I will send you the Gospel every day."),
+		profileText(req.Language, prefs.Profile),
+	)).WithKeyboard(keyboard), nil
+}
+
+// unsuscribe asks for confirmation before removing the subscription
+func unsuscribe(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	allowed, err := canManage(ctx, req.ChatID, req.UserID, req.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return req.Reply(forbidden(req.Language)), nil
+	}
+
+	yes, err := router.Button(req.ChatID, req.Language.Choose("Sí, darme de baja", "Yes, unsubscribe"), unsuscribeAction, "yes")
+	if err != nil {
+		return nil, err
+	}
+	no, err := router.Button(req.ChatID, req.Language.Choose("No", "No"), unsuscribeAction, "no")
+	if err != nil {
+		return nil, err
+	}
+	return req.Reply(req.Language.Choose(
+		"¿Seguro que quieres dejar de recibir el Evangelio?",
+		"Are you sure you want to stop getting the Gospel?",
+	)).WithKeyboard([]api.InlineKeyboardButton{yes, no}), nil
+}
+
+func onDemand(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	sugar.Infow("on demand operation", "chat_id", req.ChatID)
+	return nil, invokeOnDemand(ctx, req.ChatID, "", req.Language)
+}
+
+// invokeOnDemand asks the OnDemand function to send the lectures of a day to a
+// chat. An empty date means today. The language is the one of the user, in
+// case the lectures can't be got and the function has to apologize.
+func invokeOnDemand(ctx context.Context, chatID int64, date string, lang api.Language) error {
+	lambdaFunctionName := viper.GetString(onDemandLambdaFlag)
+	payload := map[string]interface{}{"chat_id": chatID, "action": "on_demand", "language": lang}
+	if date != "" {
+		payload["date"] = date
+	}
+
+	statusCode, err := c.Invoke(ctx, lambdaFunctionName, payload)
+	if err != nil {
+		return fmt.Errorf("error invoking lambda function %s: %w", lambdaFunctionName, err)
+	}
+
+	sugar.Debugw(
+		"successfully invoked Lambda function",
+		"function_name",
+		lambdaFunctionName,
+		"chat_id",
+		chatID,
+		"status_code",
+		statusCode,
+	)
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/archimadrid"
+	"github.com/igvaquero18/magnifibot/controller"
+)
+
+// inlineCacheTime is the number of seconds that Telegram may cache the
+// results of an inline query
+const inlineCacheTime = 300
+
+// inlineMessageLength is the maximum number of characters of the message that an
+// inline result sends, which Telegram counts once the MarkdownV2 markup is parsed
+const inlineMessageLength = 4096
+
+// inlineReading is a lecture that can be shared through an inline query
+type inlineReading struct {
+	part     controller.DeliveryPart
+	title    api.Localized
+	keywords []string
+	get      func(a archimadrid.Archimadrid, ctx context.Context, day time.Time) (*archimadrid.Gospel, error)
+}
+
+var inlineReadings = []inlineReading{
+	{
+		part:     controller.GospelPart,
+		title:    api.Localized{api.Spanish: "Evangelio", api.English: "Gospel"},
+		keywords: []string{"evangelio", "gospel"},
+		get:      archimadrid.Archimadrid.GetGospel,
+	},
+	{
+		part:     controller.FirstLecturePart,
+		title:    api.Localized{api.Spanish: "Primera lectura", api.English: "First reading"},
+		keywords: []string{"lectura", "primera", "reading", "first"},
+		get:      archimadrid.Archimadrid.GetFirstLecture,
+	},
+	{
+		part:     controller.PsalmPart,
+		title:    api.Localized{api.Spanish: "Salmo", api.English: "Psalm"},
+		keywords: []string{"salmo", "psalm"},
+		get:      archimadrid.Archimadrid.GetPsalm,
+	},
+	{
+		part:     controller.SecondLecturePart,
+		title:    api.Localized{api.Spanish: "Segunda lectura", api.English: "Second reading"},
+		keywords: []string{"segunda", "second"},
+		get:      archimadrid.Archimadrid.GetSecondLecture,
+	},
+}
+
+// inlineDays maps the words accepted in an inline query to the offset in days from today
+var inlineDays = map[string]int{
+	"hoy":       0,
+	"today":     0,
+	"mañana":    1,
+	"manana":    1,
+	"tomorrow":  1,
+	"ayer":      -1,
+	"yesterday": -1,
+}
+
+// handleInlineQuery answers queries like "@magnifibot_bot salmo mañana" with the
+// lectures that match the words of the query, or with all of today's if none do
+func handleInlineQuery(ctx context.Context, query *api.InlineQuery) (Response, error) {
+	lang := api.ToLanguage(query.From.LanguageCode)
+	readings, offset := parseInlineQuery(query.Query)
+	day := time.Now().In(location).AddDate(0, 0, offset)
+	date := day.Format("2006-01-02")
+
+	results := []api.InlineQueryResultArticle{}
+	for _, reading := range readings {
+		lecture, err := reading.get(a, ctx, day)
+		if err != nil {
+			sugar.Warnw("error getting lecture for inline query", "part", reading.part, "date", date, "error", err.Error())
+			continue
+		}
+		if lecture == nil || lecture.Content == "" {
+			continue
+		}
+		lecture = fitInlineMessage(lecture)
+
+		magnificat := &archimadrid.Magnificat{Date: date, Day: lecture.Day}
+		description := lecture.Reference
+		switch reading.part {
+		case controller.GospelPart:
+			magnificat.Gosp = lecture
+		case controller.FirstLecturePart:
+			magnificat.FirstLecture = lecture
+		case controller.PsalmPart:
+			magnificat.Psalm = lecture
+			description = lecture.Title
+		case controller.SecondLecturePart:
+			magnificat.SecondLecture = lecture
+		}
+
+		header, _ := controller.RenderPart(magnificat, controller.HeaderPart, lang)
+		text, _ := controller.RenderPart(magnificat, reading.part, lang)
+		results = append(results, api.NewInlineQueryResultArticle(
+			fmt.Sprintf("%s-%s", date, reading.part),
+			fmt.Sprintf("%s · %s", reading.title.In(lang), day.Format("02/01/2006")),
+			description,
+			fmt.Sprintf("%s\n\n%s", header, text),
+			"MarkdownV2",
+		))
+	}
+
+	sugar.Debugw("answering inline query", "query", query.Query, "date", date, "results", len(results))
+	return createTelegramResponse(http.StatusOK, query.Answer(results, inlineCacheTime))
+}
+
+// fitInlineMessage returns a copy of the lecture whose content is truncated so that the
+// message of its inline result, with the day and the headings, keeps within the length
+// allowed by Telegram. The content is cut before it is escaped, so that no escape
+// sequence nor entity of the message is broken.
+func fitInlineMessage(lecture *archimadrid.Gospel) *archimadrid.Gospel {
+	// The day, the reference and the title are joined by five line breaks
+	length := inlineMessageLength - utf8.RuneCountInString(lecture.Day+lecture.Reference+lecture.Title) - 5
+	// truncate appends an ellipsis to the text it cuts
+	length--
+	if length < 0 {
+		length = 0
+	}
+
+	fitted := *lecture
+	fitted.Content = truncate(lecture.Content, length)
+	return &fitted
+}
+
+// parseInlineQuery returns the lectures requested in the query, matching the words
+// as prefixes so that results show up while typing, and the offset of the day
+func parseInlineQuery(query string) ([]inlineReading, int) {
+	selected, offset := map[controller.DeliveryPart]bool{}, 0
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		if days, ok := inlineDays[word]; ok {
+			offset = days
+			continue
+		}
+		for _, reading := range inlineReadings {
+			for _, keyword := range reading.keywords {
+				if strings.HasPrefix(keyword, word) {
+					selected[reading.part] = true
+				}
+			}
+		}
+	}
+
+	if len(selected) == 0 {
+		return inlineReadings, offset
+	}
+
+	readings := []inlineReading{}
+	for _, reading := range inlineReadings {
+		if selected[reading.part] {
+			readings = append(readings, reading)
+		}
+	}
+	return readings, offset
+}
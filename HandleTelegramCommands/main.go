@@ -6,45 +6,87 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"regexp"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/archimadrid"
 	"github.com/igvaquero18/magnifibot/controller"
 	"github.com/igvaquero18/magnifibot/utils"
+	"github.com/mymmrac/telego"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
 const (
-	magnifibotNameEnv    = "MAGNIFIBOT_NAME"
-	awsRegionEnv         = "MAGNIFIBOT_AWS_REGION"
-	sqsEndpointEnv       = "MAGNIFIBOT_SQS_ENDPOINT"
-	verboseEnv           = "MAGNIFIBOT_VERBOSE"
-	dynamoDBEndpointEnv  = "MAGNIFIBOT_DYNAMODB_ENDPOINT"
-	dynamoDBUserTableEnv = "MAGNIFIBOT_DYNAMODB_USER_TABLE"
-	lambdaEndpointEnv    = "MAGNIFIBOT_LAMBDA_ENDPOINT"
-	onDemandLambdaEnv    = "MAGNIFIBOT_ON_DEMAND_LAMBDA_FUNCTION_NAME"
-	magnifibotTimeoutEnv = "MAGNIFIBOT_TIMEOUT"
+	magnifibotNameEnv            = "MAGNIFIBOT_NAME"
+	awsRegionEnv                 = "MAGNIFIBOT_AWS_REGION"
+	sqsEndpointEnv               = "MAGNIFIBOT_SQS_ENDPOINT"
+	sqsQueueNameEnv              = "MAGNIFIBOT_SQS_QUEUE_NAME"
+	verboseEnv                   = "MAGNIFIBOT_VERBOSE"
+	dynamoDBEndpointEnv          = "MAGNIFIBOT_DYNAMODB_ENDPOINT"
+	dynamoDBUserTableEnv         = "MAGNIFIBOT_DYNAMODB_USER_TABLE"
+	subscriberStoreEnv           = "MAGNIFIBOT_SUBSCRIBER_STORE"
+	subscriberStoreDSNEnv        = "MAGNIFIBOT_SUBSCRIBER_STORE_DSN"
+	dynamoDBConversationTableEnv = "MAGNIFIBOT_DYNAMODB_CONVERSATION_TABLE"
+	dynamoDBUpdateTableEnv       = "MAGNIFIBOT_DYNAMODB_UPDATE_TABLE"
+	dynamoDBBroadcastTableEnv    = "MAGNIFIBOT_DYNAMODB_BROADCAST_TABLE"
+	lambdaEndpointEnv            = "MAGNIFIBOT_LAMBDA_ENDPOINT"
+	onDemandLambdaEnv            = "MAGNIFIBOT_ON_DEMAND_LAMBDA_FUNCTION_NAME"
+	sendBroadcastLambdaEnv       = "MAGNIFIBOT_SEND_BROADCAST_LAMBDA_FUNCTION_NAME"
+	magnifibotTimeoutEnv         = "MAGNIFIBOT_TIMEOUT"
+	telegramTokenEnv             = "MAGNIFIBOT_TELEGRAM_BOT_TOKEN"
+	callbackSecretEnv            = "MAGNIFIBOT_TELEGRAM_CALLBACK_SECRET"
+	webhookSecretEnv             = "MAGNIFIBOT_TELEGRAM_WEBHOOK_SECRET"
+	webhookURLEnv                = "MAGNIFIBOT_TELEGRAM_WEBHOOK_URL"
+	pollingOffsetFileEnv         = "MAGNIFIBOT_TELEGRAM_POLLING_OFFSET_FILE"
+	adminUserIDsEnv              = "MAGNIFIBOT_ADMIN_USER_IDS"
 )
 
 const (
-	magnifibotNameFlag    = "name"
-	awsRegionFlag         = "aws.region"
-	sqsEndpointFlag       = "aws.sqs.endpoint"
-	verboseFlag           = "logging.verbose"
-	dynamoDBEndpointFlag  = "aws.dynamodb.endpoint"
-	dynamoDBUserTableFlag = "aws.dynamodb.tables.user"
-	lambdaEndpointFlag    = "aws.lambda.endpoint"
-	onDemandLambdaFlag    = "aws.lambda.on_demand.function_name"
-	magnifibotTimeoutFlag = "timeout"
+	magnifibotNameFlag            = "name"
+	awsRegionFlag                 = "aws.region"
+	sqsEndpointFlag               = "aws.sqs.endpoint"
+	sqsQueueNameFlag              = "aws.sqs.queue_name"
+	verboseFlag                   = "logging.verbose"
+	dynamoDBEndpointFlag          = "aws.dynamodb.endpoint"
+	dynamoDBUserTableFlag         = "aws.dynamodb.tables.user"
+	subscriberStoreFlag           = "subscribers.store"
+	subscriberStoreDSNFlag        = "subscribers.dsn"
+	dynamoDBConversationTableFlag = "aws.dynamodb.tables.conversation"
+	dynamoDBUpdateTableFlag       = "aws.dynamodb.tables.update"
+	dynamoDBBroadcastTableFlag    = "aws.dynamodb.tables.broadcast"
+	lambdaEndpointFlag            = "aws.lambda.endpoint"
+	onDemandLambdaFlag            = "aws.lambda.on_demand.function_name"
+	sendBroadcastLambdaFlag       = "aws.lambda.send_broadcast.function_name"
+	magnifibotTimeoutFlag         = "timeout"
+	telegramTokenFlag             = "telegram.bot_token"
+	callbackSecretFlag            = "telegram.callback_secret"
+	webhookSecretFlag             = "telegram.webhook.secret"
+	webhookURLFlag                = "telegram.webhook.url"
+	pollingOffsetFileFlag         = "telegram.polling.offset_file"
+	adminUserIDsFlag              = "admin.user_ids"
 )
 
 var (
-	c     controller.MagnifibotInterface
-	sugar *zap.SugaredLogger
+	c        controller.MagnifibotInterface
+	a        archimadrid.Archimadrid
+	updates  controller.UpdateStore
+	router   *api.Router
+	location *time.Location
+	sugar    *zap.SugaredLogger
+
+	// admins are the Telegram users allowed to run the admin commands
+	admins map[int64]bool
+
+	// secretToken is the token Telegram sends in every request to the webhook
+	secretToken string
 )
 
 // Response is of type APIGatewayProxyResponse since we're leveraging the
@@ -57,21 +99,47 @@ func init() {
 	viper.SetDefault(magnifibotNameFlag, "magnifibot_bot")
 	viper.SetDefault(awsRegionFlag, "eu-west-3")
 	viper.SetDefault(sqsEndpointFlag, "")
+	viper.SetDefault(sqsQueueNameFlag, controller.DefaultQueueName)
 	viper.SetDefault(verboseFlag, false)
 	viper.SetDefault(dynamoDBEndpointFlag, "")
 	viper.SetDefault(dynamoDBUserTableFlag, controller.DefaultUserTable)
+	viper.SetDefault(subscriberStoreFlag, controller.DefaultSubscriberStore)
+	viper.SetDefault(subscriberStoreDSNFlag, "")
+	viper.SetDefault(dynamoDBConversationTableFlag, controller.DefaultConversationTable)
+	viper.SetDefault(dynamoDBUpdateTableFlag, controller.DefaultUpdateTable)
+	viper.SetDefault(dynamoDBBroadcastTableFlag, controller.DefaultBroadcastTable)
 	viper.SetDefault(lambdaEndpointFlag, "")
 	viper.SetDefault(onDemandLambdaFlag, "")
+	viper.SetDefault(sendBroadcastLambdaFlag, "")
 	viper.SetDefault(magnifibotTimeoutFlag, utils.DefaultTimeout)
+	viper.SetDefault(telegramTokenFlag, "")
+	viper.SetDefault(callbackSecretFlag, "")
+	viper.SetDefault(webhookSecretFlag, "")
+	viper.SetDefault(webhookURLFlag, "")
+	viper.SetDefault(pollingOffsetFileFlag, controller.DefaultOffsetFile)
+	viper.SetDefault(adminUserIDsFlag, "")
 	viper.BindEnv(magnifibotNameFlag, magnifibotNameEnv)
 	viper.BindEnv(awsRegionFlag, awsRegionEnv)
 	viper.BindEnv(sqsEndpointFlag, sqsEndpointEnv)
+	viper.BindEnv(sqsQueueNameFlag, sqsQueueNameEnv)
 	viper.BindEnv(verboseFlag, verboseEnv)
 	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
 	viper.BindEnv(dynamoDBUserTableFlag, dynamoDBUserTableEnv)
+	viper.BindEnv(subscriberStoreFlag, subscriberStoreEnv)
+	viper.BindEnv(subscriberStoreDSNFlag, subscriberStoreDSNEnv)
+	viper.BindEnv(dynamoDBConversationTableFlag, dynamoDBConversationTableEnv)
+	viper.BindEnv(dynamoDBUpdateTableFlag, dynamoDBUpdateTableEnv)
+	viper.BindEnv(dynamoDBBroadcastTableFlag, dynamoDBBroadcastTableEnv)
 	viper.BindEnv(lambdaEndpointFlag, lambdaEndpointEnv)
 	viper.BindEnv(onDemandLambdaFlag, onDemandLambdaEnv)
+	viper.BindEnv(sendBroadcastLambdaFlag, sendBroadcastLambdaEnv)
 	viper.BindEnv(magnifibotTimeoutFlag, magnifibotTimeoutEnv)
+	viper.BindEnv(telegramTokenFlag, telegramTokenEnv)
+	viper.BindEnv(callbackSecretFlag, callbackSecretEnv)
+	viper.BindEnv(webhookSecretFlag, webhookSecretEnv)
+	viper.BindEnv(webhookURLFlag, webhookURLEnv)
+	viper.BindEnv(pollingOffsetFileFlag, pollingOffsetFileEnv)
+	viper.BindEnv(adminUserIDsFlag, adminUserIDsEnv)
 
 	var err error
 
@@ -90,6 +158,36 @@ func init() {
 		sugar.Fatalw("error creating DynamoDB client", "error", err.Error())
 	}
 
+	subscriberStore := viper.GetString(subscriberStoreFlag)
+	sugar.Infow("creating subscriber store", "store", subscriberStore)
+	subscribers, err := controller.OpenSubscriberStore(
+		context.Background(),
+		subscriberStore,
+		viper.GetString(subscriberStoreDSNFlag),
+	)
+	if err != nil {
+		sugar.Fatalw("error creating subscriber store", "store", subscriberStore, "error", err.Error())
+	}
+
+	sqsEndpoint := viper.GetString(sqsEndpointFlag)
+	sugar.Infow("creating SQS client", "region", region, "url", sqsEndpoint)
+	sqsClient, err := utils.InitSQSClient(region, sqsEndpoint)
+	if err != nil {
+		sugar.Fatalw("error creating SQS client", "error", err.Error())
+	}
+
+	// The queue is only used by the admin commands, so the bot keeps
+	// answering the rest of the commands when it can't be found
+	queueURL := ""
+	queue, err := sqsClient.GetQueueUrl(context.TODO(), &sqs.GetQueueUrlInput{
+		QueueName: aws.String(viper.GetString(sqsQueueNameFlag)),
+	})
+	if err != nil {
+		sugar.Warnw("error getting the queue URL", "queue_name", viper.GetString(sqsQueueNameFlag), "error", err.Error())
+	} else {
+		queueURL = aws.ToString(queue.QueueUrl)
+	}
+
 	lambdaEndpoint := viper.GetString(lambdaEndpointFlag)
 	sugar.Infow("creating lambda client", "region", region, "url", lambdaEndpoint)
 	lambdaClient, err := utils.InitLambdaClient(region, lambdaEndpoint)
@@ -97,36 +195,81 @@ func init() {
 		sugar.Fatalw("error creating Lambda client", "error", err.Error())
 	}
 
+	sugar.Info("creating telegram bot client")
+	bot, err := telego.NewBot(viper.GetString(telegramTokenFlag), telego.WithLogger(sugar))
+	if err != nil {
+		sugar.Fatalw("error creating telegram bot client", "error", err.Error())
+	}
+
 	c = controller.NewMagnifibot(
 		controller.SetDynamoDBClient(dynamoClient),
+		controller.SetSubscriberStore(subscribers),
+		controller.SetSQSClient(sqsClient),
 		controller.SetLambdaClient(lambdaClient),
+		controller.SetTelegramClient(bot),
 		controller.SetConfig(&controller.MagnifibotConfig{
-			UserTable: viper.GetString(dynamoDBUserTableFlag),
+			QueueURL:          queueURL,
+			UserTable:         viper.GetString(dynamoDBUserTableFlag),
+			ConversationTable: viper.GetString(dynamoDBConversationTableFlag),
+			UpdateTable:       viper.GetString(dynamoDBUpdateTableFlag),
+			BroadcastTable:    viper.GetString(dynamoDBBroadcastTableFlag),
 		}),
 	)
+
+	updates = c
+
+	ids, err := utils.ParseIDs(viper.GetString(adminUserIDsFlag))
+	if err != nil {
+		sugar.Fatalw("error parsing the admin user IDs", "error", err.Error())
+	}
+	admins = map[int64]bool{}
+	for _, id := range ids {
+		admins[id] = true
+	}
+	a = archimadrid.NewClient()
+
+	location, err = time.LoadLocation(controller.DefaultTimezone)
+	if err != nil {
+		sugar.Fatalw("error loading timezone", "timezone", controller.DefaultTimezone, "error", err.Error())
+	}
+
+	router, err = newRouter(viper.GetString(magnifibotNameFlag))
+	if err != nil {
+		sugar.Fatalw("error registering commands", "error", err.Error())
+	}
+	router.SetCallbackSecret(utils.DeriveSecret(
+		viper.GetString(callbackSecretFlag),
+		viper.GetString(telegramTokenFlag),
+		api.CallbackSecretPurpose,
+	))
+	secretToken = api.WebhookSecretToken(utils.DeriveSecret(
+		viper.GetString(webhookSecretFlag),
+		viper.GetString(telegramTokenFlag),
+		api.WebhookSecretPurpose,
+	))
 }
 
 // Handler is our lambda handler invoked by the `lambda.Start` function call
 func Handler(request events.APIGatewayProxyRequest) (Response, error) {
-	ctx, cancel, err := utils.InitContextWithTimeout(viper.GetString(magnifibotTimeoutFlag))
-	if err != nil {
-		sugar.Warnw(
-			"invalid timeout setting, using default",
-			"timeout",
-			viper.GetString(magnifibotTimeoutFlag),
-			"default",
-			utils.DefaultTimeout,
-			"error",
-			err.Error(),
-		)
-	}
+	ctx, cancel := newContext()
 	defer cancel()
-	sugar.Infow("received request", "method", request.HTTPMethod, "body", request.Body)
-	var update api.Update
 	headers := map[string]string{
 		"Content-Type": "application/json",
 	}
 
+	// Requests that do not come from Telegram are rejected before reaching any other service
+	if !api.ValidSecretToken(request.Headers, secretToken) {
+		sugar.Warnw("rejected request with invalid secret token", "method", request.HTTPMethod)
+		return Response{
+			Body:       "Unauthorized",
+			StatusCode: http.StatusUnauthorized,
+			Headers:    headers,
+		}, nil
+	}
+
+	sugar.Infow("received request", "method", request.HTTPMethod, "body", request.Body)
+	var update api.Update
+
 	if err := json.Unmarshal([]byte(request.Body), &update); err != nil {
 		return Response{
 			Body:       fmt.Sprintf("Invalid Telegram message: %s", err.Error()),
@@ -135,6 +278,25 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 		}, nil
 	}
 
+	return handleUpdate(ctx, &update)
+}
+
+// handleUpdate dispatches an update of Telegram, received either through the
+// webhook or by polling, and returns the method to call in response to it
+func handleUpdate(ctx context.Context, update *api.Update) (Response, error) {
+	// Telegram delivers an update again when the response is slow, so that
+	// commands that take long, like /obtener, could otherwise run twice
+	claimed, err := updates.ClaimUpdate(ctx, update.UpdateID)
+	if err != nil {
+		sugar.Warnw("error claiming update, handling it anyway", "update_id", update.UpdateID, "error", err.Error())
+	} else if !claimed {
+		sugar.Infow("ignoring update already handled", "update_id", update.UpdateID)
+		return Response{
+			Body:       "success",
+			StatusCode: http.StatusOK,
+		}, nil
+	}
+
 	if update.Message != nil {
 		sugar.Infow(
 			"received message",
@@ -143,15 +305,21 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 			"text",
 			update.Message.Text,
 		)
-		return handleCommand(
-			ctx,
-			`/(\w*)@?\w*`,
-			update.Message.Text,
-			update.Message.Chat.Type,
-			update.Message.Chat.ID,
-			update.Message.From.ID,
-			update.Message.Date,
-		)
+		req := &api.Request{
+			Text:     update.Message.Text,
+			ChatID:   update.Message.Chat.ID,
+			UserID:   update.Message.From.ID,
+			Date:     update.Message.Date,
+			Kind:     update.Message.Chat.Type,
+			Title:    update.Message.Chat.Title,
+			Username: update.Message.Chat.Username,
+			Language: api.ToLanguage(update.Message.From.LanguageCode),
+		}
+		// Anonymous administrators send their messages on behalf of the group
+		if sender := update.Message.SenderChat; sender != nil && sender.ID == req.ChatID {
+			req.UserID = sender.ID
+		}
+		return handleRequest(ctx, req)
 	}
 
 	if update.ChannelPost != nil {
@@ -162,123 +330,146 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 			"text",
 			update.ChannelPost.Text,
 		)
-		return handleCommand(
-			ctx,
-			fmt.Sprintf(`/(\w*)@%s`, viper.GetString(magnifibotNameFlag)),
-			update.ChannelPost.Text,
-			update.ChannelPost.SenderChat.Type,
-			update.ChannelPost.Chat.ID,
-			update.ChannelPost.SenderChat.ID,
-			update.ChannelPost.Date,
+		return handleRequest(ctx, &api.Request{
+			Text:     update.ChannelPost.Text,
+			Channel:  true,
+			ChatID:   update.ChannelPost.Chat.ID,
+			UserID:   update.ChannelPost.SenderChat.ID,
+			Date:     update.ChannelPost.Date,
+			Kind:     update.ChannelPost.SenderChat.Type,
+			Title:    update.ChannelPost.Chat.Title,
+			Username: update.ChannelPost.Chat.Username,
+			Language: api.DefaultLanguage,
+		})
+	}
+
+	if update.CallbackQuery != nil {
+		sugar.Infow(
+			"received callback query",
+			"callback_id",
+			update.CallbackQuery.ID,
+			"data",
+			update.CallbackQuery.Data,
 		)
+		cb := &api.Callback{
+			ID:       update.CallbackQuery.ID,
+			UserID:   update.CallbackQuery.From.ID,
+			Language: api.ToLanguage(update.CallbackQuery.From.LanguageCode),
+			Data:     update.CallbackQuery.Data,
+		}
+		if update.CallbackQuery.Message != nil {
+			cb.ChatID = update.CallbackQuery.Message.Chat.ID
+			cb.MessageID = update.CallbackQuery.Message.MessageID
+			cb.Kind = update.CallbackQuery.Message.Chat.Type
+		}
+		return handleCallback(ctx, cb)
 	}
+
+	if update.InlineQuery != nil {
+		sugar.Infow(
+			"received inline query",
+			"inline_query_id",
+			update.InlineQuery.ID,
+			"query",
+			update.InlineQuery.Query,
+		)
+		return handleInlineQuery(ctx, update.InlineQuery)
+	}
+
 	return Response{
 		StatusCode: http.StatusBadRequest,
-		Headers:    headers,
-		Body:       "Invalid request",
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: "Invalid request",
 	}, nil
 }
 
-func handleCommand(ctx context.Context, regexPattern, body, kind string, chatID, userID, date int64) (Response, error) {
-	re := regexp.MustCompile(regexPattern)
-	submatch := re.FindStringSubmatch(body)
-	if submatch != nil {
-		command := api.ToCommand(submatch[1])
-		if !command.IsValid() {
-			return createTelegramResponse(
-				http.StatusOK,
-				chatID,
-				fmt.Sprintf(
-					"Lo siento, solo acepto los siguientes comandos: %s",
-					strings.Join(api.GetValidCommandsString(), ", "),
-				),
-			)
-		}
-		if api.ValidCommands["suscribe"] == command {
-			sugar.Infow("suscribe operation", "chat_id", chatID)
-			if err := c.Suscribe(ctx, chatID, userID, date, kind); err != nil {
-				return createTelegramResponse(
-					http.StatusOK,
-					chatID,
-					fmt.Sprintf("Lo siento, no he podido suscribirte: %s", err.Error()),
-				)
-			}
-			return createTelegramResponse(
-				http.StatusOK,
-				chatID,
-				"¡Hecho! Te enviaré el Evangelio cada día.",
-			)
-		} else if api.ValidCommands["unsuscribe"] == command {
-			sugar.Infow("unsuscribe operation", "chat_id", chatID)
-			if err := c.Unsuscribe(ctx, chatID); err != nil {
-				return createTelegramResponse(
-					http.StatusOK,
-					chatID,
-					fmt.Sprintf("Lo siento, no he podido darte de baja: %s", err.Error()),
-				)
-			}
-			return createTelegramResponse(
-				http.StatusOK,
-				chatID,
-				"¡Hecho! Ya no te enviaré más el Evangelio.",
-			)
-		}
-
-		sugar.Infow("on demand operation", "chat_id", chatID)
-		lambdaFunctionName := viper.GetString(onDemandLambdaFlag)
-		statusCode, err := c.Invoke(
-			ctx,
-			lambdaFunctionName,
-			map[string]interface{}{"chat_id": chatID, "action": "on_demand"},
+// newContext returns the context in which an update is handled, which is
+// cancelled after the configured timeout
+func newContext() (context.Context, context.CancelFunc) {
+	ctx, cancel, err := utils.InitContextWithTimeout(viper.GetString(magnifibotTimeoutFlag))
+	if err != nil {
+		sugar.Warnw(
+			"invalid timeout setting, using default",
+			"timeout",
+			viper.GetString(magnifibotTimeoutFlag),
+			"default",
+			utils.DefaultTimeout,
+			"error",
+			err.Error(),
 		)
-		if err != nil {
-			sugar.Errorw(
-				"error invoking lambda function",
-				"function_name",
-				lambdaFunctionName,
-				"error",
-				err.Error(),
-			)
-			return createTelegramResponse(http.StatusOK, chatID, "Lo siento, algo ha fallado")
-		}
+	}
+	return ctx, cancel
+}
 
-		sugar.Debugw(
-			"successfully invoked Lambda function",
-			"function_name",
-			lambdaFunctionName,
-			"chat_id",
-			chatID,
-			"status_code",
-			statusCode,
+func handleRequest(ctx context.Context, req *api.Request) (Response, error) {
+	req.Language = chatLanguage(ctx, req.ChatID, req.Language)
+
+	message, err := router.Route(ctx, req)
+	if err != nil {
+		sugar.Errorw("error handling command", "chat_id", req.ChatID, "text", req.Text, "error", err.Error())
+		return createTelegramResponse(
+			http.StatusOK,
+			req.Reply(req.Language.Choose("Lo siento, algo ha fallado", "Sorry, something went wrong")),
 		)
+	}
 
+	if message == nil {
 		return Response{
 			Body:       "success",
 			StatusCode: http.StatusOK,
 		}, nil
 	}
-	return createTelegramResponse(
-		http.StatusOK,
-		chatID,
-		"Lo siento, solo acepto comandos de Telegram.",
-	)
+	return createTelegramResponse(http.StatusOK, message)
+}
+
+// handleCallback dispatches a callback query and answers it, so that the
+// Telegram client stops showing the button as loading
+func handleCallback(ctx context.Context, cb *api.Callback) (Response, error) {
+	cb.Language = chatLanguage(ctx, cb.ChatID, cb.Language)
+
+	message, err := router.RouteCallback(ctx, cb)
+	if err != nil {
+		sugar.Errorw("error handling callback query", "chat_id", cb.ChatID, "data", cb.Data, "error", err.Error())
+		cb.Notification = cb.Language.Choose("Lo siento, algo ha fallado", "Sorry, something went wrong")
+		message = nil
+	}
+
+	if err := c.AnswerCallback(ctx, cb.ID, cb.Notification); err != nil {
+		sugar.Warnw("error answering callback query", "callback_id", cb.ID, "error", err.Error())
+	}
+
+	if message == nil {
+		return Response{
+			Body:       "success",
+			StatusCode: http.StatusOK,
+		}, nil
+	}
+	return createTelegramResponse(http.StatusOK, message)
 }
 
-func createTelegramResponse(
-	status int,
-	chatID int64,
-	text string,
-) (Response, error) {
-	t := api.TelegramWebhookSendMessage{
-		ChatID: chatID,
-		Text:   text,
-		Method: "sendMessage",
+// chatLanguage returns the language chosen by the chat, or the given one
+// if the chat has not chosen any
+func chatLanguage(ctx context.Context, chatID int64, lang api.Language) api.Language {
+	prefs, err := c.GetPreferences(ctx, chatID)
+	if err != nil {
+		sugar.Warnw("error getting preferences, using the language of the user", "chat_id", chatID, "error", err.Error())
+		return lang
+	}
+	if prefs == nil {
+		return lang
 	}
+	return api.Language(prefs.Language)
+}
+
+// createTelegramResponse returns the method called in response to the webhook request
+func createTelegramResponse(status int, message interface{}) (Response, error) {
 	headers := map[string]string{
 		"Content-Type": "application/json",
 	}
 
-	body, err := json.Marshal(t)
+	body, err := json.Marshal(message)
 	if err != nil {
 		return Response{
 			Body:       fmt.Sprintf("error when marshalling response: %s", err.Error()),
@@ -295,5 +486,27 @@ func createTelegramResponse(
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "setup" {
+		if err := setup(); err != nil {
+			sugar.Fatalw("error setting up the bot", "error", err.Error())
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrated, err := c.MigrateSubscriptions(context.Background())
+		if err != nil {
+			sugar.Fatalw("error migrating subscriptions", "migrated", migrated, "error", err.Error())
+		}
+		sugar.Infow("successfully migrated subscriptions", "migrated", migrated, "version", controller.SubscriptionVersion)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "poll" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := poll(ctx); err != nil {
+			sugar.Fatalw("error polling for updates", "error", err.Error())
+		}
+		return
+	}
 	lambda.Start(Handler)
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/controller"
+)
+
+const onboardingFlow = "onboarding"
+
+// Steps of the onboarding, in the order in which they are asked
+const (
+	languageStep = "language"
+	profileStep  = "profile"
+	hourStep     = "hour"
+)
+
+var (
+	deepLinkRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	hourRegex     = regexp.MustCompile(`^([01]?[0-9]|2[0-3])(:00)?h?$`)
+)
+
+var (
+	languageAnswers = map[string]api.Language{
+		"es":      api.Spanish,
+		"español": api.Spanish,
+		"espanol": api.Spanish,
+		"spanish": api.Spanish,
+		"en":      api.English,
+		"english": api.English,
+		"inglés":  api.English,
+		"ingles":  api.English,
+	}
+	profileAnswers = map[string]string{
+		"evangelio": controller.GospelProfile,
+		"gospel":    controller.GospelProfile,
+		"completo":  controller.FullProfile,
+		"todo":      controller.FullProfile,
+		"full":      controller.FullProfile,
+		"all":       controller.FullProfile,
+	}
+)
+
+// start welcomes the users. The deep links like https://t.me/magnifibot_bot?start=suscribirme
+// run the command given as payload, while any other payload is only logged as the source of
+// the visit. Private chats are then guided through the onboarding.
+func start(r *api.Router) api.HandlerFunc {
+	return func(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+		payload := req.Args["enlace"]
+		sugar.Infow("start operation", "chat_id", req.ChatID, "payload", payload)
+
+		if cmd, ok := r.Lookup(payload); ok && cmd != req.Command && !hasRequiredArgs(cmd) {
+			req.Command = cmd
+			return cmd.Handler(ctx, req)
+		}
+
+		welcome := req.Language.Choose(
+			"¡Bienvenido a Magnifibot! Cada día te enviaré el Evangelio y las lecturas de la misa.",
+			"Welcome to Magnifibot! Every day I will send you the Gospel and the readings of the Mass.",
+		)
+		if req.Kind != "private" {
+			return req.Reply(fmt.Sprintf("%s\n\n%s", welcome, r.Help(req.Language))), nil
+		}
+
+		if err := c.SaveConversation(ctx, req.ChatID, &controller.Conversation{
+			Flow: onboardingFlow,
+			Step: languageStep,
+			Data: map[string]string{},
+		}); err != nil {
+			return nil, err
+		}
+		return req.Reply(fmt.Sprintf(
+			"%s\n\n%s",
+			welcome,
+			"¿En qué idioma prefieres que te hable? Responde «español» o «english».\n"+
+				"Which language do you prefer? Answer «español» or «english».",
+		)), nil
+	}
+}
+
+// cancel abandons the ongoing conversation, if any
+func cancel(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	if err := c.EndConversation(ctx, req.ChatID); err != nil {
+		return nil, err
+	}
+	return req.Reply(req.Language.Choose(
+		"De acuerdo, lo dejamos aquí. Escribe /start cuando quieras volver a empezar.",
+		"All right, let's leave it here. Send /start whenever you want to start again.",
+	)), nil
+}
+
+// handleText handles the messages that are not commands, which are the
+// answers to the questions of the ongoing conversation
+func handleText(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	conversation, err := c.GetConversation(ctx, req.ChatID)
+	if err != nil {
+		return nil, err
+	}
+
+	if conversation == nil || conversation.Flow != onboardingFlow {
+		return req.Reply(req.Language.Choose(
+			"Lo siento, solo acepto comandos de Telegram. Escribe /ayuda para verlos.",
+			"Sorry, I only understand Telegram commands. Send /help to list them.",
+		)), nil
+	}
+
+	if language, ok := conversation.Data[languageStep]; ok {
+		req.Language = api.Language(language)
+	}
+	return onboard(ctx, req, conversation)
+}
+
+// onboard records the answer to the current step of the onboarding and asks
+// the next question, subscribing the chat once all of them are answered
+func onboard(ctx context.Context, req *api.Request, conversation *controller.Conversation) (*api.TelegramWebhookSendMessage, error) {
+	answer := strings.ToLower(strings.TrimSpace(req.Text))
+
+	switch conversation.Step {
+	case languageStep:
+		language, ok := languageAnswers[answer]
+		if !ok {
+			return req.Reply("Responde «español» o «english», por favor.\nPlease answer «español» or «english»."), nil
+		}
+		req.Language = language
+		conversation.Data[languageStep] = string(language)
+		conversation.Step = profileStep
+		if err := c.SaveConversation(ctx, req.ChatID, conversation); err != nil {
+			return nil, err
+		}
+		return req.Reply(req.Language.Choose(
+			"¿Qué quieres recibir cada día? Responde «evangelio» para recibir solo el Evangelio "+
+				"o «completo» para recibir también las lecturas y el salmo.",
+			"What do you want to get every day? Answer «gospel» to get only the Gospel "+
+				"or «full» to get the readings and the psalm as well.",
+		)), nil
+
+	case profileStep:
+		profile, ok := profileAnswers[answer]
+		if !ok {
+			return req.Reply(req.Language.Choose(
+				"Responde «evangelio» o «completo», por favor.",
+				"Please answer «gospel» or «full».",
+			)), nil
+		}
+		conversation.Data[profileStep] = profile
+		conversation.Step = hourStep
+		if err := c.SaveConversation(ctx, req.ChatID, conversation); err != nil {
+			return nil, err
+		}
+		return req.Reply(fmt.Sprintf(req.Language.Choose(
+			"¿A qué hora quieres recibirlo? Responde con una hora entre 0 y 23 (hora de España), por ejemplo %d.",
+			"At what time do you want to get it? Answer with an hour between 0 and 23 (Spain time), for example %d.",
+		), controller.DefaultDeliveryHour)), nil
+
+	case hourStep:
+		submatch := hourRegex.FindStringSubmatch(answer)
+		if submatch == nil {
+			return req.Reply(req.Language.Choose(
+				"Responde con una hora entre 0 y 23, por favor.",
+				"Please answer with an hour between 0 and 23.",
+			)), nil
+		}
+		hour, _ := strconv.Atoi(submatch[1])
+		return finishOnboarding(ctx, req, &controller.Preferences{
+			Language:     conversation.Data[languageStep],
+			Profile:      conversation.Data[profileStep],
+			DeliveryHour: hour,
+		})
+	}
+
+	sugar.Warnw("unknown onboarding step, restarting", "chat_id", req.ChatID, "step", conversation.Step)
+	if err := c.EndConversation(ctx, req.ChatID); err != nil {
+		return nil, err
+	}
+	return req.Reply(req.Language.Choose(
+		"Lo siento, me he perdido. Escribe /start para volver a empezar.",
+		"Sorry, I got lost. Send /start to start again.",
+	)), nil
+}
+
+func finishOnboarding(ctx context.Context, req *api.Request, prefs *controller.Preferences) (*api.TelegramWebhookSendMessage, error) {
+	sugar.Infow("suscribe operation", "chat_id", req.ChatID, "preferences", prefs)
+	// Chats that were already subscribed keep their record, so the answers are
+	// stored as their new preferences
+	if err := c.Suscribe(ctx, subscription(req, prefs)); err != nil && !errors.Is(err, controller.ErrAlreadySubscribed) {
+		return nil, err
+	}
+	if err := c.SetPreferences(ctx, req.ChatID, prefs); err != nil {
+		return nil, err
+	}
+	if err := c.EndConversation(ctx, req.ChatID); err != nil {
+		return nil, err
+	}
+
+	readings := req.Language.Choose("el Evangelio", "the Gospel")
+	if prefs.Profile == controller.FullProfile {
+		readings = req.Language.Choose("el Evangelio y las lecturas", "the Gospel and the readings")
+	}
+	return req.Reply(fmt.Sprintf(req.Language.Choose(
+		"¡Hecho! Te enviaré %s cada día a las %d:00 (hora de España). Escribe /ayuda para ver todo lo que puedo hacer.",
+		"Done! I will send you %s every day at %d:00 (Spain time). Send /help to see everything I can do.",
+	), readings, prefs.DeliveryHour)), nil
+}
+
+func hasRequiredArgs(cmd *api.Command) bool {
+	for _, arg := range cmd.Args {
+		if arg.Required {
+			return true
+		}
+	}
+	return false
+}
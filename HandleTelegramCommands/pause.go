@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/igvaquero18/magnifibot/api"
+)
+
+// maxPauseDays is the longest pause allowed, after which the chat should rather unsubscribe
+const maxPauseDays = 365
+
+var (
+	pauseRegex     = regexp.MustCompile(`(?i)^(\d{1,3})\s*(d|días|dias|days?)$|^(hasta|until)\s+(\d{4}-\d{2}-\d{2})$`)
+	pauseDaysRegex = regexp.MustCompile(`(?i)^(\d{1,3})\s*(d|días|dias|days?)$`)
+)
+
+// pause stops the daily delivery for a number of days, as in «/pausar 7d», or until a
+// date, as in «/pausar hasta 2026-11-01». The delivery resumes at the beginning of the
+// last day, in the timezone of the bot.
+func pause(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	allowed, err := canManage(ctx, req.ChatID, req.UserID, req.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return req.Reply(forbidden(req.Language)), nil
+	}
+
+	until, err := parsePause(req.Args["duracion"], time.Now().In(location))
+	if err != nil {
+		return req.Reply(fmt.Sprintf(req.Language.Choose(
+			"Lo siento, la pausa debe durar entre 1 y %d días. Por ejemplo: «/pausar 7d» o «/pausar hasta 2026-11-01».",
+			"Sorry, the pause must last between 1 and %d days. For example: «/pause 7d» or «/pause until 2026-11-01».",
+		), maxPauseDays)), nil
+	}
+
+	sub, err := c.GetSubscription(ctx, req.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return req.Reply(req.Language.Choose(
+			"No estás suscrito. Usa /suscribirme para recibir el Evangelio cada día.",
+			"You are not subscribed. Use /subscribe to get the Gospel every day.",
+		)), nil
+	}
+
+	sugar.Infow("pause operation", "chat_id", req.ChatID, "until", until)
+	if err := c.Pause(ctx, req.ChatID, until); err != nil {
+		return nil, err
+	}
+	return req.Reply(fmt.Sprintf(req.Language.Choose(
+		"De acuerdo, no te enviaré el Evangelio hasta el %s. Usa /reanudar si quieres recibirlo antes.",
+		"All right, I will not send you the Gospel until %s. Use /resume to get it earlier.",
+	), until.Format("02/01/2006"))), nil
+}
+
+// resume restarts the daily delivery before the pause ends
+func resume(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	allowed, err := canManage(ctx, req.ChatID, req.UserID, req.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return req.Reply(forbidden(req.Language)), nil
+	}
+
+	sub, err := c.GetSubscription(ctx, req.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return req.Reply(req.Language.Choose(
+			"No estás suscrito. Usa /suscribirme para recibir el Evangelio cada día.",
+			"You are not subscribed. Use /subscribe to get the Gospel every day.",
+		)), nil
+	}
+	if !sub.Paused(time.Now()) {
+		return req.Reply(req.Language.Choose(
+			"Tu suscripción no está pausada.",
+			"Your subscription is not paused.",
+		)), nil
+	}
+
+	sugar.Infow("resume operation", "chat_id", req.ChatID)
+	if err := c.Resume(ctx, req.ChatID); err != nil {
+		return nil, err
+	}
+	return req.Reply(req.Language.Choose(
+		"¡Bienvenido de vuelta! Volveré a enviarte el Evangelio a la hora de siempre.",
+		"Welcome back! I will send you the Gospel again at the usual time.",
+	)), nil
+}
+
+// parsePause returns the beginning of the day in which the pause given as
+// argument ends, which must be between 1 and maxPauseDays days from now
+func parsePause(arg string, now time.Time) (time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var until time.Time
+	if submatch := pauseDaysRegex.FindStringSubmatch(arg); submatch != nil {
+		days, _ := strconv.Atoi(submatch[1])
+		until = today.AddDate(0, 0, days)
+	} else {
+		submatch := pauseRegex.FindStringSubmatch(arg)
+		if submatch == nil {
+			return time.Time{}, fmt.Errorf("error parsing pause %s", arg)
+		}
+		date, err := time.ParseInLocation("2006-01-02", submatch[4], now.Location())
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error parsing date %s: %w", submatch[4], err)
+		}
+		until = date
+	}
+
+	if !until.After(today) || until.After(today.AddDate(0, 0, maxPauseDays)) {
+		return time.Time{}, fmt.Errorf("error parsing pause %s: out of range", arg)
+	}
+	return until, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/controller"
+)
+
+var (
+	policyRegex = regexp.MustCompile(`(?i)^(admins|administradores|todos|anyone|everyone)$`)
+
+	policyAnswers = map[string]string{
+		"admins":          controller.AdminsPolicy,
+		"administradores": controller.AdminsPolicy,
+		"todos":           controller.AnyonePolicy,
+		"anyone":          controller.AnyonePolicy,
+		"everyone":        controller.AnyonePolicy,
+	}
+)
+
+// isAdmin tells whether the user administers the chat. Private chats are administered
+// by their only user, and the messages sent on behalf of the chat itself, like the posts
+// of a channel or those of the anonymous administrators of a group, can only come from
+// its administrators.
+func isAdmin(ctx context.Context, chatID, userID int64, kind string) (bool, error) {
+	if kind == "private" || userID == chatID {
+		return true, nil
+	}
+	return c.IsChatAdmin(ctx, chatID, userID)
+}
+
+// canManage tells whether the user may change the subscription of the chat,
+// according to the policy chosen by the chat
+func canManage(ctx context.Context, chatID, userID int64, kind string) (bool, error) {
+	if kind != "private" && userID != chatID {
+		policy, err := c.GetPolicy(ctx, chatID)
+		if err != nil {
+			return false, err
+		}
+		if policy == controller.AnyonePolicy {
+			return true, nil
+		}
+	}
+	return isAdmin(ctx, chatID, userID, kind)
+}
+
+func forbidden(lang api.Language) string {
+	return lang.Choose(
+		"Lo siento, solo los administradores pueden cambiar la suscripción de este chat.",
+		"Sorry, only the administrators can change the subscription of this chat.",
+	)
+}
+
+// permissions shows or changes who may change the subscription of a group or channel,
+// which only its administrators can do
+func permissions(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	if req.Kind == "private" {
+		return req.Reply(req.Language.Choose(
+			"Este comando solo tiene sentido en grupos y canales.",
+			"This command only makes sense in groups and channels.",
+		)), nil
+	}
+
+	answer, ok := req.Args["quien"]
+	if !ok {
+		policy, err := c.GetPolicy(ctx, req.ChatID)
+		if err != nil {
+			return nil, err
+		}
+		return req.Reply(policyText(req.Language, policy)), nil
+	}
+
+	admin, err := isAdmin(ctx, req.ChatID, req.UserID, req.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		return req.Reply(req.Language.Choose(
+			"Lo siento, solo los administradores pueden cambiar quién gestiona la suscripción.",
+			"Sorry, only the administrators can change who manages the subscription.",
+		)), nil
+	}
+
+	policy := policyAnswers[strings.ToLower(answer)]
+	sugar.Infow("set policy operation", "chat_id", req.ChatID, "policy", policy)
+	if err := c.SetPolicy(ctx, req.ChatID, policy); err != nil {
+		return req.Reply(req.Language.Choose(
+			"Lo siento, no he podido cambiarlo. ¿Está el chat suscrito? Usa /suscribirme primero.",
+			"Sorry, I could not change it. Is the chat subscribed? Use /subscribe first.",
+		)), nil
+	}
+	return req.Reply(policyText(req.Language, policy)), nil
+}
+
+func policyText(lang api.Language, policy string) string {
+	if policy == controller.AnyonePolicy {
+		return lang.Choose(
+			"Cualquier miembro puede cambiar la suscripción de este chat. Usa «/permisos admins» para restringirlo a los administradores.",
+			"Any member can change the subscription of this chat. Use «/permissions admins» to restrict it to the administrators.",
+		)
+	}
+	return lang.Choose(
+		"Solo los administradores pueden cambiar la suscripción de este chat. Usa «/permisos todos» para permitírselo a cualquier miembro.",
+		"Only the administrators can change the subscription of this chat. Use «/permissions anyone» to allow any member.",
+	)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/controller"
+	"github.com/spf13/viper"
+)
+
+const (
+	// pollTimeout is the time in seconds that Telegram holds a request for
+	// updates while there are none
+	pollTimeout = 50
+
+	// pollRetryDelay is the time to wait before asking again for updates after an error
+	pollRetryDelay = 5 * time.Second
+)
+
+// poll gets the updates from Telegram instead of receiving them through the webhook,
+// and handles them one by one until the context is done. The offset of the next
+// update is saved after handling each one, so that a restarted poller goes on
+// where the previous one stopped. It is run with the poll argument.
+func poll(ctx context.Context) error {
+	offsets := controller.NewFileOffsetStore(viper.GetString(pollingOffsetFileFlag))
+	offset, err := offsets.GetOffset(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Telegram does not return any update while a webhook is set
+	sugar.Infow("deleting webhook to poll for updates")
+	if err := callBotAPI(ctx, "deleteWebhook", map[string]interface{}{}, nil); err != nil {
+		return err
+	}
+
+	sugar.Infow("polling for updates", "offset", offset, "allowed_updates", allowedUpdates)
+	for {
+		var received []api.Update
+		err := callBotAPI(ctx, "getUpdates", map[string]interface{}{
+			"offset":          offset,
+			"timeout":         pollTimeout,
+			"allowed_updates": allowedUpdates,
+		}, &received)
+		if ctx.Err() != nil {
+			sugar.Infow("stopped polling for updates", "offset", offset)
+			return nil
+		}
+		if err != nil {
+			sugar.Warnw("error getting updates", "offset", offset, "error", err.Error())
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollRetryDelay):
+			}
+			continue
+		}
+
+		for i := range received {
+			// An update is handled to the end even if the poller is being stopped
+			handlePolledUpdate(&received[i])
+
+			offset = received[i].UpdateID + 1
+			if err := offsets.SetOffset(context.Background(), offset); err != nil {
+				// The updates handled again after a restart are ignored for a while anyway
+				sugar.Errorw("error saving offset", "offset", offset, "error", err.Error())
+			}
+			if ctx.Err() != nil {
+				sugar.Infow("stopped polling for updates", "offset", offset)
+				return nil
+			}
+		}
+	}
+}
+
+// handlePolledUpdate handles an update and calls the method returned in response,
+// which the webhook would have returned to Telegram instead
+func handlePolledUpdate(update *api.Update) {
+	ctx, cancel := newContext()
+	defer cancel()
+
+	response, err := handleUpdate(ctx, update)
+	if err != nil {
+		sugar.Errorw("error handling update", "update_id", update.UpdateID, "error", err.Error())
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		sugar.Warnw("update not handled", "update_id", update.UpdateID, "status", response.StatusCode, "body", response.Body)
+		return
+	}
+
+	var method struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal([]byte(response.Body), &method); err != nil || method.Method == "" {
+		return
+	}
+	if err := callBotAPI(ctx, method.Method, json.RawMessage(response.Body), nil); err != nil {
+		sugar.Errorw("error answering update", "update_id", update.UpdateID, "method", method.Method, "error", err.Error())
+	}
+}
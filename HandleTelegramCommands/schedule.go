@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/controller"
+)
+
+var (
+	scheduleRegex = regexp.MustCompile(`(?i)^[a-záéíóú ,]+$`)
+
+	weekdayAnswers = map[string]time.Weekday{
+		"domingo":   time.Sunday,
+		"domingos":  time.Sunday,
+		"sunday":    time.Sunday,
+		"sundays":   time.Sunday,
+		"lunes":     time.Monday,
+		"monday":    time.Monday,
+		"mondays":   time.Monday,
+		"martes":    time.Tuesday,
+		"tuesday":   time.Tuesday,
+		"tuesdays":  time.Tuesday,
+		"miércoles": time.Wednesday,
+		"miercoles": time.Wednesday,
+		"wednesday": time.Wednesday,
+		"jueves":    time.Thursday,
+		"thursday":  time.Thursday,
+		"thursdays": time.Thursday,
+		"viernes":   time.Friday,
+		"friday":    time.Friday,
+		"fridays":   time.Friday,
+		"sábado":    time.Saturday,
+		"sábados":   time.Saturday,
+		"sabado":    time.Saturday,
+		"sabados":   time.Saturday,
+		"saturday":  time.Saturday,
+		"saturdays": time.Saturday,
+	}
+	feastAnswers    = []string{"fiestas", "festivos", "solemnidades", "feasts", "solemnities"}
+	everyDayAnswers = []string{"todos", "diario", "all", "everyday", "daily"}
+
+	weekdayNames = map[time.Weekday]api.Localized{
+		time.Sunday:    {api.Spanish: "domingo", api.English: "Sunday"},
+		time.Monday:    {api.Spanish: "lunes", api.English: "Monday"},
+		time.Tuesday:   {api.Spanish: "martes", api.English: "Tuesday"},
+		time.Wednesday: {api.Spanish: "miércoles", api.English: "Wednesday"},
+		time.Thursday:  {api.Spanish: "jueves", api.English: "Thursday"},
+		time.Friday:    {api.Spanish: "viernes", api.English: "Friday"},
+		time.Saturday:  {api.Spanish: "sábado", api.English: "Saturday"},
+	}
+)
+
+// schedule shows or changes the days in which the chat receives the Gospel, as in
+// «/dias domingo festivos» for Sundays, solemnities and feasts, or «/dias todos»
+func schedule(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	sub, err := c.GetSubscription(ctx, req.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return req.Reply(req.Language.Choose(
+			"No estás suscrito. Usa /suscribirme para recibir el Evangelio cada día.",
+			"You are not subscribed. Use /subscribe to get the Gospel every day.",
+		)), nil
+	}
+
+	answer, ok := req.Args["dias"]
+	if !ok {
+		return req.Reply(scheduleText(req.Language, sub.Schedule) + "\n\n" + req.Language.Choose(
+			"Para cambiarlo, escribe por ejemplo «/dias domingo festivos» o «/dias todos».",
+			"To change it, send for example «/days sunday feasts» or «/days all».",
+		)), nil
+	}
+
+	allowed, err := canManage(ctx, req.ChatID, req.UserID, req.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return req.Reply(forbidden(req.Language)), nil
+	}
+
+	chosen, ok := parseSchedule(answer)
+	if !ok {
+		return req.Reply(req.Language.Choose(
+			"Lo siento, no lo he entendido. Escribe los días de la semana y «festivos» si quieres recibirlo en las fiestas y solemnidades, "+
+				"por ejemplo «/dias domingo festivos», o «/dias todos».",
+			"Sorry, I did not understand it. Send the days of the week and «feasts» if you want to get it on feasts and solemnities, "+
+				"for example «/days sunday feasts», or «/days all».",
+		)), nil
+	}
+
+	sugar.Infow("set schedule operation", "chat_id", req.ChatID, "schedule", chosen)
+	if err := c.SetSchedule(ctx, req.ChatID, chosen); err != nil {
+		return nil, err
+	}
+	return req.Reply(scheduleText(req.Language, chosen)), nil
+}
+
+// preview toggles whether the chat gets the readings of the next Sunday in advance,
+// for those who prepare them during the week
+func preview(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	sub, err := c.GetSubscription(ctx, req.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return req.Reply(req.Language.Choose(
+			"No estás suscrito. Usa /suscribirme para recibir el Evangelio cada día.",
+			"You are not subscribed. Use /subscribe to get the Gospel every day.",
+		)), nil
+	}
+
+	allowed, err := canManage(ctx, req.ChatID, req.UserID, req.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return req.Reply(forbidden(req.Language)), nil
+	}
+
+	sugar.Infow("set preview operation", "chat_id", req.ChatID, "preview", !sub.Preview)
+	if err := c.SetPreview(ctx, req.ChatID, !sub.Preview); err != nil {
+		return nil, err
+	}
+	return req.Reply(previewText(req.Language, !sub.Preview)), nil
+}
+
+func previewText(lang api.Language, enabled bool) string {
+	if enabled {
+		return lang.Choose(
+			"Cada semana recibirás con antelación las lecturas del domingo. Usa /avance de nuevo para dejar de recibirlas.",
+			"Every week you will get the Sunday readings in advance. Use /preview again to stop getting them.",
+		)
+	}
+	return lang.Choose(
+		"No recibirás las lecturas del domingo con antelación. Usa /avance para recibirlas.",
+		"You will not get the Sunday readings in advance. Use /preview to get them.",
+	)
+}
+
+// parseSchedule reads the days listed in the answer, returning a nil
+// schedule when the chat wants to receive the Gospel every day
+func parseSchedule(answer string) (*controller.Schedule, bool) {
+	chosen := &controller.Schedule{Weekdays: []time.Weekday{}}
+	for _, word := range strings.FieldsFunc(strings.ToLower(answer), func(r rune) bool { return r == ' ' || r == ',' }) {
+		if weekday, ok := weekdayAnswers[word]; ok {
+			chosen.Weekdays = append(chosen.Weekdays, weekday)
+			continue
+		}
+		switch {
+		case contains(feastAnswers, word):
+			chosen.Feasts = true
+		case contains(everyDayAnswers, word):
+			return nil, true
+		case word == "y" || word == "and":
+		default:
+			return nil, false
+		}
+	}
+
+	if len(chosen.Weekdays) == 0 && !chosen.Feasts {
+		return nil, false
+	}
+	return chosen, true
+}
+
+func scheduleText(lang api.Language, chosen *controller.Schedule) string {
+	if chosen.EveryDay() {
+		return lang.Choose("Recibes el Evangelio todos los días.", "You get the Gospel every day.")
+	}
+
+	days := []string{}
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if chosen.Includes(weekday, false) {
+			days = append(days, weekdayNames[weekday].In(lang))
+		}
+	}
+	if chosen.Feasts {
+		days = append(days, lang.Choose("las solemnidades y fiestas", "solemnities and feasts"))
+	}
+	return lang.Choose("Recibes el Evangelio estos días: ", "You get the Gospel on these days: ") +
+		strings.Join(days, ", ") + "."
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/igvaquero18/magnifibot/utils"
+	"github.com/mymmrac/telego"
+	"github.com/spf13/viper"
+)
+
+// telegramAPIURL is the endpoint of the methods of the Telegram Bot API
+const telegramAPIURL = "https://api.telegram.org/bot%s/%s"
+
+// allowedUpdates are the kinds of updates handled by the webhook
+var allowedUpdates = []string{"message", "channel_post", "callback_query", "inline_query"}
+
+// setup registers the menu of commands of the bot in Telegram, in every
+// supported language, and the webhook when its URL is configured. It is run
+// after every deployment with the setup argument.
+func setup() error {
+	bot, err := telego.NewBot(viper.GetString(telegramTokenFlag), telego.WithLogger(sugar))
+	if err != nil {
+		return fmt.Errorf("error creating telegram bot: %w", err)
+	}
+
+	if url := viper.GetString(webhookURLFlag); url != "" {
+		sugar.Infow("setting webhook", "url", url, "allowed_updates", allowedUpdates)
+		if err := setWebhook(url); err != nil {
+			return err
+		}
+	}
+
+	for _, payload := range router.SetMyCommands() {
+		commands := []telego.BotCommand{}
+		for _, command := range payload.Commands {
+			commands = append(commands, telego.BotCommand{
+				Command:     command.Command,
+				Description: command.Description,
+			})
+		}
+
+		sugar.Infow("setting bot commands", "language_code", payload.LanguageCode, "commands", len(commands))
+		if err := bot.SetMyCommands(&telego.SetMyCommandsParams{
+			Commands:     commands,
+			LanguageCode: payload.LanguageCode,
+		}); err != nil {
+			return fmt.Errorf("error setting commands for language %q: %w", payload.LanguageCode, err)
+		}
+	}
+	return nil
+}
+
+// setWebhook registers the URL of the webhook along with the secret token that
+// Telegram must send in every request. It calls the Bot API directly, since the
+// telegram client in use does not support secret tokens yet.
+func setWebhook(url string) error {
+	err := callBotAPI(context.Background(), "setWebhook", map[string]interface{}{
+		"url":             url,
+		"allowed_updates": allowedUpdates,
+		"secret_token":    secretToken,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error setting webhook: %w", err)
+	}
+	return nil
+}
+
+// callBotAPI calls a method of the Telegram Bot API with the given parameters,
+// and decodes its result into result, unless it is nil
+func callBotAPI(ctx context.Context, method string, params interface{}, result interface{}) error {
+	return utils.CallBotAPI(ctx, telegramAPIURL, viper.GetString(telegramTokenFlag), method, params, result)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/controller"
+)
+
+// subscription builds the subscription of the chat of the request. Nil
+// preferences get the default values in the language of the request.
+func subscription(req *api.Request, prefs *controller.Preferences) *controller.Subscription {
+	if prefs == nil {
+		prefs = controller.DefaultPreferences()
+		prefs.Language = string(req.Language)
+	}
+	return &controller.Subscription{
+		ChatID:      req.ChatID,
+		UserID:      req.UserID,
+		Kind:        req.Kind,
+		Title:       req.Title,
+		Username:    req.Username,
+		Preferences: prefs,
+		CreatedAt:   req.Date,
+	}
+}
+
+// status reports whether the chat is subscribed, since when, with which
+// preferences and when it got the last delivery
+func status(ctx context.Context, req *api.Request) (*api.TelegramWebhookSendMessage, error) {
+	sub, err := c.GetSubscription(ctx, req.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return req.Reply(req.Language.Choose(
+			"No estás suscrito. Usa /suscribirme para recibir el Evangelio cada día.",
+			"You are not subscribed. Use /subscribe to get the Gospel every day.",
+		)), nil
+	}
+
+	lang := req.Language
+	lines := []string{
+		fmt.Sprintf(lang.Choose("Suscrito desde el %s.", "Subscribed since %s."), formatDate(sub.CreatedAt)),
+	}
+	if !sub.Active {
+		lines = append(lines, lang.Choose(
+			"⚠️ La suscripción está desactivada porque no he podido enviarte mensajes. Usa /suscribirme para reactivarla.",
+			"⚠️ The subscription is inactive because I could not send you messages. Use /subscribe to reactivate it.",
+		))
+	}
+
+	if sub.Paused(time.Now()) {
+		lines = append(lines, fmt.Sprintf(
+			lang.Choose("⏸ Entrega pausada hasta el %s. Usa /reanudar para recibirlo antes.", "⏸ Delivery paused until %s. Use /resume to get it earlier."),
+			formatDate(sub.PausedUntil),
+		))
+	}
+
+	readings := lang.Choose("el Evangelio, las lecturas y el salmo", "the Gospel, the readings and the psalm")
+	if sub.Preferences.Profile == controller.GospelProfile {
+		readings = lang.Choose("solo el Evangelio", "only the Gospel")
+	}
+	language := lang.Choose("español", "Spanish")
+	if api.Language(sub.Preferences.Language) == api.English {
+		language = lang.Choose("inglés", "English")
+	}
+	lines = append(lines,
+		fmt.Sprintf(lang.Choose("Recibes %s.", "You get %s."), readings),
+		scheduleText(lang, sub.Schedule),
+		previewText(lang, sub.Preview),
+		fmt.Sprintf(
+			lang.Choose("Hora de entrega: %d:00 (hora de España).", "Delivery time: %d:00 (Spain time)."),
+			sub.Preferences.DeliveryHour,
+		),
+		fmt.Sprintf(lang.Choose("Idioma: %s.", "Language: %s."), language),
+	)
+
+	if sub.LastDelivery == "" {
+		lines = append(lines, lang.Choose("Todavía no te he enviado ninguna entrega diaria.", "I have not sent you any daily delivery yet."))
+	} else {
+		day := sub.LastDelivery
+		if date, err := time.Parse("2006-01-02", sub.LastDelivery); err == nil {
+			day = date.Format("02/01/2006")
+		}
+		lines = append(lines, fmt.Sprintf(
+			lang.Choose("Última entrega: las lecturas del %s, enviadas el %s.", "Last delivery: the readings of %s, sent on %s."),
+			day,
+			formatDate(sub.LastDeliveredAt),
+		))
+	}
+	return req.Reply(strings.Join(lines, "\n")), nil
+}
+
+// formatDate formats a Unix timestamp as a date in the timezone of the bot
+func formatDate(timestamp int64) string {
+	return time.Unix(timestamp, 0).In(location).Format("02/01/2006")
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"regexp"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/igvaquero18/magnifibot/api"
 	"github.com/igvaquero18/magnifibot/archimadrid"
 	"github.com/igvaquero18/magnifibot/controller"
 	"github.com/igvaquero18/magnifibot/utils"
@@ -19,12 +22,16 @@ import (
 const (
 	verboseEnv       = "MAGNIFIBOT_VERBOSE"
 	awsRegionEnv     = "MAGNIFIBOT_AWS_REGION"
+	sqsEndpointEnv   = "MAGNIFIBOT_SQS_ENDPOINT"
+	sqsQueueNameEnv  = "MAGNIFIBOT_SQS_QUEUE_NAME"
 	telegramTokenEnv = "MAGNIFIBOT_TELEGRAM_BOT_TOKEN"
 )
 
 const (
 	verboseFlag       = "logging.verbose"
 	awsRegionFlag     = "aws.region"
+	sqsEndpointFlag   = "aws.sqs.endpoint"
+	sqsQueueNameFlag  = "aws.sqs.queue_name"
 	telegramTokenFlag = "telegram.bot_token"
 )
 
@@ -37,14 +44,25 @@ var (
 type Event struct {
 	ChatID int64  `json:"chat_id"`
 	Action string `json:"action,omitempty"`
+
+	// Date is the day of the lectures, as YYYY-MM-DD. Today if empty.
+	Date string `json:"date,omitempty"`
+
+	// Language is the language of the user, used to apologize if the
+	// lectures can't be sent
+	Language api.Language `json:"language,omitempty"`
 }
 
 func init() {
 	viper.SetDefault(verboseFlag, false)
 	viper.SetDefault(awsRegionFlag, "eu-west-3")
+	viper.SetDefault(sqsEndpointFlag, "")
+	viper.SetDefault(sqsQueueNameFlag, controller.DefaultQueueName)
 	viper.SetDefault(telegramTokenFlag, "")
 	viper.BindEnv(verboseFlag, verboseEnv)
 	viper.BindEnv(awsRegionFlag, awsRegionEnv)
+	viper.BindEnv(sqsEndpointFlag, sqsEndpointEnv)
+	viper.BindEnv(sqsQueueNameFlag, sqsQueueNameEnv)
 	viper.BindEnv(telegramTokenFlag, telegramTokenEnv)
 
 	var err error
@@ -55,6 +73,29 @@ func init() {
 		os.Exit(1)
 	}
 
+	region := viper.GetString(awsRegionFlag)
+	sqsEndpoint := viper.GetString(sqsEndpointFlag)
+
+	sugar.Infow("creating SQS client", "region", region, "url", sqsEndpoint)
+	sqsClient, err := utils.InitSQSClient(region, sqsEndpoint)
+	if err != nil {
+		sugar.Fatalw("error creating SQS client", "error", err.Error())
+	}
+
+	queueURL, err := sqsClient.GetQueueUrl(context.TODO(), &sqs.GetQueueUrlInput{
+		QueueName: aws.String(viper.GetString(sqsQueueNameFlag)),
+	})
+
+	if err != nil {
+		sugar.Fatalw(
+			"error getting the queue URL",
+			"queue_name",
+			viper.GetString(sqsQueueNameFlag),
+			"error",
+			err.Error(),
+		)
+	}
+
 	sugar.Info("creating telegram bot client")
 	bot, err := telego.NewBot(viper.GetString(telegramTokenFlag), telego.WithLogger(sugar))
 	if err != nil {
@@ -62,148 +103,82 @@ func init() {
 	}
 
 	c = controller.NewMagnifibot(
+		controller.SetConfig(&controller.MagnifibotConfig{
+			QueueURL: *queueURL.QueueUrl,
+		}),
+		controller.SetSQSClient(sqsClient),
 		controller.SetTelegramClient(bot),
 	)
 
 	a = archimadrid.NewClient()
 }
 
-// Handler is our lambda handler invoked by the `lambda.Start` function call
+// Handler is our lambda handler invoked by the `lambda.Start` function call.
+// If the lectures can't be sent, the user is told to try again later, since
+// the function is not retried.
 func Handler(ctx context.Context, event Event) error {
 	sugar.Debug("received event")
-	today := time.Now()
-
-	gospel, err := a.GetGospel(ctx, today)
-	if err != nil {
-		sugar.Fatalw("error getting gospel", "error", err.Error())
-	}
-	firstLecture, err := a.GetFirstLecture(ctx, today)
-	if err != nil {
-		sugar.Fatalw("error getting first lecture", "error", err.Error())
-	}
-	psalm, err := a.GetPsalm(ctx, today)
-	if err != nil {
-		sugar.Fatalw("error getting psalm", "error", err.Error())
-	}
-	secondLecture, err := a.GetSecondLecture(ctx, today)
-	if err != nil {
-		sugar.Fatalw("error getting second lecture", "error", err.Error())
+	if err := sendLectures(ctx, event); err != nil {
+		if apologyErr := apologize(ctx, event); apologyErr != nil {
+			sugar.Warnw("error apologizing to chat", "chat_id", event.ChatID, "error", apologyErr.Error())
+		}
+		return fmt.Errorf("error sending lectures on demand to chat %d: %w", event.ChatID, err)
 	}
+	return nil
+}
 
-	re := regexp.MustCompile(`([_\*\[\]\(\)\~\>#\+\-\=\|\{\}\.!])`)
-	day := string(re.ReplaceAll([]byte(gospel.Day), []byte(`\$1`)))
-	firstLectureReference := string(re.ReplaceAll([]byte(firstLecture.Reference), []byte(`\$1`)))
-	firstLectureTitle := string(re.ReplaceAll([]byte(firstLecture.Title), []byte(`\$1`)))
-	firstLectureBody := string(re.ReplaceAll([]byte(firstLecture.Content), []byte(`\$1`)))
-	psalmReference := string(re.ReplaceAll([]byte(psalm.Title), []byte(`\$1`)))
-	psalmTitle := string(re.ReplaceAll([]byte(psalm.Reference), []byte(`\$1`)))
-	psalmBody := string(re.ReplaceAll([]byte(psalm.Content), []byte(`\$1`)))
-	gospelReference := string(re.ReplaceAll([]byte(gospel.Reference), []byte(`\$1`)))
-	gospelTitle := string(re.ReplaceAll([]byte(gospel.Title), []byte(`\$1`)))
-	gospelBody := string(re.ReplaceAll([]byte(gospel.Content), []byte(`\$1`)))
-
-	// Send day
-	messageID, err := c.SendTelegram(ctx, fmt.Sprintf("%d", event.ChatID), fmt.Sprintf("*%s*", day))
-	if err != nil {
-		return fmt.Errorf("error sending day %s as Telegram message: %w", day, err)
+// sendLectures enqueues the lectures of the day of the event for its chat
+func sendLectures(ctx context.Context, event Event) error {
+	today := time.Now()
+	if event.Date != "" {
+		day, err := time.Parse("2006-01-02", event.Date)
+		if err != nil {
+			return fmt.Errorf("error parsing date %s: %w", event.Date, err)
+		}
+		today = day
 	}
-	sugar.Debugw(
-		"successfully sent day as Telegram message",
-		"day",
-		day,
-		"chat_id",
-		event.ChatID,
-		"message_id",
-		messageID,
-	)
 
-	// Send First Lecture
-	messageID, err = c.SendTelegram(
-		ctx,
-		fmt.Sprintf("%d", event.ChatID),
-		fmt.Sprintf("*%s\n%s*\n\n%s", firstLectureReference, firstLectureTitle, firstLectureBody),
-	)
-	if err != nil {
-		return fmt.Errorf("error sending first lecture as Telegram message: %w", err)
+	magnificat, err := archimadrid.GetMagnificat(ctx, a, today)
+	if errors.Is(err, archimadrid.ErrMissingLectures) {
+		sugar.Warnw("sending the lectures that could be got", "day", magnificat.Date, "chat_id", event.ChatID, "error", err.Error())
+	} else if err != nil {
+		return fmt.Errorf("error getting lectures of %s: %w", today.Format("2006-01-02"), err)
 	}
-	sugar.Debugw(
-		"successfully sent first lecture as Telegram message",
-		"first_lecture_reference",
-		firstLectureReference,
-		"chat_id",
-		event.ChatID,
-		"message_id",
-		messageID,
-	)
 
-	// Send Psalm
-	messageID, err = c.SendTelegram(
-		ctx,
-		fmt.Sprintf("%d", event.ChatID),
-		fmt.Sprintf("*%s\n%s*\n\n%s", psalmReference, psalmTitle, psalmBody),
-	)
+	// The lectures are delivered by SendGospel, like the daily ones, so that failed
+	// deliveries are retried. On demand messages are sent to chats that are not
+	// subscribed too, followed by the buttons to get the lectures of other days.
+	message, err := controller.NewMessage(controller.MagnificatKind, magnificat)
 	if err != nil {
-		return fmt.Errorf("error sending psalm as Telegram message: %w", err)
-	}
-	sugar.Debugw(
-		"successfully sent psalm as Telegram message",
-		"psalm_reference",
-		psalmTitle,
-		"chat_id",
-		event.ChatID,
-		"message_id",
-		messageID,
-	)
-
-	// Send Second Lecture if exists
-	if len(secondLecture.Content) > 0 {
-		secondLectureReference := string(
-			re.ReplaceAll([]byte(secondLecture.Reference), []byte(`\$1`)),
-		)
-		secondLectureTitle := string(re.ReplaceAll([]byte(secondLecture.Title), []byte(`\$1`)))
-		secondLectureBody := string(re.ReplaceAll([]byte(secondLecture.Content), []byte(`\$1`)))
-
-		messageID, err = c.SendTelegram(
-			ctx,
-			fmt.Sprintf("%d", event.ChatID),
-			fmt.Sprintf("*%s\n%s*\n\n%s", secondLectureReference, secondLectureTitle, secondLectureBody),
-		)
-		if err != nil {
-			return fmt.Errorf("error sending second lecture as Telegram message: %w", err)
-		}
-		sugar.Debugw(
-			"successfully sent second lecture as Telegram message",
-			"second_lecture_reference",
-			secondLectureReference,
-			"chat_id",
-			event.ChatID,
-			"message_id",
-			messageID,
-		)
+		return err
 	}
-
-	// Send Gospel
-	messageID, err = c.SendTelegram(
-		ctx,
-		fmt.Sprintf("%d", event.ChatID),
-		fmt.Sprintf("*%s\n%s*\n\n%s", gospelReference, gospelTitle, gospelBody),
-	)
+	chatID := fmt.Sprintf("%d", event.ChatID)
+	messageID, err := c.SendMessageToQueue(ctx, chatID, message, true)
 	if err != nil {
-		return fmt.Errorf("error sending gospel as Telegram message: %w", err)
+		return fmt.Errorf("error sending magnificat %s to queue: %w", magnificat.Date, err)
 	}
 	sugar.Debugw(
-		"successfully sent gospel as Telegram message",
-		"gospel_reference",
-		gospelReference,
+		"successfully sent magnificat to queue",
+		"day",
+		magnificat.Day,
 		"chat_id",
 		event.ChatID,
-		"message_id",
+		"sqs_message_id",
 		messageID,
 	)
-
 	return nil
 }
 
+// apologize tells the chat of the event that its lectures could not be sent
+func apologize(ctx context.Context, event Event) error {
+	text := event.Language.Choose(
+		"Lo siento, ahora mismo no puedo obtener las lecturas. Inténtalo de nuevo dentro de un rato.",
+		"Sorry, I can't get the readings right now. Please try again in a while.",
+	)
+	_, err := c.SendTelegram(ctx, fmt.Sprintf("%d", event.ChatID), utils.EscapeMarkdownV2(text))
+	return err
+}
+
 func main() {
 	lambda.Start(Handler)
 }
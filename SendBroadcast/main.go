@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/igvaquero18/magnifibot/archimadrid"
+	"github.com/igvaquero18/magnifibot/controller"
+	"github.com/igvaquero18/magnifibot/utils"
+	"github.com/mymmrac/telego"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	verboseEnv                = "MAGNIFIBOT_VERBOSE"
+	awsRegionEnv              = "MAGNIFIBOT_AWS_REGION"
+	sqsEndpointEnv            = "MAGNIFIBOT_SQS_ENDPOINT"
+	sqsQueueNameEnv           = "MAGNIFIBOT_SQS_QUEUE_NAME"
+	sqsConcurrencyEnv         = "MAGNIFIBOT_SQS_CONCURRENCY"
+	dynamoDBEndpointEnv       = "MAGNIFIBOT_DYNAMODB_ENDPOINT"
+	dynamoDBUserTableEnv      = "MAGNIFIBOT_DYNAMODB_USER_TABLE"
+	dynamoDBBroadcastTableEnv = "MAGNIFIBOT_DYNAMODB_BROADCAST_TABLE"
+	subscriberStoreEnv        = "MAGNIFIBOT_SUBSCRIBER_STORE"
+	subscriberStoreDSNEnv     = "MAGNIFIBOT_SUBSCRIBER_STORE_DSN"
+	dynamoDBSegmentsEnv       = "MAGNIFIBOT_DYNAMODB_SCAN_SEGMENTS"
+	telegramTokenEnv          = "MAGNIFIBOT_TELEGRAM_BOT_TOKEN"
+	broadcastRateEnv          = "MAGNIFIBOT_BROADCAST_RATE"
+	timezoneEnv               = "MAGNIFIBOT_TIMEZONE"
+)
+
+const (
+	verboseFlag                = "logging.verbose"
+	awsRegionFlag              = "aws.region"
+	sqsEndpointFlag            = "aws.sqs.endpoint"
+	sqsQueueNameFlag           = "aws.sqs.queue_name"
+	sqsConcurrencyFlag         = "aws.sqs.concurrency"
+	dynamoDBEndpointFlag       = "aws.dynamodb.endpoint"
+	dynamoDBUserTableFlag      = "aws.dynamodb.tables.user"
+	dynamoDBBroadcastTableFlag = "aws.dynamodb.tables.broadcast"
+	subscriberStoreFlag        = "subscribers.store"
+	subscriberStoreDSNFlag     = "subscribers.dsn"
+	dynamoDBSegmentsFlag       = "aws.dynamodb.scan_segments"
+	telegramTokenFlag          = "telegram.bot_token"
+	broadcastRateFlag          = "admin.broadcast_rate"
+	timezoneFlag               = "timezone"
+)
+
+// The actions of the events, which default to sending a broadcast
+const (
+	broadcastAction = "broadcast"
+	resendAction    = "resend"
+)
+
+var (
+	c        controller.MagnifibotInterface
+	a        archimadrid.Archimadrid
+	location *time.Location
+	sugar    *zap.SugaredLogger
+)
+
+// Event is a message for many chats requested by an operator, who is told in
+// ChatID how many chats it was enqueued for. It is either the broadcast of
+// BroadcastID or, for resendAction, the lectures of Date.
+type Event struct {
+	Action      string `json:"action,omitempty"`
+	BroadcastID string `json:"broadcast_id,omitempty"`
+	Date        string `json:"date,omitempty"`
+	ChatID      int64  `json:"chat_id"`
+}
+
+func init() {
+	viper.SetDefault(verboseFlag, false)
+	viper.SetDefault(awsRegionFlag, "eu-west-3")
+	viper.SetDefault(sqsEndpointFlag, "")
+	viper.SetDefault(sqsQueueNameFlag, controller.DefaultQueueName)
+	viper.SetDefault(sqsConcurrencyFlag, controller.DefaultQueueConcurrency)
+	viper.SetDefault(dynamoDBEndpointFlag, "")
+	viper.SetDefault(dynamoDBUserTableFlag, controller.DefaultUserTable)
+	viper.SetDefault(dynamoDBBroadcastTableFlag, controller.DefaultBroadcastTable)
+	viper.SetDefault(subscriberStoreFlag, controller.DefaultSubscriberStore)
+	viper.SetDefault(subscriberStoreDSNFlag, "")
+	viper.SetDefault(dynamoDBSegmentsFlag, controller.DefaultScanSegments)
+	viper.SetDefault(telegramTokenFlag, "")
+	viper.SetDefault(broadcastRateFlag, controller.DefaultBroadcastRate)
+	viper.SetDefault(timezoneFlag, controller.DefaultTimezone)
+	viper.BindEnv(verboseFlag, verboseEnv)
+	viper.BindEnv(awsRegionFlag, awsRegionEnv)
+	viper.BindEnv(sqsEndpointFlag, sqsEndpointEnv)
+	viper.BindEnv(sqsQueueNameFlag, sqsQueueNameEnv)
+	viper.BindEnv(sqsConcurrencyFlag, sqsConcurrencyEnv)
+	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
+	viper.BindEnv(dynamoDBUserTableFlag, dynamoDBUserTableEnv)
+	viper.BindEnv(dynamoDBBroadcastTableFlag, dynamoDBBroadcastTableEnv)
+	viper.BindEnv(subscriberStoreFlag, subscriberStoreEnv)
+	viper.BindEnv(subscriberStoreDSNFlag, subscriberStoreDSNEnv)
+	viper.BindEnv(dynamoDBSegmentsFlag, dynamoDBSegmentsEnv)
+	viper.BindEnv(telegramTokenFlag, telegramTokenEnv)
+	viper.BindEnv(broadcastRateFlag, broadcastRateEnv)
+	viper.BindEnv(timezoneFlag, timezoneEnv)
+
+	var err error
+
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+	if err != nil {
+		fmt.Printf("error when initializing logger: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	region := viper.GetString(awsRegionFlag)
+	sqsEndpoint := viper.GetString(sqsEndpointFlag)
+	dynamoDBEndpoint := viper.GetString(dynamoDBEndpointFlag)
+
+	sugar.Infow("creating SQS client", "region", region, "url", sqsEndpoint)
+	sqsClient, err := utils.InitSQSClient(region, sqsEndpoint)
+	if err != nil {
+		sugar.Fatalw("error creating SQS client", "error", err.Error())
+	}
+
+	queueURL, err := sqsClient.GetQueueUrl(context.TODO(), &sqs.GetQueueUrlInput{
+		QueueName: aws.String(viper.GetString(sqsQueueNameFlag)),
+	})
+
+	if err != nil {
+		sugar.Fatalw(
+			"error getting the queue URL",
+			"queue_name",
+			viper.GetString(sqsQueueNameFlag),
+			"error",
+			err.Error(),
+		)
+	}
+
+	sugar.Infow("creating DynamoDB client", "region", region, "url", dynamoDBEndpoint)
+	dynamoClient, err := utils.InitDynamoClient(region, dynamoDBEndpoint)
+	if err != nil {
+		sugar.Fatalw("error creating DynamoDB client", "error", err.Error())
+	}
+
+	subscriberStore := viper.GetString(subscriberStoreFlag)
+	sugar.Infow("creating subscriber store", "store", subscriberStore)
+	subscribers, err := controller.OpenSubscriberStore(
+		context.Background(),
+		subscriberStore,
+		viper.GetString(subscriberStoreDSNFlag),
+	)
+	if err != nil {
+		sugar.Fatalw("error creating subscriber store", "store", subscriberStore, "error", err.Error())
+	}
+
+	sugar.Info("creating telegram bot client")
+	bot, err := telego.NewBot(viper.GetString(telegramTokenFlag), telego.WithLogger(sugar))
+	if err != nil {
+		sugar.Fatalw("error creating telegram bot client", "error", err.Error())
+	}
+
+	c = controller.NewMagnifibot(
+		controller.SetConfig(&controller.MagnifibotConfig{
+			UserTable:        viper.GetString(dynamoDBUserTableFlag),
+			BroadcastTable:   viper.GetString(dynamoDBBroadcastTableFlag),
+			ScanSegments:     viper.GetInt(dynamoDBSegmentsFlag),
+			QueueURL:         *queueURL.QueueUrl,
+			QueueConcurrency: viper.GetInt(sqsConcurrencyFlag),
+		}),
+		controller.SetSQSClient(sqsClient),
+		controller.SetDynamoDBClient(dynamoClient),
+		controller.SetSubscriberStore(subscribers),
+		controller.SetTelegramClient(bot),
+	)
+
+	a = archimadrid.NewClient()
+
+	location, err = time.LoadLocation(viper.GetString(timezoneFlag))
+	if err != nil {
+		sugar.Fatalw("error loading timezone", "timezone", viper.GetString(timezoneFlag), "error", err.Error())
+	}
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call.
+// It enqueues the messages requested by an operator, which takes longer than
+// the webhook may, and tells the operator the result. The function is not
+// retried, since that would send the messages twice to the enqueued chats.
+func Handler(ctx context.Context, event Event) error {
+	sugar.Infow("received event", "action", event.Action, "broadcast_id", event.BroadcastID, "date", event.Date)
+
+	var text string
+	var err error
+	switch event.Action {
+	case "", broadcastAction:
+		text, err = sendBroadcast(ctx, event.BroadcastID)
+	case resendAction:
+		text, err = resend(ctx, event.Date)
+	default:
+		return fmt.Errorf("error handling event: unknown action %q", event.Action)
+	}
+
+	if event.ChatID != 0 && text != "" {
+		chatID := strconv.FormatInt(event.ChatID, 10)
+		if _, sendErr := c.SendTelegram(ctx, chatID, utils.EscapeMarkdownV2(text)); sendErr != nil {
+			sugar.Warnw("error reporting to operator", "action", event.Action, "chat_id", chatID, "error", sendErr.Error())
+		}
+	}
+	return err
+}
+
+// sendBroadcast enqueues a broadcast that the operator confirmed, returning
+// the text that tells the operator the result
+func sendBroadcast(ctx context.Context, id string) (string, error) {
+	b, err := c.GetBroadcast(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if b == nil {
+		return "", fmt.Errorf("error sending broadcast %s: not found", id)
+	}
+	// Only the broadcasts just started by the operator are sent
+	if b.Status != controller.BroadcastSending || b.SentAt != 0 {
+		return "", fmt.Errorf("error sending broadcast %s: it is %s", b.ID, b.Status)
+	}
+
+	enqueued, failed, err := c.SendBroadcast(ctx, b, viper.GetInt(broadcastRateFlag))
+	if err != nil {
+		sugar.Errorw("error sending broadcast", "broadcast_id", b.ID, "enqueued", enqueued, "error", err.Error())
+		return fmt.Sprintf("Broadcast %s stopped after enqueueing %d chats: %s", b.ID, enqueued, err.Error()), err
+	}
+	return fmt.Sprintf(
+		"Broadcast %s enqueued for %d chats, %d failed. Use /admin_broadcasts to follow its delivery.",
+		b.ID,
+		enqueued,
+		failed,
+	), nil
+}
+
+// resend enqueues the lectures of a day again for the chats that should have
+// got them, returning the text that tells the operator the result
+func resend(ctx context.Context, date string) (string, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, location)
+	if err != nil {
+		return "", fmt.Errorf("error parsing date %s: %w", date, err)
+	}
+
+	// Unlike the daily job, a day is only resent with all its lectures
+	magnificat, err := archimadrid.GetMagnificat(ctx, a, day)
+	if err != nil {
+		return fmt.Sprintf("Lectures of %s could not be got: %s", date, err.Error()), err
+	}
+	message, err := controller.NewMessage(controller.MagnificatKind, magnificat)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().In(location)
+	weekday := day.Weekday()
+	enqueued, failed, err := enqueueMessage(ctx, message, controller.ChatFilter{
+		NotPausedAt: &now,
+		Weekday:     &weekday,
+		Feast:       magnificat.Rank() >= archimadrid.FeastRank,
+	})
+	if err != nil {
+		sugar.Errorw("error resending lectures", "date", date, "enqueued", enqueued, "error", err.Error())
+		return fmt.Sprintf("Lectures of %s stopped after enqueueing %d chats: %s", date, enqueued, err.Error()), err
+	}
+	return fmt.Sprintf("Lectures of %s enqueued for %d chats, %d failed.", date, enqueued, failed), nil
+}
+
+// enqueueMessage sends a message to the queue for every chat matching the
+// filter, returning the number of chats enqueued and of the ones that failed
+func enqueueMessage(ctx context.Context, message *controller.Message, filter controller.ChatFilter) (int, int, error) {
+	var mu sync.Mutex
+	enqueued, failed := 0, 0
+	err := c.ScanChatIDs(ctx, filter, func(chatIDs []string) error {
+		pageFailed := c.SendMessagesToQueue(ctx, chatIDs, message)
+		for chatID, err := range pageFailed {
+			sugar.Warnw("error sending message to queue", "chat_id", chatID, "error", err.Error())
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		enqueued += len(chatIDs) - len(pageFailed)
+		failed += len(pageFailed)
+		return nil
+	})
+	if err != nil {
+		return enqueued, failed, fmt.Errorf("error enqueueing message after %d chats: %w", enqueued, err)
+	}
+	return enqueued, failed, nil
+}
+
+func main() {
+	lambda.Start(Handler)
+}
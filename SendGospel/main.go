@@ -2,17 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
-	"regexp"
-	"strings"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/igvaquero18/magnifibot/api"
 	"github.com/igvaquero18/magnifibot/archimadrid"
 	"github.com/igvaquero18/magnifibot/controller"
 	"github.com/igvaquero18/magnifibot/utils"
@@ -22,24 +22,39 @@ import (
 )
 
 const (
-	verboseEnv       = "MAGNIFIBOT_VERBOSE"
-	awsRegionEnv     = "MAGNIFIBOT_AWS_REGION"
-	sqsEndpointEnv   = "MAGNIFIBOT_SQS_ENDPOINT"
-	sqsQueueNameEnv  = "MAGNIFIBOT_SQS_QUEUE_NAME"
-	telegramTokenEnv = "MAGNIFIBOT_TELEGRAM_BOT_TOKEN"
+	verboseEnv                = "MAGNIFIBOT_VERBOSE"
+	awsRegionEnv              = "MAGNIFIBOT_AWS_REGION"
+	sqsEndpointEnv            = "MAGNIFIBOT_SQS_ENDPOINT"
+	sqsQueueNameEnv           = "MAGNIFIBOT_SQS_QUEUE_NAME"
+	dynamoDBEndpointEnv       = "MAGNIFIBOT_DYNAMODB_ENDPOINT"
+	dynamoDBUserTableEnv      = "MAGNIFIBOT_DYNAMODB_USER_TABLE"
+	subscriberStoreEnv        = "MAGNIFIBOT_SUBSCRIBER_STORE"
+	subscriberStoreDSNEnv     = "MAGNIFIBOT_SUBSCRIBER_STORE_DSN"
+	dynamoDBDeliveryTableEnv  = "MAGNIFIBOT_DYNAMODB_DELIVERY_TABLE"
+	dynamoDBBroadcastTableEnv = "MAGNIFIBOT_DYNAMODB_BROADCAST_TABLE"
+	telegramTokenEnv          = "MAGNIFIBOT_TELEGRAM_BOT_TOKEN"
+	callbackSecretEnv         = "MAGNIFIBOT_TELEGRAM_CALLBACK_SECRET"
 )
 
 const (
-	verboseFlag       = "logging.verbose"
-	awsRegionFlag     = "aws.region"
-	sqsEndpointFlag   = "aws.sqs.endpoint"
-	sqsQueueNameFlag  = "aws.sqs.queue_name"
-	telegramTokenFlag = "telegram.bot_token"
+	verboseFlag                = "logging.verbose"
+	awsRegionFlag              = "aws.region"
+	sqsEndpointFlag            = "aws.sqs.endpoint"
+	sqsQueueNameFlag           = "aws.sqs.queue_name"
+	dynamoDBEndpointFlag       = "aws.dynamodb.endpoint"
+	dynamoDBUserTableFlag      = "aws.dynamodb.tables.user"
+	subscriberStoreFlag        = "subscribers.store"
+	subscriberStoreDSNFlag     = "subscribers.dsn"
+	dynamoDBDeliveryTableFlag  = "aws.dynamodb.tables.delivery"
+	dynamoDBBroadcastTableFlag = "aws.dynamodb.tables.broadcast"
+	telegramTokenFlag          = "telegram.bot_token"
+	callbackSecretFlag         = "telegram.callback_secret"
 )
 
 var (
-	c     controller.MagnifibotInterface
-	sugar *zap.SugaredLogger
+	c      controller.MagnifibotInterface
+	secret []byte
+	sugar  *zap.SugaredLogger
 )
 
 // Response is of type SQSEvent since we're leveraging the
@@ -53,12 +68,26 @@ func init() {
 	viper.SetDefault(awsRegionFlag, "eu-west-3")
 	viper.SetDefault(sqsEndpointFlag, "")
 	viper.SetDefault(sqsQueueNameFlag, controller.DefaultQueueName)
+	viper.SetDefault(dynamoDBEndpointFlag, "")
+	viper.SetDefault(dynamoDBUserTableFlag, controller.DefaultUserTable)
+	viper.SetDefault(subscriberStoreFlag, controller.DefaultSubscriberStore)
+	viper.SetDefault(subscriberStoreDSNFlag, "")
+	viper.SetDefault(dynamoDBDeliveryTableFlag, controller.DefaultDeliveryTable)
+	viper.SetDefault(dynamoDBBroadcastTableFlag, controller.DefaultBroadcastTable)
 	viper.SetDefault(telegramTokenFlag, "")
+	viper.SetDefault(callbackSecretFlag, "")
 	viper.BindEnv(verboseFlag, verboseEnv)
 	viper.BindEnv(awsRegionFlag, awsRegionEnv)
 	viper.BindEnv(sqsEndpointFlag, sqsEndpointEnv)
 	viper.BindEnv(sqsQueueNameFlag, sqsQueueNameEnv)
+	viper.BindEnv(dynamoDBEndpointFlag, dynamoDBEndpointEnv)
+	viper.BindEnv(dynamoDBUserTableFlag, dynamoDBUserTableEnv)
+	viper.BindEnv(subscriberStoreFlag, subscriberStoreEnv)
+	viper.BindEnv(subscriberStoreDSNFlag, subscriberStoreDSNEnv)
+	viper.BindEnv(dynamoDBDeliveryTableFlag, dynamoDBDeliveryTableEnv)
+	viper.BindEnv(dynamoDBBroadcastTableFlag, dynamoDBBroadcastTableEnv)
 	viper.BindEnv(telegramTokenFlag, telegramTokenEnv)
+	viper.BindEnv(callbackSecretFlag, callbackSecretEnv)
 
 	var err error
 
@@ -91,6 +120,24 @@ func init() {
 		)
 	}
 
+	dynamoDBEndpoint := viper.GetString(dynamoDBEndpointFlag)
+	sugar.Infow("creating DynamoDB client", "region", region, "url", dynamoDBEndpoint)
+	dynamoClient, err := utils.InitDynamoClient(region, dynamoDBEndpoint)
+	if err != nil {
+		sugar.Fatalw("error creating DynamoDB client", "error", err.Error())
+	}
+
+	subscriberStore := viper.GetString(subscriberStoreFlag)
+	sugar.Infow("creating subscriber store", "store", subscriberStore)
+	subscribers, err := controller.OpenSubscriberStore(
+		context.Background(),
+		subscriberStore,
+		viper.GetString(subscriberStoreDSNFlag),
+	)
+	if err != nil {
+		sugar.Fatalw("error creating subscriber store", "store", subscriberStore, "error", err.Error())
+	}
+
 	sugar.Info("creating telegram bot client")
 	bot, err := telego.NewBot(viper.GetString(telegramTokenFlag), telego.WithLogger(sugar))
 	if err != nil {
@@ -99,153 +146,48 @@ func init() {
 
 	c = controller.NewMagnifibot(
 		controller.SetConfig(&controller.MagnifibotConfig{
-			QueueURL: *queueURL.QueueUrl,
+			QueueURL:       *queueURL.QueueUrl,
+			UserTable:      viper.GetString(dynamoDBUserTableFlag),
+			DeliveryTable:  viper.GetString(dynamoDBDeliveryTableFlag),
+			BroadcastTable: viper.GetString(dynamoDBBroadcastTableFlag),
 		}),
 		controller.SetSQSClient(sqsClient),
+		controller.SetDynamoDBClient(dynamoClient),
+		controller.SetSubscriberStore(subscribers),
 		controller.SetTelegramClient(bot),
 	)
 
+	secret = utils.DeriveSecret(
+		viper.GetString(callbackSecretFlag),
+		viper.GetString(telegramTokenFlag),
+		api.CallbackSecretPurpose,
+	)
+}
+
+// failure is the error that occurred while processing an SQS message
+type failure struct {
+	messageID string
+	err       error
 }
 
-// Handler is our lambda handler invoked by the `lambda.Start` function call
-func Handler(ctx context.Context, event Event) error {
+// Handler is our lambda handler invoked by the `lambda.Start` function call.
+// It reports back the messages that could not be delivered, so that only those
+// are retried and eventually moved to the dead-letter queue.
+func Handler(ctx context.Context, event Event) (events.SQSEventResponse, error) {
 	sugar.Debug("received sqs event")
 
 	wg := sync.WaitGroup{}
-	errCh := make(chan error)
+	failureCh := make(chan failure)
 	doneCh := make(chan struct{})
 	wg.Add(len(event.Records))
 
 	for _, record := range event.Records {
-		go func(r events.SQSMessage, e chan<- error) {
+		go func(r events.SQSMessage, f chan<- failure) {
 			defer wg.Done()
-
-			var magnificat archimadrid.Magnificat
-			err := json.Unmarshal([]byte(r.Body), &magnificat)
-
-			if err != nil {
-				e <- fmt.Errorf("error unmarshalling JSON: %w", err)
-				return
-			}
-
-			re := regexp.MustCompile(`([_\*\[\]\(\)\~\>#\+\-\=\|\{\}\.!])`)
-			day := string(re.ReplaceAll([]byte(magnificat.Day), []byte(`\$1`)))
-			firstLectureReference := string(re.ReplaceAll([]byte(magnificat.FirstLecture.Reference), []byte(`\$1`)))
-			firstLectureTitle := string(re.ReplaceAll([]byte(magnificat.FirstLecture.Title), []byte(`\$1`)))
-			firstLectureBody := string(re.ReplaceAll([]byte(magnificat.FirstLecture.Content), []byte(`\$1`)))
-			psalmReference := string(re.ReplaceAll([]byte(magnificat.Psalm.Title), []byte(`\$1`)))
-			psalmTitle := string(re.ReplaceAll([]byte(magnificat.Psalm.Reference), []byte(`\$1`)))
-			psalmBody := string(re.ReplaceAll([]byte(magnificat.Psalm.Content), []byte(`\$1`)))
-			gospelReference := string(re.ReplaceAll([]byte(magnificat.Gosp.Reference), []byte(`\$1`)))
-			gospelTitle := string(re.ReplaceAll([]byte(magnificat.Gosp.Title), []byte(`\$1`)))
-			gospelBody := string(re.ReplaceAll([]byte(magnificat.Gosp.Content), []byte(`\$1`)))
-
-			chatID := *r.MessageAttributes["chatID"].StringValue
-
-			// Send day
-			messageID, err := c.SendTelegram(ctx, chatID, fmt.Sprintf("*%s*", day))
-			if err != nil {
-				e <- fmt.Errorf("error sending day %s as Telegram message: %w", day, err)
-				return
+			if err := deliver(ctx, r); err != nil {
+				f <- failure{messageID: r.MessageId, err: err}
 			}
-			sugar.Debugw(
-				"successfully sent day as Telegram message",
-				"day",
-				day,
-				"chat_id",
-				chatID,
-				"message_id",
-				messageID,
-			)
-
-			// Send First Lecture
-			messageID, err = c.SendTelegram(
-				ctx,
-				chatID,
-				fmt.Sprintf("*%s\n%s*\n\n%s", firstLectureReference, firstLectureTitle, firstLectureBody),
-			)
-			if err != nil {
-				e <- fmt.Errorf("error sending first lecture as Telegram message: %w", err)
-				return
-			}
-			sugar.Debugw(
-				"successfully sent first lecture as Telegram message",
-				"first_lecture_reference",
-				firstLectureReference,
-				"chat_id",
-				chatID,
-				"message_id",
-				messageID,
-			)
-
-			// Send Psalm
-			messageID, err = c.SendTelegram(
-				ctx,
-				chatID,
-				fmt.Sprintf("*%s\n%s*\n\n%s", psalmReference, psalmTitle, psalmBody),
-			)
-			if err != nil {
-				e <- fmt.Errorf("error sending psalm as Telegram message: %w", err)
-				return
-			}
-			sugar.Debugw(
-				"successfully sent psalm as Telegram message",
-				"psalm_reference",
-				psalmTitle,
-				"chat_id",
-				chatID,
-				"message_id",
-				messageID,
-			)
-
-			// Send Second Lecture if exists
-			if magnificat.SecondLecture != nil {
-				secondLectureReference := string(
-					re.ReplaceAll([]byte(magnificat.SecondLecture.Reference), []byte(`\$1`)),
-				)
-				secondLectureTitle := string(re.ReplaceAll([]byte(magnificat.SecondLecture.Title), []byte(`\$1`)))
-				secondLectureBody := string(re.ReplaceAll([]byte(magnificat.SecondLecture.Content), []byte(`\$1`)))
-
-				messageID, err = c.SendTelegram(
-					ctx,
-					chatID,
-					fmt.Sprintf("*%s\n%s*\n\n%s", secondLectureReference, secondLectureTitle, secondLectureBody),
-				)
-				if err != nil {
-					e <- fmt.Errorf("error sending second lecture as Telegram message: %w", err)
-					return
-				}
-				sugar.Debugw(
-					"successfully sent second lecture as Telegram message",
-					"second_lecture_reference",
-					secondLectureReference,
-					"chat_id",
-					chatID,
-					"message_id",
-					messageID,
-				)
-			}
-
-			// Send Gospel
-			messageID, err = c.SendTelegram(
-				ctx,
-				chatID,
-				fmt.Sprintf("*%s\n%s*\n\n%s", gospelReference, gospelTitle, gospelBody),
-			)
-			if err != nil {
-				e <- fmt.Errorf("error sending gospel as Telegram message: %w", err)
-				return
-			}
-			sugar.Debugw(
-				"successfully sent gospel as Telegram message",
-				"gospel_reference",
-				gospelReference,
-				"chat_id",
-				chatID,
-				"message_id",
-				messageID,
-			)
-		}(record, errCh)
+		}(record, failureCh)
 	}
 
 	go func(d chan<- struct{}) {
@@ -254,23 +196,255 @@ func Handler(ctx context.Context, event Event) error {
 	}(doneCh)
 
 	done := false
-	errors := []string{}
+	response := events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{}}
 
 	for !done {
 		select {
-		case err := <-errCh:
-			errors = append(errors, err.Error())
+		case f := <-failureCh:
+			sugar.Errorw("error processing sqs message", "sqs_message_id", f.messageID, "error", f.err.Error())
+			response.BatchItemFailures = append(
+				response.BatchItemFailures,
+				events.SQSBatchItemFailure{ItemIdentifier: f.messageID},
+			)
 		case <-doneCh:
 			done = true
 		}
 	}
 
-	if len(errors) > 0 {
-		return fmt.Errorf("errors occurred while processing sqs events: %s", strings.Join(errors, "\n"))
+	return response, nil
+}
+
+// deliver sends the content of an SQS message to its chat. Messages of a kind or
+// version that this consumer does not know are reported as failed, so that they end
+// up in the dead-letter queue and can be replayed once a consumer knows them.
+func deliver(ctx context.Context, r events.SQSMessage) error {
+	chatIDAttribute := ""
+	if attribute, ok := r.MessageAttributes["chatID"]; ok && attribute.StringValue != nil {
+		chatIDAttribute = *attribute.StringValue
+	}
+
+	envelope, err := controller.ParseEnvelope(r.Body, chatIDAttribute)
+	if err != nil {
+		return err
+	}
+	if envelope.Deferred(time.Now()) {
+		return c.DeferEnvelope(ctx, envelope)
+	}
+
+	switch envelope.Kind {
+	case controller.MagnificatKind:
+		magnificat, err := envelope.Magnificat()
+		if err != nil {
+			return err
+		}
+		if envelope.OnDemand {
+			return deliverOnDemand(ctx, r, envelope.ChatID, magnificat)
+		}
+		return deliverMagnificat(ctx, r, envelope.ChatID, magnificat)
+	case controller.BroadcastKind:
+		broadcast, err := envelope.Broadcast()
+		if err != nil {
+			return err
+		}
+		return announce(ctx, r, envelope.ChatID, broadcast)
+	}
+	return fmt.Errorf("error delivering message: %w: %q", controller.ErrUnknownKind, envelope.Kind)
+}
+
+// deliverMagnificat sends the Magnificat in an SQS message to its chat. Failed attempts
+// are recorded in the delivery ledger, so that the reason can be inspected if the
+// message ends up in the dead-letter queue.
+func deliverMagnificat(ctx context.Context, r events.SQSMessage, id int64, magnificat *archimadrid.Magnificat) error {
+	chatID := strconv.FormatInt(id, 10)
+
+	key := magnificat.Date
+	if key == "" {
+		key = magnificat.Day
+	}
+	// Previews are delivered again on their Sunday, so they need their own entry in the ledger
+	if magnificat.Preview {
+		key = fmt.Sprintf("%s#preview", key)
+	}
+
+	sub, err := c.GetSubscription(ctx, id)
+	if err != nil {
+		return err
+	}
+	if sub == nil {
+		sugar.Infow("chat is no longer suscribed, skipping delivery", "chat_id", chatID)
+		return nil
+	}
+	if sub.Paused(time.Now()) {
+		sugar.Infow("delivery to chat is paused, skipping delivery", "chat_id", chatID, "paused_until", sub.PausedUntil)
+		return nil
+	}
+	prefs := sub.Preferences
+
+	// The first delivery after a pause ends welcomes the chat back
+	if sub.PausedUntil != 0 {
+		if err := welcomeBack(ctx, sub); err != nil {
+			if reason, unreachable := controller.UnreachableReason(err); unreachable {
+				return deactivate(ctx, id, reason)
+			}
+			return err
+		}
+	}
+
+	if err := c.DeliverMagnificat(ctx, chatID, key, prefs.Readings(magnificat), api.Language(prefs.Language)); err != nil {
+		if reason, unreachable := controller.UnreachableReason(err); unreachable {
+			return deactivate(ctx, id, reason)
+		}
+		if markErr := c.MarkFailed(ctx, chatID, key, err.Error()); markErr != nil {
+			sugar.Warnw("error recording failed delivery", "chat_id", chatID, "error", markErr.Error())
+		}
+		return fmt.Errorf("error delivering magnificat %s: %w", key, err)
+	}
+	if !magnificat.Preview {
+		if err := c.RecordDelivery(ctx, id, key); err != nil {
+			sugar.Warnw("error recording delivery in the subscription", "chat_id", chatID, "error", err.Error())
+		}
+	}
+	sugar.Debugw(
+		"successfully delivered magnificat as Telegram messages",
+		"day",
+		magnificat.Day,
+		"chat_id",
+		chatID,
+		"sqs_message_id",
+		r.MessageId,
+	)
+	return nil
+}
+
+// deliverOnDemand sends the Magnificat requested by a chat, whether it is subscribed
+// or not, followed by the buttons to get the lectures of other days
+func deliverOnDemand(ctx context.Context, r events.SQSMessage, id int64, magnificat *archimadrid.Magnificat) error {
+	chatID := strconv.FormatInt(id, 10)
+
+	// Retries of an SQS message keep its ID, so using it as part of the delivery key
+	// resumes a failed delivery without mixing it up with other on demand requests
+	// for the same day
+	key := fmt.Sprintf("%s#ondemand#%s", magnificat.Date, r.MessageId)
+
+	prefs, err := c.GetPreferences(ctx, id)
+	if err != nil {
+		return err
+	}
+	if prefs == nil {
+		prefs = controller.DefaultPreferences()
+	}
+
+	if err := c.DeliverMagnificat(ctx, chatID, key, prefs.Readings(magnificat), api.Language(prefs.Language)); err != nil {
+		if reason, unreachable := controller.UnreachableReason(err); unreachable {
+			sugar.Infow("chat is unreachable, skipping on demand delivery", "chat_id", chatID, "reason", reason)
+			return nil
+		}
+		if markErr := c.MarkFailed(ctx, chatID, key, err.Error()); markErr != nil {
+			sugar.Warnw("error recording failed delivery", "chat_id", chatID, "error", markErr.Error())
+		}
+		return fmt.Errorf("error delivering magnificat %s: %w", key, err)
+	}
+	sugar.Debugw(
+		"successfully delivered magnificat on demand",
+		"day",
+		magnificat.Day,
+		"chat_id",
+		chatID,
+		"sqs_message_id",
+		r.MessageId,
+	)
+
+	day, err := time.Parse("2006-01-02", magnificat.Date)
+	if err != nil {
+		sugar.Warnw("error parsing date of the magnificat", "date", magnificat.Date, "error", err.Error())
+		return nil
+	}
+	if err := sendNavigation(ctx, id, day, api.Language(prefs.Language)); err != nil {
+		sugar.Warnw("error sending navigation buttons", "chat_id", chatID, "error", err.Error())
 	}
 	return nil
 }
 
+// sendNavigation sends the buttons to get the lectures of the previous and the next day
+func sendNavigation(ctx context.Context, chatID int64, day time.Time, lang api.Language) error {
+	buttons := []api.InlineKeyboardButton{}
+	for _, nav := range []struct {
+		text string
+		day  time.Time
+	}{
+		{text: lang.Choose("◀ Día anterior", "◀ Previous day"), day: day.AddDate(0, 0, -1)},
+		{text: lang.Choose("Día siguiente ▶", "Next day ▶"), day: day.AddDate(0, 0, 1)},
+	} {
+		data, err := api.SignCallbackData(secret, chatID, api.DayCallbackAction, nav.day.Format("2006-01-02"))
+		if err != nil {
+			return err
+		}
+		buttons = append(buttons, api.InlineKeyboardButton{Text: nav.text, CallbackData: data})
+	}
+
+	_, err := c.SendTelegramKeyboard(
+		ctx,
+		fmt.Sprintf("%d", chatID),
+		utils.EscapeMarkdownV2(lang.Choose("¿Quieres leer las lecturas de otro día?", "Do you want to read the readings of another day?")),
+		&api.InlineKeyboardMarkup{InlineKeyboard: [][]api.InlineKeyboardButton{buttons}},
+	)
+	return err
+}
+
+// announce sends a broadcast of the operators of the bot to the chat of an SQS message,
+// counting it in the statistics of the broadcast. Paused chats get it too, since
+// broadcasts are not daily deliveries.
+func announce(ctx context.Context, r events.SQSMessage, id int64, broadcast *controller.BroadcastMessage) error {
+	chatID := strconv.FormatInt(id, 10)
+
+	if _, err := c.SendTelegram(ctx, chatID, utils.EscapeMarkdownV2(broadcast.Text)); err != nil {
+		reason, unreachable := controller.UnreachableReason(err)
+		if !unreachable {
+			return fmt.Errorf("error sending broadcast %s to chat %s: %w", broadcast.BroadcastID, chatID, err)
+		}
+		if err := c.RecordBroadcastDelivery(ctx, broadcast.BroadcastID, false); err != nil {
+			sugar.Warnw("error recording failed broadcast", "broadcast_id", broadcast.BroadcastID, "chat_id", chatID, "error", err.Error())
+		}
+		return deactivate(ctx, id, reason)
+	}
+
+	if err := c.RecordBroadcastDelivery(ctx, broadcast.BroadcastID, true); err != nil {
+		sugar.Warnw("error recording broadcast delivery", "broadcast_id", broadcast.BroadcastID, "chat_id", chatID, "error", err.Error())
+	}
+	sugar.Debugw(
+		"successfully delivered broadcast",
+		"broadcast_id",
+		broadcast.BroadcastID,
+		"chat_id",
+		chatID,
+		"sqs_message_id",
+		r.MessageId,
+	)
+	return nil
+}
+
+// welcomeBack greets a chat whose pause has ended and clears the pause, so
+// that the greeting is not repeated if the delivery is retried
+func welcomeBack(ctx context.Context, sub *controller.Subscription) error {
+	chatID := strconv.FormatInt(sub.ChatID, 10)
+	text := api.Language(sub.Preferences.Language).Choose(
+		"¡Bienvenido de vuelta! Tu pausa ha terminado y vuelvo a enviarte las lecturas de cada día.",
+		"Welcome back! Your pause is over and I am sending you the daily readings again.",
+	)
+	if _, err := c.SendTelegram(ctx, chatID, utils.EscapeMarkdownV2(text)); err != nil {
+		return fmt.Errorf("error welcoming back chat %s: %w", chatID, err)
+	}
+	return c.Resume(ctx, sub.ChatID)
+}
+
+// deactivate marks the subscription of a chat that can no longer be reached as
+// inactive. Retrying the delivery would fail forever, so the message is only
+// reported as failed if the subscription can't be updated.
+func deactivate(ctx context.Context, chatID int64, reason string) error {
+	sugar.Infow("chat is unreachable, deactivating subscription", "chat_id", chatID, "reason", reason)
+	return c.Deactivate(ctx, chatID, reason, time.Now().Unix())
+}
+
 func main() {
 	lambda.Start(Handler)
 }
@@ -0,0 +1,7 @@
+//go:build sqlstore
+// +build sqlstore
+
+package main
+
+// The SQL subscriber stores are only available in the builds with the sqlstore tag
+import _ "github.com/igvaquero18/magnifibot/controller/sqlstore"
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxCallbackData is the maximum length in bytes of the data of a button
+	maxCallbackData = 64
+
+	// callbackSignatureLength is the number of characters of the signature
+	// appended to the data of a button
+	callbackSignatureLength = 8
+
+	// CallbackSecretPurpose is the purpose used to derive the secret of the
+	// buttons from the bot token, when none is configured
+	CallbackSecretPurpose = "callbacks"
+
+	// DayCallbackAction is the action of the buttons that send the
+	// lectures of the day given as argument
+	DayCallbackAction = "day"
+)
+
+var callbackActionRegex = regexp.MustCompile(`^[a-z]{1,8}$`)
+
+// CallbackHandlerFunc handles a callback query, returning the message to reply
+// with, if any. Returning a message with the editMessageText method replaces
+// the message that holds the keyboard.
+type CallbackHandlerFunc func(ctx context.Context, cb *Callback) (*TelegramWebhookSendMessage, error)
+
+// Callback is a callback query sent when a user taps a button of an inline keyboard
+type Callback struct {
+	// ID is the identifier of the callback query, used to answer it
+	ID string
+
+	ChatID int64
+	UserID int64
+
+	// MessageID is the message that holds the keyboard
+	MessageID int64
+
+	// Kind is the type of the chat, like private, group or channel
+	Kind string
+
+	// Language is the language of the user
+	Language Language
+
+	// Data is the data of the button, as received from Telegram
+	Data string
+
+	// Action and Arg are the contents of the data, set by the Router
+	Action string
+	Arg    string
+
+	// Notification is the text shown to the user when answering
+	// the callback query, set by the handler
+	Notification string
+}
+
+// Reply returns a message sent to the chat of the callback
+func (cb *Callback) Reply(text string) *TelegramWebhookSendMessage {
+	return &TelegramWebhookSendMessage{
+		Method: "sendMessage",
+		ChatID: cb.ChatID,
+		Text:   text,
+	}
+}
+
+// Edit returns a message that replaces the one holding the keyboard
+func (cb *Callback) Edit(text string) *TelegramWebhookSendMessage {
+	return &TelegramWebhookSendMessage{
+		Method:    "editMessageText",
+		ChatID:    cb.ChatID,
+		MessageID: cb.MessageID,
+		Text:      text,
+	}
+}
+
+// SignCallbackData builds the data of a button as action:arg:signature. The signature
+// is a truncated HMAC of the data and the chat ID, so that buttons can't be forged
+// nor replayed in other chats. The whole data must fit in 64 bytes.
+func SignCallbackData(secret []byte, chatID int64, action, arg string) (string, error) {
+	payload := fmt.Sprintf("%s:%s", action, arg)
+	data := fmt.Sprintf("%s:%s", payload, callbackSignature(secret, chatID, payload))
+	if len(data) > maxCallbackData {
+		return "", fmt.Errorf("error signing callback data %s: longer than %d bytes", payload, maxCallbackData)
+	}
+	return data, nil
+}
+
+// ParseCallbackData verifies the signature of the data of a button pressed in
+// a chat, returning its action and argument.
+func ParseCallbackData(secret []byte, chatID int64, data string) (string, string, error) {
+	i := strings.LastIndex(data, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("error parsing callback data %s: missing signature", data)
+	}
+	payload, signature := data[:i], data[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(callbackSignature(secret, chatID, payload))) {
+		return "", "", fmt.Errorf("error parsing callback data %s: invalid signature", data)
+	}
+
+	parts := strings.SplitN(payload, ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("error parsing callback data %s: missing argument", data)
+	}
+	return parts[0], parts[1], nil
+}
+
+func callbackSignature(secret []byte, chatID int64, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d:%s", chatID, payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:callbackSignatureLength]
+}
+
+// SetCallbackSecret sets the secret used to sign the data of the buttons
+func (r *Router) SetCallbackSecret(secret []byte) {
+	r.secret = secret
+}
+
+// HandleCallback registers the handler of the buttons with the given action,
+// which must be a short lowercase word to leave room for the argument.
+func (r *Router) HandleCallback(action string, handler CallbackHandlerFunc) error {
+	if !callbackActionRegex.MatchString(action) {
+		return fmt.Errorf("error registering callback: invalid action %s", action)
+	}
+	if _, ok := r.callbacks[action]; ok {
+		return fmt.Errorf("error registering callback: action %s already registered", action)
+	}
+	r.callbacks[action] = handler
+	return nil
+}
+
+// Button returns a button of an inline keyboard that calls the handler of
+// the action with the given argument when it is tapped in the chat.
+func (r *Router) Button(chatID int64, text, action, arg string) (InlineKeyboardButton, error) {
+	data, err := SignCallbackData(r.secret, chatID, action, arg)
+	if err != nil {
+		return InlineKeyboardButton{}, err
+	}
+	return InlineKeyboardButton{Text: text, CallbackData: data}, nil
+}
+
+// RouteCallback verifies the data of a callback query and calls the handler of its
+// action. Buttons with invalid data only get a notification explaining it.
+func (r *Router) RouteCallback(ctx context.Context, cb *Callback) (*TelegramWebhookSendMessage, error) {
+	if cb.Language == "" {
+		cb.Language = DefaultLanguage
+	}
+
+	action, arg, err := ParseCallbackData(r.secret, cb.ChatID, cb.Data)
+	handler, ok := r.callbacks[action]
+	if err != nil || !ok {
+		cb.Notification = cb.Language.Choose("Este botón ya no es válido.", "This button is no longer valid.")
+		return nil, nil
+	}
+
+	cb.Action, cb.Arg = action, arg
+	return handler(ctx, cb)
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testSecret = []byte("secret")
+
+func TestSignCallbackData(t *testing.T) {
+	tests := []struct {
+		name          string
+		action        string
+		arg           string
+		errorExpected bool
+	}{
+		{
+			name:          "valid data",
+			action:        "day",
+			arg:           "2022-03-19",
+			errorExpected: false,
+		},
+		{
+			name:          "empty argument",
+			action:        "unsub",
+			arg:           "",
+			errorExpected: false,
+		},
+		{
+			name:          "too long data",
+			action:        "day",
+			arg:           strings.Repeat("a", 60),
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			data, err := SignCallbackData(testSecret, 12, test.action, test.arg)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.LessOrEqual(tt, len(data), maxCallbackData)
+
+			action, arg, err := ParseCallbackData(testSecret, 12, data)
+			assert.NoError(tt, err)
+			assert.Equal(tt, test.action, action)
+			assert.Equal(tt, test.arg, arg)
+		})
+	}
+}
+
+func TestParseCallbackData(t *testing.T) {
+	valid, _ := SignCallbackData(testSecret, 12, "day", "2022-03-19")
+
+	tests := []struct {
+		name          string
+		chatID        int64
+		data          string
+		errorExpected bool
+	}{
+		{
+			name:          "valid data",
+			chatID:        12,
+			data:          valid,
+			errorExpected: false,
+		},
+		{
+			name:          "data from another chat",
+			chatID:        13,
+			data:          valid,
+			errorExpected: true,
+		},
+		{
+			name:          "tampered data",
+			chatID:        12,
+			data:          strings.Replace(valid, "19", "20", 1),
+			errorExpected: true,
+		},
+		{
+			name:          "missing signature",
+			chatID:        12,
+			data:          "day",
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			_, _, err := ParseCallbackData(testSecret, test.chatID, test.data)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestHandleCallback(t *testing.T) {
+	tests := []struct {
+		name          string
+		action        string
+		errorExpected bool
+	}{
+		{
+			name:          "valid action",
+			action:        "profile",
+			errorExpected: false,
+		},
+		{
+			name:          "already registered action",
+			action:        "day",
+			errorExpected: true,
+		},
+		{
+			name:          "invalid action",
+			action:        "next:day",
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			r := NewRouter("magnifibot_bot")
+			assert.NoError(tt, r.HandleCallback("day", nil))
+			err := r.HandleCallback(test.action, nil)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestRouteCallback(t *testing.T) {
+	r := NewRouter("magnifibot_bot")
+	r.SetCallbackSecret(testSecret)
+	assert.NoError(t, r.HandleCallback("day", func(ctx context.Context, cb *Callback) (*TelegramWebhookSendMessage, error) {
+		cb.Notification = cb.Arg
+		return cb.Edit(cb.Arg), nil
+	}))
+
+	button, err := r.Button(12, "Día siguiente", "day", "2022-03-19")
+	assert.NoError(t, err)
+	unknown, _ := SignCallbackData(testSecret, 12, "unknown", "")
+
+	tests := []struct {
+		name         string
+		callback     *Callback
+		expected     *TelegramWebhookSendMessage
+		notification string
+	}{
+		{
+			name:     "valid callback",
+			callback: &Callback{ChatID: 12, MessageID: 3, Data: button.CallbackData},
+			expected: &TelegramWebhookSendMessage{
+				Method:    "editMessageText",
+				ChatID:    12,
+				MessageID: 3,
+				Text:      "2022-03-19",
+			},
+			notification: "2022-03-19",
+		},
+		{
+			name:         "forged callback",
+			callback:     &Callback{ChatID: 13, MessageID: 3, Data: button.CallbackData, Language: English},
+			expected:     nil,
+			notification: "This button is no longer valid.",
+		},
+		{
+			name:         "unknown action",
+			callback:     &Callback{ChatID: 12, MessageID: 3, Data: unknown},
+			expected:     nil,
+			notification: "Este botón ya no es válido.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			actual, err := r.RouteCallback(context.TODO(), test.callback)
+			assert.NoError(tt, err)
+			assert.Equal(tt, test.expected, actual)
+			assert.Equal(tt, test.notification, test.callback.Notification)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+// InlineQuery is sent when a user types @magnifibot_bot followed by a query in any chat
+type InlineQuery struct {
+	ID       string `json:"id"`
+	From     From   `json:"from"`
+	Query    string `json:"query"`
+	Offset   string `json:"offset"`
+	ChatType string `json:"chat_type,omitempty"`
+}
+
+// InputTextMessageContent is the content of the message sent when
+// the user picks a result of an inline query
+type InputTextMessageContent struct {
+	MessageText           string `json:"message_text"`
+	ParseMode             string `json:"parse_mode,omitempty"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview,omitempty"`
+}
+
+// InlineQueryResultArticle is a result of an inline query that sends a text message
+type InlineQueryResultArticle struct {
+	Type                string                  `json:"type"`
+	ID                  string                  `json:"id"`
+	Title               string                  `json:"title"`
+	Description         string                  `json:"description,omitempty"`
+	InputMessageContent InputTextMessageContent `json:"input_message_content"`
+}
+
+// NewInlineQueryResultArticle returns a result that sends the text, formatted with the parse mode
+func NewInlineQueryResultArticle(id, title, description, text, parseMode string) InlineQueryResultArticle {
+	return InlineQueryResultArticle{
+		Type:        "article",
+		ID:          id,
+		Title:       title,
+		Description: description,
+		InputMessageContent: InputTextMessageContent{
+			MessageText: text,
+			ParseMode:   parseMode,
+		},
+	}
+}
+
+// TelegramWebhookAnswerInlineQuery is the answerInlineQuery method called in
+// response to a webhook request with an inline query
+type TelegramWebhookAnswerInlineQuery struct {
+	Method        string                     `json:"method"`
+	InlineQueryID string                     `json:"inline_query_id"`
+	Results       []InlineQueryResultArticle `json:"results"`
+	CacheTime     int                        `json:"cache_time,omitempty"`
+	IsPersonal    bool                       `json:"is_personal,omitempty"`
+}
+
+// Answer returns the answer to the inline query with the given results
+func (q *InlineQuery) Answer(results []InlineQueryResultArticle, cacheTime int) *TelegramWebhookAnswerInlineQuery {
+	return &TelegramWebhookAnswerInlineQuery{
+		Method:        "answerInlineQuery",
+		InlineQueryID: q.ID,
+		Results:       results,
+		CacheTime:     cacheTime,
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInlineQueryAnswer(t *testing.T) {
+	query := &InlineQuery{ID: "42", Query: "salmo"}
+	answer := query.Answer([]InlineQueryResultArticle{
+		NewInlineQueryResultArticle("2022-01-10-psalm", "Salmo", "Sal 1", "*Salmo*", "MarkdownV2"),
+	}, 300)
+
+	body, err := json.Marshal(answer)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"method": "answerInlineQuery",
+		"inline_query_id": "42",
+		"cache_time": 300,
+		"results": [{
+			"type": "article",
+			"id": "2022-01-10-psalm",
+			"title": "Salmo",
+			"description": "Sal 1",
+			"input_message_content": {"message_text": "*Salmo*", "parse_mode": "MarkdownV2"}
+		}]
+	}`, string(body))
+}
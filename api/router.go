@@ -0,0 +1,395 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Language is a language in which the bot can talk to its users
+type Language string
+
+const (
+	Spanish Language = "es"
+	English Language = "en"
+)
+
+// DefaultLanguage is the language used when the user has not chosen another one
+const DefaultLanguage = Spanish
+
+// Languages are the languages supported by the bot
+var Languages = []Language{Spanish, English}
+
+var commandNameRegex = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// ToLanguage converts a Telegram language code, like "en" or "es-ES", into one of
+// the supported languages, defaulting to Spanish.
+func ToLanguage(code string) Language {
+	if strings.HasPrefix(strings.ToLower(code), string(English)) {
+		return English
+	}
+	return DefaultLanguage
+}
+
+// Choose returns the text written in the language
+func (l Language) Choose(spanish, english string) string {
+	if l == English {
+		return english
+	}
+	return spanish
+}
+
+// Localized is a text translated into the supported languages
+type Localized map[Language]string
+
+// In returns the text in the given language, falling back to the default one
+func (l Localized) In(lang Language) string {
+	if text, ok := l[lang]; ok {
+		return text
+	}
+	return l[DefaultLanguage]
+}
+
+// Arg describes an argument accepted by a command
+type Arg struct {
+	// Name is the name of the argument, as shown in the help
+	Name Localized
+
+	// Required tells whether the command fails when the argument is missing
+	Required bool
+
+	// Rest makes the argument take all the remaining text of the message,
+	// spaces included. It can only be used in the last argument.
+	Rest bool
+
+	// Pattern, when set, is the expression that the value must match
+	Pattern *regexp.Regexp
+}
+
+// Args are the values of the arguments received by a command, by the
+// name of the argument in the default language
+type Args map[string]string
+
+// HandlerFunc handles a command, returning the message to reply with, if any
+type HandlerFunc func(ctx context.Context, req *Request) (*TelegramWebhookSendMessage, error)
+
+// Command is a command understood by the bot
+type Command struct {
+	// Names is the name of the command in each language. The one in the default
+	// language is used as the identifier of the command.
+	Names Localized
+
+	// Aliases are other names accepted for the command
+	Aliases []string
+
+	// Description is what the command does, as shown in the help and
+	// in the Telegram menu of commands
+	Description Localized
+
+	// Args is the list of arguments accepted by the command, in order
+	Args []Arg
+
+	// Hidden commands are not listed in the help nor in the Telegram menu
+	Hidden bool
+
+	// Handler is the function called when the command is received
+	Handler HandlerFunc
+}
+
+// Name returns the identifier of the command
+func (c *Command) Name() string {
+	return c.Names.In(DefaultLanguage)
+}
+
+// Usage returns how the command is written, including its arguments
+func (c *Command) Usage(lang Language) string {
+	usage := []string{"/" + c.Names.In(lang)}
+	for _, arg := range c.Args {
+		if arg.Required {
+			usage = append(usage, fmt.Sprintf("<%s>", arg.Name.In(lang)))
+		} else {
+			usage = append(usage, fmt.Sprintf("[%s]", arg.Name.In(lang)))
+		}
+	}
+	return strings.Join(usage, " ")
+}
+
+// Request is a command received by the bot
+type Request struct {
+	// Text is the whole text of the message
+	Text string
+
+	// Channel tells whether the message is a channel post, in which case
+	// the command must mention the bot explicitly
+	Channel bool
+
+	ChatID int64
+	UserID int64
+	Date   int64
+
+	// Kind is the type of the chat, like private, group or channel
+	Kind string
+
+	// Title and Username identify the chat, when it has them
+	Title    string
+	Username string
+
+	// Language is the language of the user. It is replaced by the language
+	// of the name of the command, when it only exists in one language.
+	Language Language
+
+	// Command is the command received, set by the Router
+	Command *Command
+
+	// Args are the arguments of the command, set by the Router
+	Args Args
+}
+
+// Reply returns a message replying to the chat of the request
+func (r *Request) Reply(text string) *TelegramWebhookSendMessage {
+	return &TelegramWebhookSendMessage{
+		Method: "sendMessage",
+		ChatID: r.ChatID,
+		Text:   text,
+	}
+}
+
+// BotCommand is a command as shown in the Telegram menu of commands
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// SetMyCommands is the payload of the setMyCommands method of the Telegram API
+type SetMyCommands struct {
+	Commands     []BotCommand `json:"commands"`
+	LanguageCode string       `json:"language_code,omitempty"`
+}
+
+// Router dispatches the commands received by the bot to their handlers
+type Router struct {
+	botName   string
+	commands  []*Command
+	names     map[string]*Command
+	fallback  HandlerFunc
+	secret    []byte
+	callbacks map[string]CallbackHandlerFunc
+}
+
+// NewRouter returns a Router for the bot with the given username
+func NewRouter(botName string) *Router {
+	r := &Router{
+		botName:   botName,
+		names:     map[string]*Command{},
+		callbacks: map[string]CallbackHandlerFunc{},
+	}
+	r.fallback = func(ctx context.Context, req *Request) (*TelegramWebhookSendMessage, error) {
+		return req.Reply(req.Language.Choose(
+			"Lo siento, solo acepto comandos de Telegram.",
+			"Sorry, I only understand Telegram commands.",
+		)), nil
+	}
+	return r
+}
+
+// Register adds commands to the router. It fails when a name is not valid for
+// Telegram or is already taken by another command.
+func (r *Router) Register(commands ...*Command) error {
+	for _, cmd := range commands {
+		if cmd.Handler == nil {
+			return fmt.Errorf("error registering command %s: missing handler", cmd.Name())
+		}
+		if cmd.Description.In(DefaultLanguage) == "" {
+			return fmt.Errorf("error registering command %s: missing description", cmd.Name())
+		}
+		for i, arg := range cmd.Args {
+			if arg.Rest && i != len(cmd.Args)-1 {
+				return fmt.Errorf("error registering command %s: only the last argument can take the rest", cmd.Name())
+			}
+		}
+
+		names := append([]string{}, cmd.Aliases...)
+		for _, lang := range Languages {
+			if name, ok := cmd.Names[lang]; ok {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("error registering command: missing name")
+		}
+
+		for _, name := range names {
+			if !commandNameRegex.MatchString(name) {
+				return fmt.Errorf("error registering command %s: invalid name %s", cmd.Name(), name)
+			}
+			if other, ok := r.names[name]; ok && other != cmd {
+				return fmt.Errorf("error registering command %s: name %s already used by %s", cmd.Name(), name, other.Name())
+			}
+		}
+		for _, name := range names {
+			r.names[name] = cmd
+		}
+		r.commands = append(r.commands, cmd)
+	}
+	return nil
+}
+
+// HandleText sets the handler called with the messages that are not commands
+func (r *Router) HandleText(handler HandlerFunc) {
+	r.fallback = handler
+}
+
+// Commands returns the registered commands, in order of registration
+func (r *Router) Commands() []*Command {
+	return r.commands
+}
+
+// Lookup returns the command with the given name or alias
+func (r *Router) Lookup(name string) (*Command, bool) {
+	cmd, ok := r.names[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// Route parses the text of the request and calls the handler of the command.
+// Commands addressed to other bots are ignored, returning no message.
+func (r *Router) Route(ctx context.Context, req *Request) (*TelegramWebhookSendMessage, error) {
+	if req.Language == "" {
+		req.Language = DefaultLanguage
+	}
+
+	text := strings.TrimSpace(req.Text)
+	if !strings.HasPrefix(text, "/") {
+		if req.Channel {
+			return nil, nil
+		}
+		return r.fallback(ctx, req)
+	}
+
+	head, rest := text, ""
+	if i := strings.IndexAny(text, " \n\t"); i >= 0 {
+		head, rest = text[:i], strings.TrimSpace(text[i+1:])
+	}
+
+	name, mention := strings.TrimPrefix(head, "/"), ""
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, mention = name[:i], name[i+1:]
+	}
+	if (mention != "" || req.Channel) && !strings.EqualFold(mention, r.botName) {
+		return nil, nil
+	}
+
+	cmd, ok := r.Lookup(name)
+	if !ok {
+		return req.Reply(fmt.Sprintf(
+			"%s\n\n%s",
+			req.Language.Choose("Lo siento, no conozco ese comando.", "Sorry, I don't know that command."),
+			r.Help(req.Language),
+		)), nil
+	}
+
+	req.Command = cmd
+	req.Language = commandLanguage(cmd, strings.ToLower(name), req.Language)
+
+	args, err := parseArgs(cmd, rest)
+	if err != nil {
+		return req.Reply(fmt.Sprintf(
+			"%s\n\n%s: %s",
+			req.Language.Choose("Lo siento, los argumentos no son válidos.", "Sorry, the arguments are not valid."),
+			req.Language.Choose("Uso", "Usage"),
+			cmd.Usage(req.Language),
+		)), nil
+	}
+	req.Args = args
+
+	return cmd.Handler(ctx, req)
+}
+
+// Help returns the list of visible commands in the given language
+func (r *Router) Help(lang Language) string {
+	lines := []string{lang.Choose("Estos son los comandos que acepto:", "These are the commands I understand:")}
+	for _, cmd := range r.commands {
+		if cmd.Hidden {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s - %s", cmd.Usage(lang), cmd.Description.In(lang)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SetMyCommands returns the payloads to register the menu of commands in Telegram:
+// one for the default language, applied to every user, and one per other language.
+func (r *Router) SetMyCommands() []SetMyCommands {
+	payloads := []SetMyCommands{}
+	for _, lang := range Languages {
+		payload := SetMyCommands{Commands: []BotCommand{}}
+		if lang != DefaultLanguage {
+			payload.LanguageCode = string(lang)
+		}
+		for _, cmd := range r.commands {
+			if cmd.Hidden {
+				continue
+			}
+			payload.Commands = append(payload.Commands, BotCommand{
+				Command:     cmd.Names.In(lang),
+				Description: cmd.Description.In(lang),
+			})
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+// commandLanguage returns the language of the name used to call the command,
+// or the given one when the name is shared by several languages or is an alias
+func commandLanguage(cmd *Command, name string, lang Language) Language {
+	if cmd.Names.In(lang) == name {
+		return lang
+	}
+	for _, l := range Languages {
+		if cmd.Names[l] == name {
+			return l
+		}
+	}
+	return lang
+}
+
+func parseArgs(cmd *Command, text string) (Args, error) {
+	args := Args{}
+	fields := strings.Fields(text)
+
+	for i, arg := range cmd.Args {
+		name := arg.Name.In(DefaultLanguage)
+		if i >= len(fields) {
+			if arg.Required {
+				return nil, fmt.Errorf("missing argument %s", name)
+			}
+			continue
+		}
+
+		value := fields[i]
+		if arg.Rest {
+			value = restOfText(text, i)
+		}
+		if arg.Pattern != nil && !arg.Pattern.MatchString(value) {
+			return nil, fmt.Errorf("invalid argument %s: %s", name, value)
+		}
+		args[name] = value
+	}
+
+	if len(fields) > len(cmd.Args) && (len(cmd.Args) == 0 || !cmd.Args[len(cmd.Args)-1].Rest) {
+		return nil, fmt.Errorf("too many arguments")
+	}
+	return args, nil
+}
+
+// restOfText returns the text starting at the nth word, keeping its spaces
+func restOfText(text string, n int) string {
+	for i := 0; i < n; i++ {
+		text = strings.TrimLeft(text, " \n\t")
+		if j := strings.IndexAny(text, " \n\t"); j >= 0 {
+			text = text[j:]
+		}
+	}
+	return strings.TrimSpace(text)
+}
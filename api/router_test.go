@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func echoHandler(ctx context.Context, req *Request) (*TelegramWebhookSendMessage, error) {
+	return req.Reply(req.Language.Choose("hecho", "done")), nil
+}
+
+func testRouter(t *testing.T) *Router {
+	r := NewRouter("magnifibot_bot")
+	err := r.Register(
+		&Command{
+			Names:       Localized{Spanish: "suscribirme", English: "subscribe"},
+			Aliases:     []string{"alta"},
+			Description: Localized{Spanish: "Recibir el Evangelio cada día", English: "Get the Gospel every day"},
+			Handler:     echoHandler,
+		},
+		&Command{
+			Names:       Localized{Spanish: "pausar", English: "pause"},
+			Description: Localized{Spanish: "Pausar los envíos", English: "Pause the deliveries"},
+			Args: []Arg{
+				{
+					Name:     Localized{Spanish: "duración", English: "duration"},
+					Required: true,
+					Pattern:  regexp.MustCompile(`^\d+d$`),
+				},
+			},
+			Handler: echoHandler,
+		},
+		&Command{
+			Names:       Localized{Spanish: "anunciar"},
+			Description: Localized{Spanish: "Enviar un anuncio"},
+			Args:        []Arg{{Name: Localized{Spanish: "texto"}, Required: true, Rest: true}},
+			Hidden:      true,
+			Handler: func(ctx context.Context, req *Request) (*TelegramWebhookSendMessage, error) {
+				return req.Reply(req.Args["texto"]), nil
+			},
+		},
+	)
+	assert.NoError(t, err)
+	return r
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name          string
+		command       *Command
+		errorExpected bool
+	}{
+		{
+			name: "valid command",
+			command: &Command{
+				Names:       Localized{Spanish: "obtener", English: "gospel"},
+				Description: Localized{Spanish: "Obtener el Evangelio"},
+				Handler:     echoHandler,
+			},
+			errorExpected: false,
+		},
+		{
+			name: "name already used",
+			command: &Command{
+				Names:       Localized{Spanish: "alta"},
+				Description: Localized{Spanish: "Darse de alta"},
+				Handler:     echoHandler,
+			},
+			errorExpected: true,
+		},
+		{
+			name: "invalid name",
+			command: &Command{
+				Names:       Localized{Spanish: "Obtener-Evangelio"},
+				Description: Localized{Spanish: "Obtener el Evangelio"},
+				Handler:     echoHandler,
+			},
+			errorExpected: true,
+		},
+		{
+			name: "missing description",
+			command: &Command{
+				Names:   Localized{Spanish: "obtener"},
+				Handler: echoHandler,
+			},
+			errorExpected: true,
+		},
+		{
+			name: "missing handler",
+			command: &Command{
+				Names:       Localized{Spanish: "obtener"},
+				Description: Localized{Spanish: "Obtener el Evangelio"},
+			},
+			errorExpected: true,
+		},
+		{
+			name: "rest argument not in last place",
+			command: &Command{
+				Names:       Localized{Spanish: "obtener"},
+				Description: Localized{Spanish: "Obtener el Evangelio"},
+				Args: []Arg{
+					{Name: Localized{Spanish: "texto"}, Rest: true},
+					{Name: Localized{Spanish: "fecha"}},
+				},
+				Handler: echoHandler,
+			},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			err := testRouter(tt).Register(test.command)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  *Request
+		expected *TelegramWebhookSendMessage
+		command  string
+		args     Args
+	}{
+		{
+			name:     "spanish command",
+			request:  &Request{Text: "/suscribirme", ChatID: 12},
+			expected: &TelegramWebhookSendMessage{Method: "sendMessage", ChatID: 12, Text: "hecho"},
+			command:  "suscribirme",
+			args:     Args{},
+		},
+		{
+			name:     "english command",
+			request:  &Request{Text: "/subscribe", ChatID: 12, Language: Spanish},
+			expected: &TelegramWebhookSendMessage{Method: "sendMessage", ChatID: 12, Text: "done"},
+			command:  "suscribirme",
+			args:     Args{},
+		},
+		{
+			name:     "alias keeps the language of the user",
+			request:  &Request{Text: "/alta", ChatID: 12, Language: English},
+			expected: &TelegramWebhookSendMessage{Method: "sendMessage", ChatID: 12, Text: "done"},
+			command:  "suscribirme",
+			args:     Args{},
+		},
+		{
+			name:     "command mentioning the bot",
+			request:  &Request{Text: "/suscribirme@magnifibot_bot", ChatID: 12},
+			expected: &TelegramWebhookSendMessage{Method: "sendMessage", ChatID: 12, Text: "hecho"},
+			command:  "suscribirme",
+			args:     Args{},
+		},
+		{
+			name:     "command mentioning another bot",
+			request:  &Request{Text: "/suscribirme@other_bot", ChatID: 12},
+			expected: nil,
+		},
+		{
+			name:     "channel post without mention",
+			request:  &Request{Text: "/suscribirme", ChatID: 12, Channel: true},
+			expected: nil,
+		},
+		{
+			name:     "channel post mentioning the bot",
+			request:  &Request{Text: "/suscribirme@magnifibot_bot", ChatID: 12, Channel: true},
+			expected: &TelegramWebhookSendMessage{Method: "sendMessage", ChatID: 12, Text: "hecho"},
+			command:  "suscribirme",
+			args:     Args{},
+		},
+		{
+			name:     "command with arguments",
+			request:  &Request{Text: "/pausar 7d", ChatID: 12},
+			expected: &TelegramWebhookSendMessage{Method: "sendMessage", ChatID: 12, Text: "hecho"},
+			command:  "pausar",
+			args:     Args{"duración": "7d"},
+		},
+		{
+			name:    "missing argument",
+			request: &Request{Text: "/pause", ChatID: 12},
+			expected: &TelegramWebhookSendMessage{
+				Method: "sendMessage",
+				ChatID: 12,
+				Text:   "Sorry, the arguments are not valid.\n\nUsage: /pause <duration>",
+			},
+			command: "pausar",
+		},
+		{
+			name:    "invalid argument",
+			request: &Request{Text: "/pausar siempre", ChatID: 12},
+			expected: &TelegramWebhookSendMessage{
+				Method: "sendMessage",
+				ChatID: 12,
+				Text:   "Lo siento, los argumentos no son válidos.\n\nUso: /pausar <duración>",
+			},
+			command: "pausar",
+		},
+		{
+			name:    "too many arguments",
+			request: &Request{Text: "/suscribirme ahora", ChatID: 12},
+			expected: &TelegramWebhookSendMessage{
+				Method: "sendMessage",
+				ChatID: 12,
+				Text:   "Lo siento, los argumentos no son válidos.\n\nUso: /suscribirme",
+			},
+			command: "suscribirme",
+		},
+		{
+			name:     "argument taking the rest of the text",
+			request:  &Request{Text: "/anunciar ¡Feliz  Navidad!\nA todos", ChatID: 12},
+			expected: &TelegramWebhookSendMessage{Method: "sendMessage", ChatID: 12, Text: "¡Feliz  Navidad!\nA todos"},
+			command:  "anunciar",
+			args:     Args{"texto": "¡Feliz  Navidad!\nA todos"},
+		},
+		{
+			name:    "unknown command",
+			request: &Request{Text: "/unknown", ChatID: 12, Language: English},
+			expected: &TelegramWebhookSendMessage{
+				Method: "sendMessage",
+				ChatID: 12,
+				Text: "Sorry, I don't know that command.\n\n" +
+					"These are the commands I understand:\n" +
+					"/subscribe - Get the Gospel every day\n" +
+					"/pause <duration> - Pause the deliveries",
+			},
+		},
+		{
+			name:     "not a command",
+			request:  &Request{Text: "hola", ChatID: 12},
+			expected: &TelegramWebhookSendMessage{Method: "sendMessage", ChatID: 12, Text: "Lo siento, solo acepto comandos de Telegram."},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			actual, err := testRouter(tt).Route(context.TODO(), test.request)
+			assert.NoError(tt, err)
+			assert.Equal(tt, test.expected, actual)
+			if test.command != "" {
+				assert.Equal(tt, test.command, test.request.Command.Name())
+				assert.Equal(tt, test.args, test.request.Args)
+			}
+		})
+	}
+}
+
+func TestHelp(t *testing.T) {
+	tests := []struct {
+		name     string
+		language Language
+		expected string
+	}{
+		{
+			name:     "spanish help",
+			language: Spanish,
+			expected: "Estos son los comandos que acepto:\n" +
+				"/suscribirme - Recibir el Evangelio cada día\n" +
+				"/pausar <duración> - Pausar los envíos",
+		},
+		{
+			name:     "english help",
+			language: English,
+			expected: "These are the commands I understand:\n" +
+				"/subscribe - Get the Gospel every day\n" +
+				"/pause <duration> - Pause the deliveries",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			assert.Equal(tt, test.expected, testRouter(tt).Help(test.language))
+		})
+	}
+}
+
+func TestSetMyCommands(t *testing.T) {
+	expected := []SetMyCommands{
+		{
+			Commands: []BotCommand{
+				{Command: "suscribirme", Description: "Recibir el Evangelio cada día"},
+				{Command: "pausar", Description: "Pausar los envíos"},
+			},
+		},
+		{
+			Commands: []BotCommand{
+				{Command: "subscribe", Description: "Get the Gospel every day"},
+				{Command: "pause", Description: "Pause the deliveries"},
+			},
+			LanguageCode: "en",
+		},
+	}
+	assert.Equal(t, expected, testRouter(t).SetMyCommands())
+}
+
+func TestToLanguage(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected Language
+	}{
+		{code: "es", expected: Spanish},
+		{code: "en-GB", expected: English},
+		{code: "it", expected: Spanish},
+		{code: "", expected: Spanish},
+	}
+
+	for _, test := range tests {
+		t.Run(test.code, func(tt *testing.T) {
+			assert.Equal(tt, test.expected, ToLanguage(test.code))
+		})
+	}
+}
@@ -1,14 +1,37 @@
 package api
 
-// TelegramWebhookSendMessage is a struct that
+// TelegramWebhookSendMessage is a struct that holds the method called in response to
+// a webhook request. It is used both to send new messages with sendMessage and to
+// replace existing ones with editMessageText, in which case MessageID is set.
 type TelegramWebhookSendMessage struct {
-	Method                   string `json:"method"`
-	ChatID                   int64  `json:"chat_id"`
-	Text                     string `json:"text"`
-	ParseMode                string `json:"parse_mode,omitempty"`
-	DisableWebPagePreview    bool   `json:"disable_web_page_preview,omitempty"`
-	DisableNotification      bool   `json:"disable_notification,omitempty"`
-	ProtectContent           bool   `json:"protect_content,omitempty"`
-	ReplyToMessageID         int64  `json:"reply_to_message_id,omitempty"`
-	AllowSendingWithoutReply bool   `json:"allow_sending_without_reply,omitempty"`
+	Method                   string                `json:"method"`
+	ChatID                   int64                 `json:"chat_id"`
+	MessageID                int64                 `json:"message_id,omitempty"`
+	Text                     string                `json:"text"`
+	ParseMode                string                `json:"parse_mode,omitempty"`
+	DisableWebPagePreview    bool                  `json:"disable_web_page_preview,omitempty"`
+	DisableNotification      bool                  `json:"disable_notification,omitempty"`
+	ProtectContent           bool                  `json:"protect_content,omitempty"`
+	ReplyToMessageID         int64                 `json:"reply_to_message_id,omitempty"`
+	AllowSendingWithoutReply bool                  `json:"allow_sending_without_reply,omitempty"`
+	ReplyMarkup              *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+// WithKeyboard attaches an inline keyboard with the given rows of buttons to the message
+func (t *TelegramWebhookSendMessage) WithKeyboard(rows ...[]InlineKeyboardButton) *TelegramWebhookSendMessage {
+	t.ReplyMarkup = &InlineKeyboardMarkup{InlineKeyboard: rows}
+	return t
+}
+
+// InlineKeyboardMarkup is a keyboard of buttons shown below a message
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// InlineKeyboardButton is a button of an inline keyboard, that either opens
+// a URL or sends a callback query with its data to the bot
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
 }
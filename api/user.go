@@ -17,11 +17,12 @@ type Chat struct {
 }
 
 type UserMessage struct {
-	MessageID int64  `json:"message_id"`
-	From      From   `json:"from"`
-	Chat      Chat   `json:"chat"`
-	Date      int64  `json:"date"`
-	Text      string `json:"text"`
+	MessageID  int64       `json:"message_id"`
+	From       From        `json:"from"`
+	SenderChat *SenderChat `json:"sender_chat,omitempty"`
+	Chat       Chat        `json:"chat"`
+	Date       int64       `json:"date"`
+	Text       string      `json:"text"`
 }
 
 type SenderChat struct {
@@ -45,8 +46,18 @@ type ChannelPost struct {
 	Entities   []Entities `json:"entities"`
 }
 
+type CallbackQuery struct {
+	ID           string       `json:"id"`
+	From         From         `json:"from"`
+	Message      *UserMessage `json:"message,omitempty"`
+	ChatInstance string       `json:"chat_instance"`
+	Data         string       `json:"data,omitempty"`
+}
+
 type Update struct {
-	UpdateID    int64        `json:"update_id"`
-	Message     *UserMessage `json:"message,omitempty"`
-	ChannelPost *ChannelPost `json:"channel_post,omitempty"`
+	UpdateID      int64          `json:"update_id"`
+	Message       *UserMessage   `json:"message,omitempty"`
+	ChannelPost   *ChannelPost   `json:"channel_post,omitempty"`
+	CallbackQuery *CallbackQuery `json:"callback_query,omitempty"`
+	InlineQuery   *InlineQuery   `json:"inline_query,omitempty"`
 }
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+	"strings"
+)
+
+const (
+	// SecretTokenHeader is the header in which Telegram sends the secret
+	// token registered along with the webhook
+	SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+	// WebhookSecretPurpose is the purpose used to derive the secret token
+	// of the webhook from the bot token, when none is configured
+	WebhookSecretPurpose = "webhook"
+)
+
+// secretTokenRegex are the secret tokens accepted by Telegram
+var secretTokenRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// WebhookSecretToken returns the secret token to register along with the webhook.
+// Secrets with characters not accepted by Telegram, like derived ones, are encoded.
+func WebhookSecretToken(secret []byte) string {
+	if secretTokenRegex.Match(secret) {
+		return string(secret)
+	}
+	return base64.RawURLEncoding.EncodeToString(secret)
+}
+
+// ValidSecretToken tells whether the headers of a webhook request carry the
+// given secret token. The name of the header is case insensitive, since
+// proxies may change it.
+func ValidSecretToken(headers map[string]string, token string) bool {
+	for name, value := range headers {
+		if strings.EqualFold(name, SecretTokenHeader) {
+			return subtle.ConstantTimeCompare([]byte(value), []byte(token)) == 1
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookSecretToken(t *testing.T) {
+	assert.Equal(t, "my-secret_1", WebhookSecretToken([]byte("my-secret_1")))
+	assert.Equal(t, "AP8", WebhookSecretToken([]byte{0x00, 0xff}))
+	assert.Regexp(t, secretTokenRegex, WebhookSecretToken([]byte("not valid!")))
+}
+
+func TestValidSecretToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected bool
+	}{
+		{
+			name:     "valid token",
+			headers:  map[string]string{"X-Telegram-Bot-Api-Secret-Token": "secret"},
+			expected: true,
+		},
+		{
+			name:     "lowercase header",
+			headers:  map[string]string{"x-telegram-bot-api-secret-token": "secret"},
+			expected: true,
+		},
+		{
+			name:     "invalid token",
+			headers:  map[string]string{"X-Telegram-Bot-Api-Secret-Token": "secreto"},
+			expected: false,
+		},
+		{
+			name:     "missing header",
+			headers:  map[string]string{"Content-Type": "application/json"},
+			expected: false,
+		},
+		{
+			name:     "no headers",
+			headers:  nil,
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			assert.Equal(tt, test.expected, ValidSecretToken(test.headers, "secret"))
+		})
+	}
+}
@@ -53,11 +53,15 @@ type Gospel struct {
 // Magnificat is a struct that groups together all the
 // lectures for a particular Day
 type Magnificat struct {
+	Date          string  `json:"date,omitempty"`
 	Day           string  `json:"day"`
 	FirstLecture  *Gospel `json:"first_lecture"`
 	Psalm         *Gospel `json:"psalm"`
 	SecondLecture *Gospel `json:"second_lecture,omitempty"`
 	Gosp          *Gospel `json:"gospel"`
+
+	// Preview marks the lectures of an upcoming Sunday sent in advance
+	Preview bool `json:"preview,omitempty"`
 }
 
 // Option is a function to apply settings to Client structure
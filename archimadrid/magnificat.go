@@ -0,0 +1,54 @@
+package archimadrid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrMissingLectures is returned along with a Magnificat that holds the Gospel
+// but lacks some of the other lectures, because they could not be got
+var ErrMissingLectures = errors.New("missing lectures")
+
+// GetMagnificat gets all the lectures of a day. The Gospel is required, but if
+// any other lecture fails, the Magnificat is returned without it along with an
+// error wrapping ErrMissingLectures, so that callers can still deliver the rest.
+func GetMagnificat(ctx context.Context, a Archimadrid, day time.Time) (*Magnificat, error) {
+	gospel, err := a.GetGospel(ctx, day)
+	if err != nil {
+		return nil, fmt.Errorf("error getting gospel: %w", err)
+	}
+
+	magnificat := &Magnificat{
+		Date: day.Format("2006-01-02"),
+		Day:  gospel.Day,
+		Gosp: gospel,
+	}
+
+	missing := []string{}
+	firstLecture, err := a.GetFirstLecture(ctx, day)
+	if err != nil {
+		missing = append(missing, fmt.Sprintf("error getting first lecture: %s", err.Error()))
+	} else {
+		magnificat.FirstLecture = firstLecture
+	}
+	psalm, err := a.GetPsalm(ctx, day)
+	if err != nil {
+		missing = append(missing, fmt.Sprintf("error getting psalm: %s", err.Error()))
+	} else {
+		magnificat.Psalm = psalm
+	}
+	secondLecture, err := a.GetSecondLecture(ctx, day)
+	if err != nil {
+		missing = append(missing, fmt.Sprintf("error getting second lecture: %s", err.Error()))
+	} else if len(secondLecture.Content) > 0 {
+		magnificat.SecondLecture = secondLecture
+	}
+
+	if len(missing) > 0 {
+		return magnificat, fmt.Errorf("%w: %s", ErrMissingLectures, strings.Join(missing, "; "))
+	}
+	return magnificat, nil
+}
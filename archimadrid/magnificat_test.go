@@ -0,0 +1,140 @@
+package archimadrid
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type MockArchimadrid struct {
+	gospel, firstLecture, psalm, secondLecture *Gospel
+	errGospel, errFirstLecture, errPsalm       error
+	errSecondLecture                           error
+}
+
+func (m *MockArchimadrid) GetGospel(ctx context.Context, day time.Time) (*Gospel, error) {
+	return m.gospel, m.errGospel
+}
+
+func (m *MockArchimadrid) GetFirstLecture(ctx context.Context, day time.Time) (*Gospel, error) {
+	return m.firstLecture, m.errFirstLecture
+}
+
+func (m *MockArchimadrid) GetSecondLecture(ctx context.Context, day time.Time) (*Gospel, error) {
+	return m.secondLecture, m.errSecondLecture
+}
+
+func (m *MockArchimadrid) GetPsalm(ctx context.Context, day time.Time) (*Gospel, error) {
+	return m.psalm, m.errPsalm
+}
+
+func TestGetMagnificat(t *testing.T) {
+	day := time.Date(2022, time.March, 20, 0, 0, 0, 0, time.UTC)
+	gospel := &Gospel{Day: "Domingo 3º de Cuaresma.", Title: "Gospel", Content: "content"}
+	firstLecture := &Gospel{Title: "First lecture", Content: "content"}
+	psalm := &Gospel{Title: "Psalm", Content: "content"}
+	secondLecture := &Gospel{Title: "Second lecture", Content: "content"}
+
+	tests := []struct {
+		name        string
+		archimadrid *MockArchimadrid
+		expected    *Magnificat
+		expectedErr error
+		errExpected bool
+	}{
+		{
+			name: "all the lectures",
+			archimadrid: &MockArchimadrid{
+				gospel:        gospel,
+				firstLecture:  firstLecture,
+				psalm:         psalm,
+				secondLecture: secondLecture,
+			},
+			expected: &Magnificat{
+				Date:          "2022-03-20",
+				Day:           gospel.Day,
+				FirstLecture:  firstLecture,
+				Psalm:         psalm,
+				SecondLecture: secondLecture,
+				Gosp:          gospel,
+			},
+		},
+		{
+			name: "day without second lecture",
+			archimadrid: &MockArchimadrid{
+				gospel:        gospel,
+				firstLecture:  firstLecture,
+				psalm:         psalm,
+				secondLecture: &Gospel{Day: gospel.Day},
+			},
+			expected: &Magnificat{
+				Date:         "2022-03-20",
+				Day:          gospel.Day,
+				FirstLecture: firstLecture,
+				Psalm:        psalm,
+				Gosp:         gospel,
+			},
+		},
+		{
+			name: "missing psalm",
+			archimadrid: &MockArchimadrid{
+				gospel:        gospel,
+				firstLecture:  firstLecture,
+				errPsalm:      errors.New("error"),
+				secondLecture: secondLecture,
+			},
+			expected: &Magnificat{
+				Date:          "2022-03-20",
+				Day:           gospel.Day,
+				FirstLecture:  firstLecture,
+				SecondLecture: secondLecture,
+				Gosp:          gospel,
+			},
+			expectedErr: ErrMissingLectures,
+			errExpected: true,
+		},
+		{
+			name: "missing first and second lectures",
+			archimadrid: &MockArchimadrid{
+				gospel:           gospel,
+				errFirstLecture:  errors.New("error"),
+				psalm:            psalm,
+				errSecondLecture: errors.New("error"),
+			},
+			expected: &Magnificat{
+				Date:  "2022-03-20",
+				Day:   gospel.Day,
+				Psalm: psalm,
+				Gosp:  gospel,
+			},
+			expectedErr: ErrMissingLectures,
+			errExpected: true,
+		},
+		{
+			name:        "missing gospel",
+			archimadrid: &MockArchimadrid{errGospel: errors.New("error")},
+			expected:    nil,
+			errExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			actual, err := GetMagnificat(context.TODO(), test.archimadrid, day)
+			if test.errExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			if test.expectedErr != nil {
+				assert.ErrorIs(tt, err, test.expectedErr)
+			} else if err != nil {
+				assert.NotErrorIs(tt, err, ErrMissingLectures)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package archimadrid
+
+import "strings"
+
+// Rank is the liturgical rank of a day, in ascending order of importance
+type Rank int
+
+const (
+	// WeekdayRank is a ferial day or an optional memorial
+	WeekdayRank Rank = iota
+
+	// MemorialRank is an obligatory memorial
+	MemorialRank
+
+	// FeastRank is a feast, like those of the apostles
+	FeastRank
+
+	// SolemnityRank is a solemnity, like Christmas or Saint Joseph
+	SolemnityRank
+)
+
+// Rank returns the liturgical rank of the day of the Magnificat. Archimadrid
+// does not provide it, so it is guessed from the name of the day, as in
+// "19/03/2022 - San José, esposo de la Bienaventurada Virgen María. Solemnidad."
+func (m *Magnificat) Rank() Rank {
+	day := strings.ToLower(m.Day)
+	switch {
+	case strings.Contains(day, "solemnidad"):
+		return SolemnityRank
+	case strings.Contains(day, "fiesta"):
+		return FeastRank
+	case strings.Contains(day, "memoria obligatoria"):
+		return MemorialRank
+	}
+	return WeekdayRank
+}
@@ -0,0 +1,42 @@
+package archimadrid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRank(t *testing.T) {
+	tests := []struct {
+		name     string
+		day      string
+		expected Rank
+	}{
+		{
+			name:     "weekday",
+			day:      "16/03/2022 - Miércoles de la 2ª semana de Cuaresma.",
+			expected: WeekdayRank,
+		},
+		{
+			name:     "memorial",
+			day:      "29/04/2022 - Santa Catalina de Siena, virgen y doctora de la Iglesia. Memoria obligatoria.",
+			expected: MemorialRank,
+		},
+		{
+			name:     "feast",
+			day:      "25/04/2022 - San Marcos, evangelista. Fiesta.",
+			expected: FeastRank,
+		},
+		{
+			name:     "solemnity",
+			day:      "19/03/2022 - San José, esposo de la Bienaventurada Virgen María. Solemnidad.",
+			expected: SolemnityRank,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			assert.Equal(tt, test.expected, (&Magnificat{Day: test.day}).Rank())
+		})
+	}
+}
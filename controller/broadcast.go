@@ -0,0 +1,305 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	DefaultBroadcastTable = "MagnifibotBroadcast"
+	DefaultBroadcastTTL   = 90 * 24 * time.Hour
+
+	// DefaultBroadcastRate is the number of messages per second at which
+	// broadcasts are delivered, below the limits of Telegram for bots
+	DefaultBroadcastRate = 20
+)
+
+// Statuses of a broadcast, which is written as a draft, previewed by the operator
+// and then either sent or cancelled
+const (
+	BroadcastDraft     = "draft"
+	BroadcastSending   = "sending"
+	BroadcastSent      = "sent"
+	BroadcastCancelled = "cancelled"
+)
+
+// ErrBroadcastNotDraft is returned when a broadcast that has already been sent
+// or cancelled is sent or cancelled again
+var ErrBroadcastNotDraft = errors.New("broadcast is not a draft")
+
+// Audience are the chats that receive a broadcast. The empty audience is every
+// active subscriber.
+type Audience struct {
+	// Kind, when set, is the type of the chats, like private or group
+	Kind string
+
+	// Language, when set, is the language chosen by the chats
+	Language string
+}
+
+// Filter returns the filter that selects the chats of the audience
+func (a Audience) Filter() ChatFilter {
+	return ChatFilter{Kind: a.Kind, Language: a.Language}
+}
+
+// Broadcast is an announcement written by the operators of the bot, like a
+// downtime or a new feature, along with the statistics of its delivery
+type Broadcast struct {
+	ID       string
+	Text     string
+	Audience Audience
+	Status   string
+
+	// CreatedBy is the Telegram user that wrote the broadcast
+	CreatedBy int64
+
+	// CreatedAt and SentAt are Unix timestamps
+	CreatedAt int64
+	SentAt    int64
+
+	// Enqueued and EnqueueFailed count the chats for which the broadcast was
+	// sent to the queue or could not be, and Delivered and Failed the ones
+	// that got it or could not be reached
+	Enqueued      int
+	EnqueueFailed int
+	Delivered     int
+	Failed        int
+}
+
+// BroadcastMessage is the payload of the BroadcastKind messages of the queue
+type BroadcastMessage struct {
+	BroadcastID string `json:"broadcast_id"`
+	Text        string `json:"text"`
+}
+
+// NewBroadcastID returns a short identifier for a broadcast created at the given
+// time, so that it fits in the data of the buttons that send or cancel it
+func NewBroadcastID(at time.Time) string {
+	return strconv.FormatInt(at.UnixNano(), 36)
+}
+
+// CreateBroadcast stores a new broadcast as a draft. It is forgotten after DefaultBroadcastTTL.
+func (m *Magnifibot) CreateBroadcast(ctx context.Context, b *Broadcast) error {
+	now := time.Now()
+	b.Status = BroadcastDraft
+	b.CreatedAt = now.Unix()
+
+	_, err := m.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(m.Config.BroadcastTable),
+		Item: map[string]types.AttributeValue{
+			"BroadcastID": &types.AttributeValueMemberS{Value: b.ID},
+			"Text":        &types.AttributeValueMemberS{Value: b.Text},
+			"Kind":        &types.AttributeValueMemberS{Value: b.Audience.Kind},
+			"Language":    &types.AttributeValueMemberS{Value: b.Audience.Language},
+			"Status":      &types.AttributeValueMemberS{Value: b.Status},
+			"CreatedBy":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", b.CreatedBy)},
+			"CreatedAt":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", b.CreatedAt)},
+			"ExpiresAt":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(DefaultBroadcastTTL).Unix())},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(BroadcastID)"),
+	})
+	if err != nil {
+		return fmt.Errorf("error creating broadcast %s: %w", b.ID, err)
+	}
+	return nil
+}
+
+// GetBroadcast returns a broadcast, or nil if it does not exist
+func (m *Magnifibot) GetBroadcast(ctx context.Context, id string) (*Broadcast, error) {
+	output, err := m.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(m.Config.BroadcastTable),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]types.AttributeValue{
+			"BroadcastID": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting broadcast %s: %w", id, err)
+	}
+	if output.Item == nil {
+		return nil, nil
+	}
+	return broadcastFromItem(output.Item), nil
+}
+
+// ListBroadcasts returns the broadcasts still kept, the newest first
+func (m *Magnifibot) ListBroadcasts(ctx context.Context) ([]Broadcast, error) {
+	paginator := dynamodb.NewScanPaginator(m, &dynamodb.ScanInput{
+		TableName: aws.String(m.Config.BroadcastTable),
+	})
+
+	broadcasts := []Broadcast{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return []Broadcast{}, fmt.Errorf("error scanning dynamodb table: %w", err)
+		}
+		for _, item := range page.Items {
+			broadcasts = append(broadcasts, *broadcastFromItem(item))
+		}
+	}
+
+	sort.Slice(broadcasts, func(i, j int) bool { return broadcasts[i].CreatedAt > broadcasts[j].CreatedAt })
+	return broadcasts, nil
+}
+
+func broadcastFromItem(item map[string]types.AttributeValue) *Broadcast {
+	b := &Broadcast{
+		ID:   getString(item, "BroadcastID"),
+		Text: getString(item, "Text"),
+		Audience: Audience{
+			Kind:     getString(item, "Kind"),
+			Language: getString(item, "Language"),
+		},
+		Status: getString(item, "Status"),
+	}
+	b.CreatedBy, _ = getNumber(item, "CreatedBy")
+	b.CreatedAt, _ = getNumber(item, "CreatedAt")
+	b.SentAt, _ = getNumber(item, "SentAt")
+	for name, count := range map[string]*int{
+		"Enqueued":      &b.Enqueued,
+		"EnqueueFailed": &b.EnqueueFailed,
+		"Delivered":     &b.Delivered,
+		"Failed":        &b.Failed,
+	} {
+		value, _ := getNumber(item, name)
+		*count = int(value)
+	}
+	return b
+}
+
+// StartBroadcast marks a draft as being sent. It returns ErrBroadcastNotDraft if
+// it was already sent or cancelled, so that a broadcast is never sent twice.
+func (m *Magnifibot) StartBroadcast(ctx context.Context, id string) error {
+	return m.setBroadcastStatus(ctx, id, BroadcastDraft, BroadcastSending)
+}
+
+// CancelBroadcast discards a draft. It returns ErrBroadcastNotDraft if it was
+// already sent or cancelled.
+func (m *Magnifibot) CancelBroadcast(ctx context.Context, id string) error {
+	return m.setBroadcastStatus(ctx, id, BroadcastDraft, BroadcastCancelled)
+}
+
+// AbortBroadcast cancels a broadcast that was started but could not be sent,
+// so that it is not left as being sent forever
+func (m *Magnifibot) AbortBroadcast(ctx context.Context, id string) error {
+	return m.setBroadcastStatus(ctx, id, BroadcastSending, BroadcastCancelled)
+}
+
+func (m *Magnifibot) setBroadcastStatus(ctx context.Context, id, from, to string) error {
+	_, err := m.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(m.Config.BroadcastTable),
+		Key: map[string]types.AttributeValue{
+			"BroadcastID": &types.AttributeValueMemberS{Value: id},
+		},
+		ConditionExpression:      aws.String("#status = :from"),
+		UpdateExpression:         aws.String("SET #status = :to"),
+		ExpressionAttributeNames: map[string]string{"#status": "Status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":from": &types.AttributeValueMemberS{Value: from},
+			":to":   &types.AttributeValueMemberS{Value: to},
+		},
+	})
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return fmt.Errorf("error setting status of broadcast %s to %s: %w", id, to, ErrBroadcastNotDraft)
+	}
+	if err != nil {
+		return fmt.Errorf("error setting status of broadcast %s to %s: %w", id, to, err)
+	}
+	return nil
+}
+
+// SendBroadcast enqueues a broadcast, previously started with StartBroadcast, for
+// every chat of its audience. The messages are delayed so that no more than rate
+// of them are delivered per second, however long the broadcast takes. It returns the number of chats
+// enqueued and the ones that failed, which are also recorded in the broadcast.
+func (m *Magnifibot) SendBroadcast(ctx context.Context, b *Broadcast, rate int) (int, int, error) {
+	if rate <= 0 {
+		rate = DefaultBroadcastRate
+	}
+
+	message, err := NewMessage(BroadcastKind, BroadcastMessage{BroadcastID: b.ID, Text: b.Text})
+	if err != nil {
+		return 0, 0, fmt.Errorf("error creating message of broadcast %s: %w", b.ID, err)
+	}
+
+	var mu sync.Mutex
+	scheduled, enqueued, failed := 0, 0, 0
+	scanErr := m.ScanChatIDs(ctx, b.Audience.Filter(), func(chatIDs []string) error {
+		// Every page reserves the next positions of the delivery, since
+		// pages are enqueued concurrently
+		mu.Lock()
+		first := scheduled
+		scheduled += len(chatIDs)
+		mu.Unlock()
+
+		pageFailed := m.SendThrottledMessagesToQueue(ctx, chatIDs, message, first, rate)
+
+		mu.Lock()
+		defer mu.Unlock()
+		enqueued += len(chatIDs) - len(pageFailed)
+		failed += len(pageFailed)
+		return nil
+	})
+
+	_, err = m.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(m.Config.BroadcastTable),
+		Key: map[string]types.AttributeValue{
+			"BroadcastID": &types.AttributeValueMemberS{Value: b.ID},
+		},
+		UpdateExpression:         aws.String("SET #status = :sent, SentAt = :now, Enqueued = :enqueued, EnqueueFailed = :failed"),
+		ExpressionAttributeNames: map[string]string{"#status": "Status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sent":     &types.AttributeValueMemberS{Value: BroadcastSent},
+			":now":      &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+			":enqueued": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", enqueued)},
+			":failed":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", failed)},
+		},
+	})
+	if scanErr != nil {
+		return enqueued, failed, fmt.Errorf("error sending broadcast %s after %d chats: %w", b.ID, enqueued, scanErr)
+	}
+	if err != nil {
+		return enqueued, failed, fmt.Errorf("error recording statistics of broadcast %s: %w", b.ID, err)
+	}
+	return enqueued, failed, nil
+}
+
+// RecordBroadcastDelivery counts a chat that got a broadcast, or that could not
+// be reached. Broadcasts that are no longer kept are ignored.
+func (m *Magnifibot) RecordBroadcastDelivery(ctx context.Context, id string, delivered bool) error {
+	counter := "Failed"
+	if delivered {
+		counter = "Delivered"
+	}
+
+	_, err := m.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(m.Config.BroadcastTable),
+		Key: map[string]types.AttributeValue{
+			"BroadcastID": &types.AttributeValueMemberS{Value: id},
+		},
+		ConditionExpression:       aws.String("attribute_exists(BroadcastID)"),
+		UpdateExpression:          aws.String(fmt.Sprintf("ADD %s :one", counter)),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":one": &types.AttributeValueMemberN{Value: "1"}},
+	})
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error recording delivery of broadcast %s: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBroadcastID(t *testing.T) {
+	now := time.Now()
+	assert.NotEqual(t, NewBroadcastID(now), NewBroadcastID(now.Add(time.Nanosecond)))
+	assert.LessOrEqual(t, len(NewBroadcastID(now)), 13)
+}
+
+func TestAudienceFilter(t *testing.T) {
+	assert.Equal(t, ChatFilter{}, Audience{}.Filter())
+	assert.Equal(t, ChatFilter{Kind: "group", Language: "en"}, Audience{Kind: "group", Language: "en"}.Filter())
+}
+
+func TestGetBroadcast(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		expected      *Broadcast
+		errorExpected bool
+	}{
+		{
+			name: "sent broadcast",
+			dynamo: &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+				"BroadcastID": &types.AttributeValueMemberS{Value: "abc"},
+				"Text":        &types.AttributeValueMemberS{Value: "Hola"},
+				"Kind":        &types.AttributeValueMemberS{Value: "group"},
+				"Language":    &types.AttributeValueMemberS{Value: ""},
+				"Status":      &types.AttributeValueMemberS{Value: BroadcastSent},
+				"CreatedBy":   &types.AttributeValueMemberN{Value: "10"},
+				"CreatedAt":   &types.AttributeValueMemberN{Value: "1647588056"},
+				"SentAt":      &types.AttributeValueMemberN{Value: "1647588100"},
+				"Enqueued":    &types.AttributeValueMemberN{Value: "5"},
+				"Delivered":   &types.AttributeValueMemberN{Value: "4"},
+				"Failed":      &types.AttributeValueMemberN{Value: "1"},
+			}}},
+			expected: &Broadcast{
+				ID:        "abc",
+				Text:      "Hola",
+				Audience:  Audience{Kind: "group"},
+				Status:    BroadcastSent,
+				CreatedBy: 10,
+				CreatedAt: 1647588056,
+				SentAt:    1647588100,
+				Enqueued:  5,
+				Delivered: 4,
+				Failed:    1,
+			},
+			errorExpected: false,
+		},
+		{
+			name:          "missing broadcast",
+			dynamo:        &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{}},
+			expected:      nil,
+			errorExpected: false,
+		},
+		{
+			name:          "dynamodb error",
+			dynamo:        &MockDynamoDB{errGetItem: errors.New("error")},
+			expected:      nil,
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			actual, err := m.GetBroadcast(context.TODO(), "abc")
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestStartBroadcast(t *testing.T) {
+	tests := []struct {
+		name        string
+		dynamo      DynamoDBInterface
+		expectedErr error
+	}{
+		{
+			name:        "draft",
+			dynamo:      &MockDynamoDB{},
+			expectedErr: nil,
+		},
+		{
+			name:        "already sent",
+			dynamo:      &MockDynamoDB{errUpdateItem: &types.ConditionalCheckFailedException{}},
+			expectedErr: ErrBroadcastNotDraft,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.StartBroadcast(context.TODO(), "abc")
+			if test.expectedErr != nil {
+				assert.ErrorIs(tt, err, test.expectedErr)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestAbortBroadcast(t *testing.T) {
+	dynamo := &MockDynamoDB{}
+	m := NewMagnifibot(SetDynamoDBClient(dynamo))
+	assert.NoError(t, m.AbortBroadcast(context.TODO(), "abc"))
+
+	m = NewMagnifibot(SetDynamoDBClient(&MockDynamoDB{errUpdateItem: &types.ConditionalCheckFailedException{}}))
+	assert.Error(t, m.AbortBroadcast(context.TODO(), "abc"))
+}
+
+func TestSendBroadcast(t *testing.T) {
+	tests := []struct {
+		name             string
+		dynamo           DynamoDBInterface
+		queue            *MockQueue
+		rate             int
+		expectedEnqueued int
+		expectedFailed   int
+		expectedDelays   []int32
+		errorExpected    bool
+	}{
+		{
+			name: "throttled pages",
+			dynamo: &MockDynamoDB{scanPages: map[int32][]*dynamodb.ScanOutput{
+				0: {scanPage(1, "1", "2", "3", "4"), scanPage(0, "5", "6")},
+			}},
+			queue:            &MockQueue{},
+			rate:             2,
+			expectedEnqueued: 6,
+			expectedFailed:   0,
+			expectedDelays:   []int32{0, 2},
+			errorExpected:    false,
+		},
+		{
+			name: "throttled batches",
+			dynamo: &MockDynamoDB{scanPages: map[int32][]*dynamodb.ScanOutput{
+				0: {scanPage(0, chatIDRange(25)...)},
+			}},
+			queue:            &MockQueue{},
+			rate:             10,
+			expectedEnqueued: 25,
+			expectedFailed:   0,
+			expectedDelays:   []int32{0, 1, 2},
+			errorExpected:    false,
+		},
+		{
+			name: "failed chats",
+			dynamo: &MockDynamoDB{scanPages: map[int32][]*dynamodb.ScanOutput{
+				0: {scanPage(0, "1", "2")},
+			}},
+			queue:            &MockQueue{failedChatIDs: map[string]bool{"2": true}},
+			rate:             0,
+			expectedEnqueued: 1,
+			expectedFailed:   1,
+			expectedDelays:   []int32{0},
+			errorExpected:    false,
+		},
+		{
+			name:             "scan error",
+			dynamo:           &MockDynamoDB{errScan: errors.New("error")},
+			queue:            &MockQueue{},
+			rate:             2,
+			expectedEnqueued: 0,
+			expectedFailed:   0,
+			expectedDelays:   nil,
+			errorExpected:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(
+				SetDynamoDBClient(test.dynamo),
+				SetSQSClient(test.queue),
+				SetConfig(&MagnifibotConfig{ScanSegments: 1, QueueConcurrency: 1}),
+			)
+			enqueued, failed, err := m.SendBroadcast(context.TODO(), &Broadcast{ID: "abc", Text: "Hola"}, test.rate)
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expectedEnqueued, enqueued)
+			assert.Equal(tt, test.expectedFailed, failed)
+			assert.Equal(tt, test.expectedDelays, test.queue.delays)
+		})
+	}
+}
+
+func TestRecordBroadcastDelivery(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		errorExpected bool
+	}{
+		{
+			name:          "kept broadcast",
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "forgotten broadcast",
+			dynamo:        &MockDynamoDB{errUpdateItem: &types.ConditionalCheckFailedException{}},
+			errorExpected: false,
+		},
+		{
+			name:          "dynamodb error",
+			dynamo:        &MockDynamoDB{errUpdateItem: errors.New("error")},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.RecordBroadcastDelivery(context.TODO(), "abc", true)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
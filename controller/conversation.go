@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	DefaultConversationTable = "MagnifibotConversation"
+	DefaultConversationTTL   = 24 * time.Hour
+)
+
+// Conversation is the state of a multi-step dialog with a chat, kept between
+// the separate invocations that handle each one of its messages.
+type Conversation struct {
+	// Flow is the name of the dialog, like the onboarding
+	Flow string
+
+	// Step is the question that the chat has to answer next
+	Step string
+
+	// Data holds the answers given so far
+	Data map[string]string
+}
+
+// GetConversation returns the ongoing conversation with a chat, or nil if there is none
+func (m *Magnifibot) GetConversation(ctx context.Context, chatID int64) (*Conversation, error) {
+	output, err := m.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(m.Config.ConversationTable),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting conversation with chat %d: %w", chatID, err)
+	}
+	if output.Item == nil {
+		return nil, nil
+	}
+
+	// Expired items may still be returned until DynamoDB deletes them
+	if expiresAt, err := getNumber(output.Item, "ExpiresAt"); err == nil && expiresAt < time.Now().Unix() {
+		return nil, nil
+	}
+
+	conversation := &Conversation{
+		Flow: getString(output.Item, "Flow"),
+		Step: getString(output.Item, "Step"),
+		Data: map[string]string{},
+	}
+	if data, ok := output.Item["Data"].(*types.AttributeValueMemberM); ok {
+		for key, value := range data.Value {
+			if s, ok := value.(*types.AttributeValueMemberS); ok {
+				conversation.Data[key] = s.Value
+			}
+		}
+	}
+	return conversation, nil
+}
+
+// SaveConversation stores the state of the conversation with a chat, which
+// is forgotten after DefaultConversationTTL without answers.
+func (m *Magnifibot) SaveConversation(ctx context.Context, chatID int64, conversation *Conversation) error {
+	data := map[string]types.AttributeValue{}
+	for key, value := range conversation.Data {
+		data[key] = &types.AttributeValueMemberS{Value: value}
+	}
+
+	_, err := m.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(m.Config.ConversationTable),
+		Item: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+			"Flow":   &types.AttributeValueMemberS{Value: conversation.Flow},
+			"Step":   &types.AttributeValueMemberS{Value: conversation.Step},
+			"Data":   &types.AttributeValueMemberM{Value: data},
+			"ExpiresAt": &types.AttributeValueMemberN{
+				Value: fmt.Sprintf("%d", time.Now().Add(DefaultConversationTTL).Unix()),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error saving conversation with chat %d: %w", chatID, err)
+	}
+	return nil
+}
+
+// EndConversation forgets the conversation with a chat
+func (m *Magnifibot) EndConversation(ctx context.Context, chatID int64) error {
+	if err := m.delete(ctx, "ChatID", &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)}, m.Config.ConversationTable); err != nil {
+		return fmt.Errorf("error ending conversation with chat %d: %w", chatID, err)
+	}
+	return nil
+}
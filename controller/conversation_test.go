@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetConversation(t *testing.T) {
+	tomorrow := fmt.Sprintf("%d", time.Now().Add(24*time.Hour).Unix())
+	yesterday := fmt.Sprintf("%d", time.Now().Add(-24*time.Hour).Unix())
+
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		expected      *Conversation
+		errorExpected bool
+	}{
+		{
+			name: "ongoing conversation",
+			dynamo: &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+				"ChatID": &types.AttributeValueMemberN{Value: "12"},
+				"Flow":   &types.AttributeValueMemberS{Value: "onboarding"},
+				"Step":   &types.AttributeValueMemberS{Value: "profile"},
+				"Data": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+					"language": &types.AttributeValueMemberS{Value: "en"},
+				}},
+				"ExpiresAt": &types.AttributeValueMemberN{Value: tomorrow},
+			}}},
+			expected: &Conversation{
+				Flow: "onboarding",
+				Step: "profile",
+				Data: map[string]string{"language": "en"},
+			},
+			errorExpected: false,
+		},
+		{
+			name: "expired conversation",
+			dynamo: &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+				"ChatID":    &types.AttributeValueMemberN{Value: "12"},
+				"Flow":      &types.AttributeValueMemberS{Value: "onboarding"},
+				"Step":      &types.AttributeValueMemberS{Value: "profile"},
+				"ExpiresAt": &types.AttributeValueMemberN{Value: yesterday},
+			}}},
+			expected:      nil,
+			errorExpected: false,
+		},
+		{
+			name:          "no conversation",
+			dynamo:        &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{}},
+			expected:      nil,
+			errorExpected: false,
+		},
+		{
+			name:          "error getting conversation",
+			dynamo:        &MockDynamoDB{errGetItem: errors.New("error")},
+			expected:      nil,
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			actual, err := m.GetConversation(context.TODO(), 12)
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestSaveConversation(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		errorExpected bool
+	}{
+		{
+			name:          "valid conversation",
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "error saving conversation",
+			dynamo:        &MockDynamoDB{errPutItem: errors.New("error")},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.SaveConversation(context.TODO(), 12, &Conversation{
+				Flow: "onboarding",
+				Step: "language",
+				Data: map[string]string{},
+			})
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestEndConversation(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		errorExpected bool
+	}{
+		{
+			name:          "valid end",
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "error ending conversation",
+			dynamo:        &MockDynamoDB{errDeleteItem: errors.New("error")},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.EndConversation(context.TODO(), 12)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
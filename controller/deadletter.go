@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/igvaquero18/magnifibot/archimadrid"
+)
+
+const (
+	DefaultDeadLetterQueueName = "magnifibot-dlq"
+
+	// deadLetterVisibilityTimeout is the time, in seconds, during which a received
+	// dead letter is hidden from other consumers of the dead-letter queue
+	deadLetterVisibilityTimeout = 60
+
+	// deadLetterWaitTime is the time, in seconds, that each receive waits for dead
+	// letters to arrive. Long polling asks every server of the queue, so that the
+	// messages are not missed when the few servers sampled have none.
+	deadLetterWaitTime = 2
+
+	// deadLetterEmptyReceives is the number of receives in a row without dead
+	// letters after which the dead-letter queue is taken as empty
+	deadLetterEmptyReceives = 3
+)
+
+// DeadLetter is a message that could not be delivered after all the retries
+// and was moved to the dead-letter queue.
+type DeadLetter struct {
+	MessageID     string
+	ReceiptHandle string
+	ChatID        string
+	Body          string
+	Kind          MessageKind
+
+	// Magnificat holds the lectures of the MagnificatKind dead letters
+	Magnificat *archimadrid.Magnificat
+
+	// Reason is the last error recorded in the delivery ledger for the message
+	Reason string
+}
+
+// ReceiveDeadLetters receives up to max messages from the dead-letter queue, along with
+// the reason why they failed. Received messages are hidden for a while and become
+// visible again unless they are deleted or replayed.
+func (m *Magnifibot) ReceiveDeadLetters(ctx context.Context, max int) ([]DeadLetter, error) {
+	deadLetters := []DeadLetter{}
+	empty := 0
+	for len(deadLetters) < max && empty < deadLetterEmptyReceives {
+		batch := max - len(deadLetters)
+		if batch > 10 {
+			batch = 10
+		}
+		output, err := m.SQSDeadLetterAPI.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(m.Config.DeadLetterQueueURL),
+			MaxNumberOfMessages:   int32(batch),
+			VisibilityTimeout:     deadLetterVisibilityTimeout,
+			WaitTimeSeconds:       deadLetterWaitTime,
+			MessageAttributeNames: []string{"All"},
+		})
+		if err != nil {
+			return deadLetters, fmt.Errorf("error receiving messages from the dead-letter queue: %w", err)
+		}
+		if len(output.Messages) == 0 {
+			empty++
+			continue
+		}
+		empty = 0
+		for _, message := range output.Messages {
+			deadLetters = append(deadLetters, m.toDeadLetter(ctx, message))
+		}
+	}
+	return deadLetters, nil
+}
+
+// ReplayDeadLetter sends a dead letter back to the queue configured in the controller,
+// and removes it from the dead-letter queue. It returns the new Message ID on success.
+func (m *Magnifibot) ReplayDeadLetter(ctx context.Context, deadLetter DeadLetter) (string, error) {
+	messageID, err := m.sendBody(ctx, deadLetter.ChatID, deadLetter.Body)
+	if err != nil {
+		return "", fmt.Errorf("error replaying message %s: %w", deadLetter.MessageID, err)
+	}
+	if err := m.DeleteDeadLetter(ctx, deadLetter); err != nil {
+		return messageID, err
+	}
+	return messageID, nil
+}
+
+// DeleteDeadLetter removes a dead letter from the dead-letter queue
+func (m *Magnifibot) DeleteDeadLetter(ctx context.Context, deadLetter DeadLetter) error {
+	_, err := m.SQSDeadLetterAPI.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(m.Config.DeadLetterQueueURL),
+		ReceiptHandle: aws.String(deadLetter.ReceiptHandle),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting message %s from the dead-letter queue: %w", deadLetter.MessageID, err)
+	}
+	return nil
+}
+
+func (m *Magnifibot) toDeadLetter(ctx context.Context, message types.Message) DeadLetter {
+	deadLetter := DeadLetter{
+		MessageID:     aws.ToString(message.MessageId),
+		ReceiptHandle: aws.ToString(message.ReceiptHandle),
+		Body:          aws.ToString(message.Body),
+	}
+	if chatID, ok := message.MessageAttributes["chatID"]; ok {
+		deadLetter.ChatID = aws.ToString(chatID.StringValue)
+	}
+
+	envelope, err := ParseEnvelope(deadLetter.Body, deadLetter.ChatID)
+	if err != nil {
+		deadLetter.Reason = fmt.Sprintf("invalid message: %s", err.Error())
+		return deadLetter
+	}
+	deadLetter.Kind = envelope.Kind
+	deadLetter.ChatID = strconv.FormatInt(envelope.ChatID, 10)
+	// Only the deliveries of the lectures are recorded in the ledger
+	if envelope.Kind != MagnificatKind {
+		deadLetter.Reason = "unknown"
+		return deadLetter
+	}
+
+	// The lectures are not validated, since being invalid may be the reason why they failed
+	var magnificat archimadrid.Magnificat
+	if err := json.Unmarshal(envelope.Payload, &magnificat); err != nil {
+		deadLetter.Reason = fmt.Sprintf("invalid message: %s", err.Error())
+		return deadLetter
+	}
+	deadLetter.Magnificat = &magnificat
+
+	key := magnificat.Date
+	if key == "" {
+		key = magnificat.Day
+	}
+	delivery, err := m.GetDelivery(ctx, deadLetter.ChatID, key)
+	if err != nil {
+		deadLetter.Reason = fmt.Sprintf("unknown: %s", err.Error())
+		return deadLetter
+	}
+	deadLetter.Reason = delivery.LastError
+	if deadLetter.Reason == "" {
+		deadLetter.Reason = "unknown"
+	}
+	return deadLetter
+}
@@ -0,0 +1,330 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/igvaquero18/magnifibot/archimadrid"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockDeadLetterQueue struct {
+	messages         []types.Message
+	emptyReceives    int
+	receives         int
+	errReceive       error
+	errDeleteMessage error
+}
+
+func (m *MockDeadLetterQueue) ReceiveMessage(ctx context.Context,
+	params *sqs.ReceiveMessageInput,
+	optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	if m.errReceive != nil {
+		return nil, m.errReceive
+	}
+	if params.WaitTimeSeconds != deadLetterWaitTime {
+		return nil, errors.New("not long polling")
+	}
+	m.receives++
+	// The first receives may find no messages, as short polling does
+	if m.receives <= m.emptyReceives {
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+	n := int(params.MaxNumberOfMessages)
+	if n > len(m.messages) {
+		n = len(m.messages)
+	}
+	output := &sqs.ReceiveMessageOutput{Messages: m.messages[:n]}
+	m.messages = m.messages[n:]
+	return output, nil
+}
+
+func (m *MockDeadLetterQueue) DeleteMessage(ctx context.Context,
+	params *sqs.DeleteMessageInput,
+	optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	return &sqs.DeleteMessageOutput{}, m.errDeleteMessage
+}
+
+func deadLetterMessage(id, chatID, body string) types.Message {
+	return types.Message{
+		MessageId:     aws.String(id),
+		ReceiptHandle: aws.String("receipt-" + id),
+		Body:          aws.String(body),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"chatID": {DataType: aws.String("Number"), StringValue: aws.String(chatID)},
+		},
+	}
+}
+
+func TestReceiveDeadLetters(t *testing.T) {
+	tests := []struct {
+		name          string
+		queue         SQSDeadLetterAPI
+		dynamo        DynamoDBInterface
+		max           int
+		expected      []DeadLetter
+		errorExpected bool
+	}{
+		{
+			name: "dead letter with reason",
+			queue: &MockDeadLetterQueue{
+				messages: []types.Message{deadLetterMessage("1", "12", `{"date":"2022-03-16","day":"today"}`)},
+			},
+			dynamo: &MockDynamoDB{
+				getItemOutput: &dynamodb.GetItemOutput{
+					Item: map[string]dynamodbtypes.AttributeValue{
+						"LastError": &dynamodbtypes.AttributeValueMemberS{Value: "error sending Psalm"},
+					},
+				},
+			},
+			max: 10,
+			expected: []DeadLetter{
+				{
+					MessageID:     "1",
+					ReceiptHandle: "receipt-1",
+					ChatID:        "12",
+					Body:          `{"date":"2022-03-16","day":"today"}`,
+					Kind:          MagnificatKind,
+					Magnificat:    &archimadrid.Magnificat{Date: "2022-03-16", Day: "today"},
+					Reason:        "error sending Psalm",
+				},
+			},
+			errorExpected: false,
+		},
+		{
+			name: "dead letter without reason",
+			queue: &MockDeadLetterQueue{
+				messages: []types.Message{deadLetterMessage("1", "12", `{"day":"today"}`)},
+			},
+			dynamo: &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{}},
+			max:    10,
+			expected: []DeadLetter{
+				{
+					MessageID:     "1",
+					ReceiptHandle: "receipt-1",
+					ChatID:        "12",
+					Body:          `{"day":"today"}`,
+					Kind:          MagnificatKind,
+					Magnificat:    &archimadrid.Magnificat{Day: "today"},
+					Reason:        "unknown",
+				},
+			},
+			errorExpected: false,
+		},
+		{
+			name: "dead letter in an envelope",
+			queue: &MockDeadLetterQueue{
+				messages: []types.Message{deadLetterMessage(
+					"1",
+					"12",
+					`{"kind":"magnificat","version":1,"chat_id":13,"payload":{"date":"2022-03-16","day":"today"}}`,
+				)},
+			},
+			dynamo: &MockDynamoDB{
+				getItemOutput: &dynamodb.GetItemOutput{
+					Item: map[string]dynamodbtypes.AttributeValue{
+						"LastError": &dynamodbtypes.AttributeValueMemberS{Value: "error sending Psalm"},
+					},
+				},
+			},
+			max: 10,
+			expected: []DeadLetter{
+				{
+					MessageID:     "1",
+					ReceiptHandle: "receipt-1",
+					ChatID:        "13",
+					Body:          `{"kind":"magnificat","version":1,"chat_id":13,"payload":{"date":"2022-03-16","day":"today"}}`,
+					Kind:          MagnificatKind,
+					Magnificat:    &archimadrid.Magnificat{Date: "2022-03-16", Day: "today"},
+					Reason:        "error sending Psalm",
+				},
+			},
+			errorExpected: false,
+		},
+		{
+			name: "broadcast dead letter",
+			queue: &MockDeadLetterQueue{
+				messages: []types.Message{deadLetterMessage(
+					"1",
+					"12",
+					`{"kind":"broadcast","version":1,"chat_id":12,"payload":{"broadcast_id":"abc","text":"Hola"}}`,
+				)},
+			},
+			dynamo: &MockDynamoDB{},
+			max:    10,
+			expected: []DeadLetter{
+				{
+					MessageID:     "1",
+					ReceiptHandle: "receipt-1",
+					ChatID:        "12",
+					Body:          `{"kind":"broadcast","version":1,"chat_id":12,"payload":{"broadcast_id":"abc","text":"Hola"}}`,
+					Kind:          BroadcastKind,
+					Reason:        "unknown",
+				},
+			},
+			errorExpected: false,
+		},
+		{
+			name: "invalid dead letter",
+			queue: &MockDeadLetterQueue{
+				messages: []types.Message{deadLetterMessage("1", "12", "invalid")},
+			},
+			dynamo: &MockDynamoDB{},
+			max:    10,
+			expected: []DeadLetter{
+				{
+					MessageID:     "1",
+					ReceiptHandle: "receipt-1",
+					ChatID:        "12",
+					Body:          "invalid",
+					Reason:        "invalid message: error unmarshalling envelope: invalid character 'i' looking for beginning of value",
+				},
+			},
+			errorExpected: false,
+		},
+		{
+			name: "more dead letters than max",
+			queue: &MockDeadLetterQueue{
+				messages: []types.Message{
+					deadLetterMessage("1", "12", "invalid"),
+					deadLetterMessage("2", "13", "invalid"),
+					deadLetterMessage("3", "14", "invalid"),
+				},
+			},
+			dynamo: &MockDynamoDB{},
+			max:    2,
+			expected: []DeadLetter{
+				{
+					MessageID:     "1",
+					ReceiptHandle: "receipt-1",
+					ChatID:        "12",
+					Body:          "invalid",
+					Reason:        "invalid message: error unmarshalling envelope: invalid character 'i' looking for beginning of value",
+				},
+				{
+					MessageID:     "2",
+					ReceiptHandle: "receipt-2",
+					ChatID:        "13",
+					Body:          "invalid",
+					Reason:        "invalid message: error unmarshalling envelope: invalid character 'i' looking for beginning of value",
+				},
+			},
+			errorExpected: false,
+		},
+		{
+			name: "dead letters after empty receives",
+			queue: &MockDeadLetterQueue{
+				messages:      []types.Message{deadLetterMessage("1", "12", "invalid")},
+				emptyReceives: deadLetterEmptyReceives - 1,
+			},
+			dynamo: &MockDynamoDB{},
+			max:    10,
+			expected: []DeadLetter{
+				{
+					MessageID:     "1",
+					ReceiptHandle: "receipt-1",
+					ChatID:        "12",
+					Body:          "invalid",
+					Reason:        "invalid message: error unmarshalling envelope: invalid character 'i' looking for beginning of value",
+				},
+			},
+			errorExpected: false,
+		},
+		{
+			name: "dead letters after too many empty receives",
+			queue: &MockDeadLetterQueue{
+				messages:      []types.Message{deadLetterMessage("1", "12", "invalid")},
+				emptyReceives: deadLetterEmptyReceives,
+			},
+			dynamo:        &MockDynamoDB{},
+			max:           10,
+			expected:      []DeadLetter{},
+			errorExpected: false,
+		},
+		{
+			name:          "empty dead-letter queue",
+			queue:         &MockDeadLetterQueue{},
+			dynamo:        &MockDynamoDB{},
+			max:           10,
+			expected:      []DeadLetter{},
+			errorExpected: false,
+		},
+		{
+			name:          "error receiving dead letters",
+			queue:         &MockDeadLetterQueue{errReceive: errors.New("error")},
+			dynamo:        &MockDynamoDB{},
+			max:           10,
+			expected:      []DeadLetter{},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetSQSDeadLetterClient(test.queue), SetDynamoDBClient(test.dynamo))
+			actual, err := m.ReceiveDeadLetters(context.TODO(), test.max)
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	tests := []struct {
+		name          string
+		queue         SQSSendMessageAPI
+		deadLetters   SQSDeadLetterAPI
+		expected      string
+		errorExpected bool
+	}{
+		{
+			name:          "valid replay",
+			queue:         &MockQueue{output: &sqs.SendMessageOutput{MessageId: aws.String("id")}},
+			deadLetters:   &MockDeadLetterQueue{},
+			expected:      "id",
+			errorExpected: false,
+		},
+		{
+			name:          "error sending message",
+			queue:         &MockQueue{err: errors.New("error")},
+			deadLetters:   &MockDeadLetterQueue{},
+			expected:      "",
+			errorExpected: true,
+		},
+		{
+			name:          "error deleting dead letter",
+			queue:         &MockQueue{output: &sqs.SendMessageOutput{MessageId: aws.String("id")}},
+			deadLetters:   &MockDeadLetterQueue{errDeleteMessage: errors.New("error")},
+			expected:      "id",
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetSQSClient(test.queue), SetSQSDeadLetterClient(test.deadLetters))
+			actual, err := m.ReplayDeadLetter(context.TODO(), DeadLetter{
+				MessageID:     "1",
+				ReceiptHandle: "receipt-1",
+				ChatID:        "12",
+				Body:          `{"day":"today"}`,
+			})
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
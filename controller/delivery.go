@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/archimadrid"
+	"github.com/igvaquero18/magnifibot/utils"
+)
+
+const (
+	DefaultDeliveryTable = "MagnifibotDelivery"
+	DefaultDeliveryTTL   = 7 * 24 * time.Hour
+)
+
+// DeliveryPart identifies each one of the Telegram messages in which
+// a Magnificat is split when it is delivered to a chat.
+type DeliveryPart string
+
+const (
+	HeaderPart        DeliveryPart = "Header"
+	FirstLecturePart  DeliveryPart = "FirstLecture"
+	PsalmPart         DeliveryPart = "Psalm"
+	SecondLecturePart DeliveryPart = "SecondLecture"
+	GospelPart        DeliveryPart = "Gospel"
+)
+
+// DeliveryParts is the list of parts of a Magnificat, in the order in
+// which they are sent.
+var DeliveryParts = []DeliveryPart{HeaderPart, FirstLecturePart, PsalmPart, SecondLecturePart, GospelPart}
+
+// Delivery is the entry of the delivery ledger for a chat and a delivery key
+type Delivery struct {
+	// Parts maps every part already sent to its Telegram message ID
+	Parts map[DeliveryPart]int
+
+	// LastError is the error of the last failed attempt, if any
+	LastError string
+}
+
+// GetDelivery returns the entry of the delivery ledger for a chat and a delivery key,
+// with the parts that have already been sent and the last error, if any.
+func (m *Magnifibot) GetDelivery(ctx context.Context, chatID, key string) (*Delivery, error) {
+	output, err := m.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(m.Config.DeliveryTable),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: chatID},
+			"Date":   &types.AttributeValueMemberS{Value: key},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting delivery %s for chat %s: %w", key, chatID, err)
+	}
+
+	delivery := &Delivery{
+		Parts:     map[DeliveryPart]int{},
+		LastError: getString(output.Item, "LastError"),
+	}
+	for _, part := range DeliveryParts {
+		if _, ok := output.Item[string(part)]; !ok {
+			continue
+		}
+		messageID, err := getNumber(output.Item, string(part))
+		if err != nil {
+			return nil, err
+		}
+		delivery.Parts[part] = int(messageID)
+	}
+	return delivery, nil
+}
+
+// MarkDelivered records in the delivery ledger that a part has been sent to a
+// chat for a given delivery key, along with its Telegram message ID.
+func (m *Magnifibot) MarkDelivered(ctx context.Context, chatID, key string, part DeliveryPart, messageID int) error {
+	_, err := m.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(m.Config.DeliveryTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: chatID},
+			"Date":   &types.AttributeValueMemberS{Value: key},
+		},
+		UpdateExpression:         aws.String("SET #part = :messageID, ExpiresAt = :expiresAt"),
+		ExpressionAttributeNames: map[string]string{"#part": string(part)},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":messageID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", messageID)},
+			":expiresAt": &types.AttributeValueMemberN{
+				Value: fmt.Sprintf("%d", time.Now().Add(DefaultDeliveryTTL).Unix()),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error marking %s of delivery %s as sent for chat %s: %w", part, key, chatID, err)
+	}
+	return nil
+}
+
+// MarkFailed records in the delivery ledger the error of a failed delivery attempt,
+// so that it can be inspected if the message ends up in the dead-letter queue.
+func (m *Magnifibot) MarkFailed(ctx context.Context, chatID, key, reason string) error {
+	_, err := m.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(m.Config.DeliveryTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: chatID},
+			"Date":   &types.AttributeValueMemberS{Value: key},
+		},
+		UpdateExpression: aws.String("SET LastError = :reason, ExpiresAt = :expiresAt ADD Attempts :one"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":reason": &types.AttributeValueMemberS{Value: reason},
+			":one":    &types.AttributeValueMemberN{Value: "1"},
+			":expiresAt": &types.AttributeValueMemberN{
+				Value: fmt.Sprintf("%d", time.Now().Add(DefaultDeliveryTTL).Unix()),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error marking delivery %s as failed for chat %s: %w", key, chatID, err)
+	}
+	return nil
+}
+
+// DeliverMagnificat sends a Magnificat to a chat as a sequence of Telegram messages.
+// Every part that is sent is recorded in the delivery ledger under key, and the parts
+// that had already been recorded are skipped, so that calling it again after a failure
+// resumes the delivery where it stopped instead of duplicating messages. The language
+// is the one of the chat, in which the texts of the bot are written.
+func (m *Magnifibot) DeliverMagnificat(
+	ctx context.Context,
+	chatID, key string,
+	magnificat *archimadrid.Magnificat,
+	lang api.Language,
+) error {
+	delivery, err := m.GetDelivery(ctx, chatID, key)
+	if err != nil {
+		return err
+	}
+
+	for _, part := range DeliveryParts {
+		if _, ok := delivery.Parts[part]; ok {
+			continue
+		}
+		text, ok := RenderPart(magnificat, part, lang)
+		if !ok {
+			continue
+		}
+		messageID, err := m.SendTelegram(ctx, chatID, text)
+		if err != nil {
+			return fmt.Errorf("error sending %s to chat %s: %w", part, chatID, err)
+		}
+		if err := m.MarkDelivered(ctx, chatID, key, part, messageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderPart formats a part of the Magnificat as a MarkdownV2 Telegram message, with
+// the texts of the bot in the given language. It returns false if the Magnificat has
+// no content for that part.
+func RenderPart(magnificat *archimadrid.Magnificat, part DeliveryPart, lang api.Language) (string, bool) {
+	switch part {
+	case HeaderPart:
+		if magnificat.Preview {
+			return fmt.Sprintf(
+				"_%s_\n*%s*",
+				utils.EscapeMarkdownV2(lang.Choose("Avance de las lecturas del domingo", "Preview of the Sunday readings")),
+				utils.EscapeMarkdownV2(magnificat.Day),
+			), true
+		}
+		return fmt.Sprintf("*%s*", utils.EscapeMarkdownV2(magnificat.Day)), true
+	case FirstLecturePart:
+		if magnificat.FirstLecture == nil {
+			return "", false
+		}
+		return renderLecture(
+			magnificat.FirstLecture.Reference,
+			magnificat.FirstLecture.Title,
+			magnificat.FirstLecture.Content,
+		), true
+	case PsalmPart:
+		if magnificat.Psalm == nil {
+			return "", false
+		}
+		// The title of the psalm holds its reference, and the reference holds the response
+		return renderLecture(magnificat.Psalm.Title, magnificat.Psalm.Reference, magnificat.Psalm.Content), true
+	case SecondLecturePart:
+		if magnificat.SecondLecture == nil {
+			return "", false
+		}
+		return renderLecture(
+			magnificat.SecondLecture.Reference,
+			magnificat.SecondLecture.Title,
+			magnificat.SecondLecture.Content,
+		), true
+	case GospelPart:
+		if magnificat.Gosp == nil {
+			return "", false
+		}
+		return renderLecture(magnificat.Gosp.Reference, magnificat.Gosp.Title, magnificat.Gosp.Content), true
+	}
+	return "", false
+}
+
+func renderLecture(reference, title, content string) string {
+	return fmt.Sprintf(
+		"*%s\n%s*\n\n%s",
+		utils.EscapeMarkdownV2(reference),
+		utils.EscapeMarkdownV2(title),
+		utils.EscapeMarkdownV2(content),
+	)
+}
@@ -0,0 +1,288 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/archimadrid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDelivery(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		expected      *Delivery
+		errorExpected bool
+	}{
+		{
+			name: "partial delivery",
+			dynamo: &MockDynamoDB{
+				getItemOutput: &dynamodb.GetItemOutput{
+					Item: map[string]types.AttributeValue{
+						"ChatID":    &types.AttributeValueMemberN{Value: "12"},
+						"Date":      &types.AttributeValueMemberS{Value: "2022-03-16"},
+						"Header":    &types.AttributeValueMemberN{Value: "100"},
+						"LastError": &types.AttributeValueMemberS{Value: "error sending Psalm"},
+						"ExpiresAt": &types.AttributeValueMemberN{Value: "1648000000"},
+					},
+				},
+			},
+			expected: &Delivery{
+				Parts:     map[DeliveryPart]int{HeaderPart: 100},
+				LastError: "error sending Psalm",
+			},
+			errorExpected: false,
+		},
+		{
+			name:          "no delivery",
+			dynamo:        &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{}},
+			expected:      &Delivery{Parts: map[DeliveryPart]int{}},
+			errorExpected: false,
+		},
+		{
+			name: "invalid message ID",
+			dynamo: &MockDynamoDB{
+				getItemOutput: &dynamodb.GetItemOutput{
+					Item: map[string]types.AttributeValue{
+						"Header": &types.AttributeValueMemberS{Value: "100"},
+					},
+				},
+			},
+			errorExpected: true,
+		},
+		{
+			name:          "error getting delivery",
+			dynamo:        &MockDynamoDB{errGetItem: errors.New("error")},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			actual, err := m.GetDelivery(context.TODO(), "12", "2022-03-16")
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestMarkDelivered(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		errorExpected bool
+	}{
+		{
+			name:          "valid mark delivered",
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "error marking delivered",
+			dynamo:        &MockDynamoDB{errUpdateItem: errors.New("error")},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.MarkDelivered(context.TODO(), "12", "2022-03-16", GospelPart, 100)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestMarkFailed(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		errorExpected bool
+	}{
+		{
+			name:          "valid mark failed",
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "error marking failed",
+			dynamo:        &MockDynamoDB{errUpdateItem: errors.New("error")},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.MarkFailed(context.TODO(), "12", "2022-03-16", "error sending Psalm")
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestDeliverMagnificat(t *testing.T) {
+	magnificat := &archimadrid.Magnificat{
+		Date: "2022-03-16",
+		Day:  "16/03/2022 - Miércoles de la 2ª semana de Cuaresma.",
+		FirstLecture: &archimadrid.Gospel{
+			Title:     "Venga, vamos a hablar mal de él.",
+			Reference: "Lectura del libro de Jeremías 18, 18-20",
+			Content:   "Ellos dijeron:",
+		},
+		Psalm: &archimadrid.Gospel{
+			Title:     "Sal 30, 5-6. 14. 15-16",
+			Reference: "R. Sálvame, Señor, por tu misericordia.",
+			Content:   "Sácame de la red que me han tendido",
+		},
+		Gosp: &archimadrid.Gospel{
+			Title:     "Lo condenarán a muerte.",
+			Reference: "Lectura del santo Evangelio según san Mateo 20, 17-28",
+			Content:   "En aquel tiempo",
+		},
+	}
+
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		telegram      *MockTelegram
+		expected      []string
+		errorExpected bool
+	}{
+		{
+			name:     "new delivery",
+			dynamo:   &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{}},
+			telegram: &MockTelegram{messageID: 1},
+			expected: []string{
+				`*16/03/2022 \- Miércoles de la 2ª semana de Cuaresma\.*`,
+				"*Lectura del libro de Jeremías 18, 18\\-20\nVenga, vamos a hablar mal de él\\.*\n\nEllos dijeron:",
+				"*Sal 30, 5\\-6\\. 14\\. 15\\-16\nR\\. Sálvame, Señor, por tu misericordia\\.*\n\nSácame de la red que me han tendido",
+				"*Lectura del santo Evangelio según san Mateo 20, 17\\-28\nLo condenarán a muerte\\.*\n\nEn aquel tiempo",
+			},
+			errorExpected: false,
+		},
+		{
+			name: "resumed delivery",
+			dynamo: &MockDynamoDB{
+				getItemOutput: &dynamodb.GetItemOutput{
+					Item: map[string]types.AttributeValue{
+						"Header":       &types.AttributeValueMemberN{Value: "1"},
+						"FirstLecture": &types.AttributeValueMemberN{Value: "2"},
+						"Psalm":        &types.AttributeValueMemberN{Value: "3"},
+					},
+				},
+			},
+			telegram: &MockTelegram{messageID: 4},
+			expected: []string{
+				"*Lectura del santo Evangelio según san Mateo 20, 17\\-28\nLo condenarán a muerte\\.*\n\nEn aquel tiempo",
+			},
+			errorExpected: false,
+		},
+		{
+			name: "completed delivery",
+			dynamo: &MockDynamoDB{
+				getItemOutput: &dynamodb.GetItemOutput{
+					Item: map[string]types.AttributeValue{
+						"Header":       &types.AttributeValueMemberN{Value: "1"},
+						"FirstLecture": &types.AttributeValueMemberN{Value: "2"},
+						"Psalm":        &types.AttributeValueMemberN{Value: "3"},
+						"Gospel":       &types.AttributeValueMemberN{Value: "4"},
+					},
+				},
+			},
+			telegram:      &MockTelegram{messageID: 5},
+			expected:      nil,
+			errorExpected: false,
+		},
+		{
+			name:          "error getting delivery",
+			dynamo:        &MockDynamoDB{errGetItem: errors.New("error")},
+			telegram:      &MockTelegram{messageID: 1},
+			expected:      nil,
+			errorExpected: true,
+		},
+		{
+			name:          "error sending telegram",
+			dynamo:        &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{}},
+			telegram:      &MockTelegram{err: errors.New("error")},
+			expected:      []string{`*16/03/2022 \- Miércoles de la 2ª semana de Cuaresma\.*`},
+			errorExpected: true,
+		},
+		{
+			name: "error marking delivered",
+			dynamo: &MockDynamoDB{
+				getItemOutput: &dynamodb.GetItemOutput{},
+				errUpdateItem: errors.New("error"),
+			},
+			telegram:      &MockTelegram{messageID: 1},
+			expected:      []string{`*16/03/2022 \- Miércoles de la 2ª semana de Cuaresma\.*`},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo), SetTelegramClient(test.telegram))
+			err := m.DeliverMagnificat(context.TODO(), "12", "2022-03-16", magnificat, api.Spanish)
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, test.telegram.texts)
+		})
+	}
+}
+
+func TestRenderHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		magnificat *archimadrid.Magnificat
+		lang       api.Language
+		expected   string
+	}{
+		{
+			name:       "day",
+			magnificat: &archimadrid.Magnificat{Day: "Domingo 3º de Cuaresma."},
+			lang:       api.Spanish,
+			expected:   "*Domingo 3º de Cuaresma\\.*",
+		},
+		{
+			name:       "preview",
+			magnificat: &archimadrid.Magnificat{Day: "Domingo 3º de Cuaresma.", Preview: true},
+			lang:       api.Spanish,
+			expected:   "_Avance de las lecturas del domingo_\n*Domingo 3º de Cuaresma\\.*",
+		},
+		{
+			name:       "preview in english",
+			magnificat: &archimadrid.Magnificat{Day: "Domingo 3º de Cuaresma.", Preview: true},
+			lang:       api.English,
+			expected:   "_Preview of the Sunday readings_\n*Domingo 3º de Cuaresma\\.*",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			actual, ok := RenderPart(test.magnificat, HeaderPart, test.lang)
+			assert.True(tt, ok)
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
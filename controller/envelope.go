@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/igvaquero18/magnifibot/archimadrid"
+)
+
+// EnvelopeVersion is the version of the schema of the envelopes sent to the queue.
+// It must be increased whenever a change is not compatible with the consumers.
+const EnvelopeVersion = 1
+
+// MessageKind is the type of content carried by a message of the queue
+type MessageKind string
+
+const (
+	// MagnificatKind messages carry the lectures of a day
+	MagnificatKind MessageKind = "magnificat"
+
+	// BroadcastKind messages carry a broadcast of the operators of the bot
+	BroadcastKind MessageKind = "broadcast"
+)
+
+var (
+	// ErrUnknownKind is returned for the envelopes of a kind that this version
+	// of the bot does not know how to deliver
+	ErrUnknownKind = errors.New("unknown message kind")
+
+	// ErrUnsupportedVersion is returned for the envelopes of a newer schema
+	ErrUnsupportedVersion = errors.New("unsupported envelope version")
+
+	// ErrInvalidMessage is returned for the envelopes whose content is not valid
+	ErrInvalidMessage = errors.New("invalid message")
+)
+
+// messageKinds are the kinds of messages that can be delivered
+var messageKinds = map[MessageKind]bool{
+	MagnificatKind: true,
+	BroadcastKind:  true,
+}
+
+// Message is the content of a message of the queue, shared by all
+// the chats it is sent to
+type Message struct {
+	Kind    MessageKind
+	Payload json.RawMessage
+}
+
+// NewMessage converts the payload of a message into JSON
+func NewMessage(kind MessageKind, payload interface{}) (*Message, error) {
+	if !messageKinds[kind] {
+		return nil, fmt.Errorf("error creating message: %w: %q", ErrUnknownKind, kind)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error converting %s message into JSON: %w", kind, err)
+	}
+	return &Message{Kind: kind, Payload: data}, nil
+}
+
+// Envelope returns the body of the message of the queue that delivers the message to a chat
+func (m *Message) Envelope(chatID string, onDemand bool) (string, error) {
+	return m.envelope(chatID, onDemand, 0)
+}
+
+// envelope returns the body of a message of the queue that must not be delivered
+// before the notBefore time, in seconds since the epoch, unless it is zero
+func (m *Message) envelope(chatID string, onDemand bool, notBefore int64) (string, error) {
+	id, err := strconv.ParseInt(chatID, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("error converting chat ID from string to integer: %w", err)
+	}
+	body, err := json.Marshal(Envelope{
+		Kind:      m.Kind,
+		Version:   EnvelopeVersion,
+		ChatID:    id,
+		OnDemand:  onDemand,
+		NotBefore: notBefore,
+		Payload:   m.Payload,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error converting envelope into JSON: %w", err)
+	}
+	return string(body), nil
+}
+
+// Envelope is the body of every message of the queue
+type Envelope struct {
+	Kind    MessageKind `json:"kind"`
+	Version int         `json:"version"`
+	ChatID  int64       `json:"chat_id"`
+
+	// OnDemand is set for the lectures requested by a user, which are delivered even
+	// if the chat is not subscribed or is paused. They share the queue with the rest
+	// of the messages, so they are not delivered any sooner.
+	OnDemand bool `json:"on_demand,omitempty"`
+
+	// NotBefore is the time, in seconds since the epoch, before which the message
+	// must not be delivered. It is set for the messages that have to be hidden for
+	// longer than the queue allows, which are deferred again until then.
+	NotBefore int64           `json:"not_before,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// Deferred tells whether the envelope must not be delivered yet
+func (e *Envelope) Deferred(now time.Time) bool {
+	return e.NotBefore > now.Unix()
+}
+
+// ParseEnvelope reads and validates the body of a message of the queue. Bodies
+// sent before envelopes existed carry a Magnificat, and their chat is taken
+// from the chatID attribute of the message.
+func ParseEnvelope(body, chatIDAttribute string) (*Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return nil, fmt.Errorf("error unmarshalling envelope: %w", err)
+	}
+
+	if envelope.Kind == "" && envelope.Version == 0 {
+		id, err := strconv.ParseInt(chatIDAttribute, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error converting chat ID from string to integer: %w", err)
+		}
+		envelope = Envelope{Kind: MagnificatKind, ChatID: id, Payload: json.RawMessage(body)}
+	} else if envelope.Version < 1 || envelope.Version > EnvelopeVersion {
+		return nil, fmt.Errorf("error parsing envelope: %w: %d", ErrUnsupportedVersion, envelope.Version)
+	}
+
+	if !messageKinds[envelope.Kind] {
+		return nil, fmt.Errorf("error parsing envelope: %w: %q", ErrUnknownKind, envelope.Kind)
+	}
+	if envelope.ChatID == 0 {
+		return nil, fmt.Errorf("error parsing envelope: %w: missing chat ID", ErrInvalidMessage)
+	}
+	if len(envelope.Payload) == 0 || string(envelope.Payload) == "null" {
+		return nil, fmt.Errorf("error parsing envelope: %w: missing payload", ErrInvalidMessage)
+	}
+	return &envelope, nil
+}
+
+// Magnificat returns the lectures carried by a MagnificatKind envelope
+func (e *Envelope) Magnificat() (*archimadrid.Magnificat, error) {
+	if e.Kind != MagnificatKind {
+		return nil, fmt.Errorf("error reading magnificat: %w: %q", ErrUnknownKind, e.Kind)
+	}
+	var magnificat archimadrid.Magnificat
+	if err := json.Unmarshal(e.Payload, &magnificat); err != nil {
+		return nil, fmt.Errorf("error unmarshalling magnificat: %w", err)
+	}
+	if magnificat.Date == "" && magnificat.Day == "" {
+		return nil, fmt.Errorf("error reading magnificat: %w: missing day", ErrInvalidMessage)
+	}
+	if magnificat.Gosp == nil {
+		return nil, fmt.Errorf("error reading magnificat %s: %w: missing gospel", magnificat.Date, ErrInvalidMessage)
+	}
+	return &magnificat, nil
+}
+
+// Broadcast returns the broadcast carried by a BroadcastKind envelope
+func (e *Envelope) Broadcast() (*BroadcastMessage, error) {
+	if e.Kind != BroadcastKind {
+		return nil, fmt.Errorf("error reading broadcast: %w: %q", ErrUnknownKind, e.Kind)
+	}
+	var broadcast BroadcastMessage
+	if err := json.Unmarshal(e.Payload, &broadcast); err != nil {
+		return nil, fmt.Errorf("error unmarshalling broadcast: %w", err)
+	}
+	if broadcast.BroadcastID == "" || broadcast.Text == "" {
+		return nil, fmt.Errorf("error reading broadcast: %w: missing ID or text", ErrInvalidMessage)
+	}
+	return &broadcast, nil
+}
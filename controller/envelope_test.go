@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/igvaquero18/magnifibot/archimadrid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMessage(t *testing.T) {
+	message, err := NewMessage(BroadcastKind, BroadcastMessage{BroadcastID: "abc", Text: "Hola"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Message{
+		Kind:    BroadcastKind,
+		Payload: json.RawMessage(`{"broadcast_id":"abc","text":"Hola"}`),
+	}, message)
+
+	_, err = NewMessage("poll", "payload")
+	assert.ErrorIs(t, err, ErrUnknownKind)
+}
+
+func TestMessageEnvelope(t *testing.T) {
+	message := &Message{Kind: BroadcastKind, Payload: json.RawMessage(`{"broadcast_id":"abc","text":"Hola"}`)}
+
+	body, err := message.Envelope("-12", false)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"kind":"broadcast","version":1,"chat_id":-12,"payload":{"broadcast_id":"abc","text":"Hola"}}`, body)
+
+	body, err = message.Envelope("12", true)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"kind":"broadcast","version":1,"chat_id":12,"on_demand":true,"payload":{"broadcast_id":"abc","text":"Hola"}}`, body)
+
+	body, err = message.envelope("12", false, 1700000000)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"kind":"broadcast","version":1,"chat_id":12,"not_before":1700000000,"payload":{"broadcast_id":"abc","text":"Hola"}}`, body)
+
+	_, err = message.Envelope("invalid", false)
+	assert.Error(t, err)
+}
+
+func TestEnvelopeDeferred(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	assert.False(t, (&Envelope{}).Deferred(now))
+	assert.False(t, (&Envelope{NotBefore: now.Unix()}).Deferred(now))
+	assert.True(t, (&Envelope{NotBefore: now.Unix() + 1}).Deferred(now))
+}
+
+func TestParseEnvelope(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		attribute   string
+		expected    *Envelope
+		expectedErr error
+	}{
+		{
+			name:      "valid envelope",
+			body:      `{"kind":"magnificat","version":1,"chat_id":12,"payload":{"day":"today"}}`,
+			attribute: "13",
+			expected: &Envelope{
+				Kind:    MagnificatKind,
+				Version: 1,
+				ChatID:  12,
+				Payload: json.RawMessage(`{"day":"today"}`),
+			},
+		},
+		{
+			name:      "on demand envelope",
+			body:      `{"kind":"magnificat","version":1,"chat_id":12,"on_demand":true,"payload":{"day":"today"}}`,
+			attribute: "12",
+			expected: &Envelope{
+				Kind:     MagnificatKind,
+				Version:  1,
+				ChatID:   12,
+				OnDemand: true,
+				Payload:  json.RawMessage(`{"day":"today"}`),
+			},
+		},
+		{
+			name:      "legacy magnificat",
+			body:      `{"day":"today"}`,
+			attribute: "13",
+			expected: &Envelope{
+				Kind:    MagnificatKind,
+				ChatID:  13,
+				Payload: json.RawMessage(`{"day":"today"}`),
+			},
+		},
+		{
+			name:        "unknown kind",
+			body:        `{"kind":"poll","version":1,"chat_id":12,"payload":{}}`,
+			expectedErr: ErrUnknownKind,
+		},
+		{
+			name:        "newer version",
+			body:        `{"kind":"magnificat","version":2,"chat_id":12,"payload":{}}`,
+			expectedErr: ErrUnsupportedVersion,
+		},
+		{
+			name:        "kind without version",
+			body:        `{"kind":"magnificat","chat_id":12,"payload":{}}`,
+			expectedErr: ErrUnsupportedVersion,
+		},
+		{
+			name:        "missing chat ID",
+			body:        `{"kind":"magnificat","version":1,"payload":{}}`,
+			expectedErr: ErrInvalidMessage,
+		},
+		{
+			name:        "missing payload",
+			body:        `{"kind":"magnificat","version":1,"chat_id":12}`,
+			expectedErr: ErrInvalidMessage,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			actual, err := ParseEnvelope(test.body, test.attribute)
+			if test.expectedErr != nil {
+				assert.ErrorIs(tt, err, test.expectedErr)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+
+	_, err := ParseEnvelope("invalid", "12")
+	assert.Error(t, err)
+	_, err = ParseEnvelope(`{"day":"today"}`, "")
+	assert.Error(t, err)
+}
+
+func TestEnvelopeMagnificat(t *testing.T) {
+	tests := []struct {
+		name        string
+		envelope    *Envelope
+		expected    *archimadrid.Magnificat
+		expectedErr error
+	}{
+		{
+			name: "valid magnificat",
+			envelope: &Envelope{
+				Kind:    MagnificatKind,
+				Payload: json.RawMessage(`{"date":"2022-03-16","day":"today","gospel":{"title":"Gospel"}}`),
+			},
+			expected: &archimadrid.Magnificat{
+				Date: "2022-03-16",
+				Day:  "today",
+				Gosp: &archimadrid.Gospel{Title: "Gospel"},
+			},
+		},
+		{
+			name:        "missing gospel",
+			envelope:    &Envelope{Kind: MagnificatKind, Payload: json.RawMessage(`{"day":"today"}`)},
+			expectedErr: ErrInvalidMessage,
+		},
+		{
+			name:        "missing day",
+			envelope:    &Envelope{Kind: MagnificatKind, Payload: json.RawMessage(`{"gospel":{"title":"Gospel"}}`)},
+			expectedErr: ErrInvalidMessage,
+		},
+		{
+			name:        "other kind",
+			envelope:    &Envelope{Kind: BroadcastKind, Payload: json.RawMessage(`{}`)},
+			expectedErr: ErrUnknownKind,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			actual, err := test.envelope.Magnificat()
+			if test.expectedErr != nil {
+				assert.ErrorIs(tt, err, test.expectedErr)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestEnvelopeBroadcast(t *testing.T) {
+	envelope := &Envelope{Kind: BroadcastKind, Payload: json.RawMessage(`{"broadcast_id":"abc","text":"Hola"}`)}
+	broadcast, err := envelope.Broadcast()
+	assert.NoError(t, err)
+	assert.Equal(t, &BroadcastMessage{BroadcastID: "abc", Text: "Hola"}, broadcast)
+
+	envelope = &Envelope{Kind: BroadcastKind, Payload: json.RawMessage(`{"broadcast_id":"abc"}`)}
+	_, err = envelope.Broadcast()
+	assert.ErrorIs(t, err, ErrInvalidMessage)
+
+	envelope = &Envelope{Kind: MagnificatKind, Payload: json.RawMessage(`{"day":"today"}`)}
+	_, err = envelope.Broadcast()
+	assert.ErrorIs(t, err, ErrUnknownKind)
+}
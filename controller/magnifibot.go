@@ -2,12 +2,15 @@ package controller
 
 import (
 	"context"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/igvaquero18/magnifibot/api"
+	"github.com/igvaquero18/magnifibot/archimadrid"
 	"github.com/mymmrac/telego"
 )
 
@@ -16,13 +19,53 @@ type Option func(m *Magnifibot) Option
 
 // MagnifibotInterface is the interface implemented by the SmartHome Controller
 type MagnifibotInterface interface {
-	Suscribe(ctx context.Context, chatID, userID, date int64, kind string) error
+	Suscribe(ctx context.Context, sub *Subscription) error
+	GetSubscription(ctx context.Context, chatID int64) (*Subscription, error)
+	RecordDelivery(ctx context.Context, chatID int64, date string) error
+	SetSchedule(ctx context.Context, chatID int64, schedule *Schedule) error
+	SetPreview(ctx context.Context, chatID int64, enabled bool) error
+	Pause(ctx context.Context, chatID int64, until time.Time) error
+	Resume(ctx context.Context, chatID int64) error
+	MigrateSubscriptions(ctx context.Context) (int, error)
 	Unsuscribe(ctx context.Context, chatID int64) error
-	GetChatIDs(ctx context.Context) ([]string, error)
-	SendMessageToQueue(ctx context.Context, chatID, message string) (string, error)
+	Deactivate(ctx context.Context, chatID int64, reason string, date int64) error
+	GetInactiveSubscriptions(ctx context.Context) ([]InactiveSubscription, error)
+	GetSubscriptionStats(ctx context.Context) (*SubscriptionStats, error)
+	ScanChatIDs(ctx context.Context, filter ChatFilter, fn func(chatIDs []string) error) error
+	HasChats(ctx context.Context, filter ChatFilter) (bool, error)
+	SetPreferences(ctx context.Context, chatID int64, prefs *Preferences) error
+	GetPreferences(ctx context.Context, chatID int64) (*Preferences, error)
+	IsChatAdmin(ctx context.Context, chatID, userID int64) (bool, error)
+	SetPolicy(ctx context.Context, chatID int64, policy string) error
+	GetPolicy(ctx context.Context, chatID int64) (string, error)
+	GetConversation(ctx context.Context, chatID int64) (*Conversation, error)
+	SaveConversation(ctx context.Context, chatID int64, conversation *Conversation) error
+	EndConversation(ctx context.Context, chatID int64) error
+	SendMessageToQueue(ctx context.Context, chatID string, message *Message, onDemand bool) (string, error)
+	SendMessagesToQueue(ctx context.Context, chatIDs []string, message *Message) map[string]error
+	SendThrottledMessagesToQueue(ctx context.Context, chatIDs []string, message *Message, first, rate int) map[string]error
+	DeferEnvelope(ctx context.Context, envelope *Envelope) error
+	CreateBroadcast(ctx context.Context, b *Broadcast) error
+	GetBroadcast(ctx context.Context, id string) (*Broadcast, error)
+	ListBroadcasts(ctx context.Context) ([]Broadcast, error)
+	StartBroadcast(ctx context.Context, id string) error
+	CancelBroadcast(ctx context.Context, id string) error
+	AbortBroadcast(ctx context.Context, id string) error
+	SendBroadcast(ctx context.Context, b *Broadcast, rate int) (int, int, error)
+	RecordBroadcastDelivery(ctx context.Context, id string, delivered bool) error
 	GetConfig() *MagnifibotConfig
 	SendTelegram(ctx context.Context, chatID, message string) (int, error)
+	SendTelegramKeyboard(ctx context.Context, chatID, message string, keyboard *api.InlineKeyboardMarkup) (int, error)
+	AnswerCallback(ctx context.Context, callbackID, text string) error
 	Invoke(ctx context.Context, functionName string, payload map[string]interface{}) (int32, error)
+	GetDelivery(ctx context.Context, chatID, key string) (*Delivery, error)
+	MarkDelivered(ctx context.Context, chatID, key string, part DeliveryPart, messageID int) error
+	MarkFailed(ctx context.Context, chatID, key, reason string) error
+	DeliverMagnificat(ctx context.Context, chatID, key string, magnificat *archimadrid.Magnificat, lang api.Language) error
+	ReceiveDeadLetters(ctx context.Context, max int) ([]DeadLetter, error)
+	ReplayDeadLetter(ctx context.Context, deadLetter DeadLetter) (string, error)
+	DeleteDeadLetter(ctx context.Context, deadLetter DeadLetter) error
+	UpdateStore
 }
 
 // DynamoDBInterface is an interface implemented by the dynamodb.Client that allow
@@ -30,6 +73,11 @@ type MagnifibotInterface interface {
 type DynamoDBInterface interface {
 	GetItem(context.Context, *dynamodb.GetItemInput, ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
 	PutItem(context.Context, *dynamodb.PutItemInput, ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(
+		context.Context,
+		*dynamodb.UpdateItemInput,
+		...func(*dynamodb.Options),
+	) (*dynamodb.UpdateItemOutput, error)
 	DeleteItem(
 		context.Context,
 		*dynamodb.DeleteItemInput,
@@ -38,17 +86,33 @@ type DynamoDBInterface interface {
 	dynamodb.ScanAPIClient
 }
 
-// SQSSendMessageAPI defines the interface for the SendMessage function.
+// SQSSendMessageAPI defines the interface for the SendMessage and SendMessageBatch functions.
 // We use this interface to test the functions using a mocked service.
 type SQSSendMessageAPI interface {
 	SendMessage(ctx context.Context,
 		params *sqs.SendMessageInput,
 		optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	SendMessageBatch(ctx context.Context,
+		params *sqs.SendMessageBatchInput,
+		optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+}
+
+// SQSDeadLetterAPI defines the interface for consuming messages from the
+// dead-letter queue. We use this interface to test the functions using a mocked service.
+type SQSDeadLetterAPI interface {
+	ReceiveMessage(ctx context.Context,
+		params *sqs.ReceiveMessageInput,
+		optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context,
+		params *sqs.DeleteMessageInput,
+		optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
 }
 
 // TelegramAPI is the interface implemented by the Telegram API
 type TelegramAPI interface {
 	SendMessage(params *telego.SendMessageParams) (*telego.Message, error)
+	AnswerCallbackQuery(params *telego.AnswerCallbackQueryParams) error
+	GetChatMember(params *telego.GetChatMemberParams) (telego.ChatMember, error)
 }
 
 // LambdaInvokeAPI defines the interface for interacting with Lambda
@@ -64,7 +128,9 @@ type LambdaInvokeAPI interface {
 // Magnifibot is the controller for the Magnifibot application.
 type Magnifibot struct {
 	DynamoDBInterface
+	SubscriberStore
 	SQSSendMessageAPI
+	SQSDeadLetterAPI
 	LambdaInvokeAPI
 	TelegramAPI
 	Config *MagnifibotConfig
@@ -76,20 +142,56 @@ type MagnifibotConfig struct {
 	// UserTable is the name of the User table in DynamoDB
 	UserTable string
 
+	// ScanSegments is the number of segments in which the User table
+	// is split to be scanned in parallel
+	ScanSegments int
+
+	// DeliveryTable is the name of the DynamoDB table used as a ledger
+	// of the messages already delivered to each chat
+	DeliveryTable string
+
+	// ConversationTable is the name of the DynamoDB table where the state
+	// of the conversations with the chats is kept
+	ConversationTable string
+
+	// UpdateTable is the name of the DynamoDB table where the updates
+	// of Telegram already handled are remembered
+	UpdateTable string
+
+	// BroadcastTable is the name of the DynamoDB table where the
+	// broadcasts and the statistics of their delivery are kept
+	BroadcastTable string
+
 	// QueueURL is the URL of the SQS queue
 	QueueURL string
+
+	// QueueConcurrency is the maximum number of batches of messages
+	// sent concurrently to the SQS queue
+	QueueConcurrency int
+
+	// DeadLetterQueueURL is the URL of the SQS queue where the messages
+	// that could not be delivered end up
+	DeadLetterQueueURL string
 }
 
 func NewMagnifibot(opts ...Option) *Magnifibot {
 	m := &Magnifibot{
 		Config: &MagnifibotConfig{
-			UserTable: DefaultUserTable,
+			UserTable:         DefaultUserTable,
+			ScanSegments:      DefaultScanSegments,
+			DeliveryTable:     DefaultDeliveryTable,
+			ConversationTable: DefaultConversationTable,
+			UpdateTable:       DefaultUpdateTable,
+			BroadcastTable:    DefaultBroadcastTable,
 		},
 	}
 
 	for _, opt := range opts {
 		opt(m)
 	}
+	if m.SubscriberStore == nil {
+		m.SubscriberStore = NewDynamoDBSubscriberStore(m.DynamoDBInterface, m.Config.UserTable, m.Config.ScanSegments)
+	}
 	return m
 }
 
@@ -102,6 +204,16 @@ func SetDynamoDBClient(client DynamoDBInterface) Option {
 	}
 }
 
+// SetSubscriberStore sets the store of the subscriptions. The User table of the
+// DynamoDB client is used when it is not set.
+func SetSubscriberStore(store SubscriberStore) Option {
+	return func(m *Magnifibot) Option {
+		prev := m.SubscriberStore
+		m.SubscriberStore = store
+		return SetSubscriberStore(prev)
+	}
+}
+
 // SetSQSClient sets the SQS client
 func SetSQSClient(client SQSSendMessageAPI) Option {
 	return func(m *Magnifibot) Option {
@@ -111,6 +223,15 @@ func SetSQSClient(client SQSSendMessageAPI) Option {
 	}
 }
 
+// SetSQSDeadLetterClient sets the SQS client used to consume the dead-letter queue
+func SetSQSDeadLetterClient(client SQSDeadLetterAPI) Option {
+	return func(m *Magnifibot) Option {
+		prev := m.SQSDeadLetterAPI
+		m.SQSDeadLetterAPI = client
+		return SetSQSDeadLetterClient(prev)
+	}
+}
+
 // SetLambdaClient sets the Lambda client
 func SetLambdaClient(client LambdaInvokeAPI) Option {
 	return func(m *Magnifibot) Option {
@@ -138,6 +259,26 @@ func SetConfig(c *MagnifibotConfig) Option {
 			c.UserTable = DefaultUserTable
 		}
 
+		if c.ScanSegments < 1 {
+			c.ScanSegments = DefaultScanSegments
+		}
+
+		if c.DeliveryTable == "" {
+			c.DeliveryTable = DefaultDeliveryTable
+		}
+
+		if c.ConversationTable == "" {
+			c.ConversationTable = DefaultConversationTable
+		}
+
+		if c.UpdateTable == "" {
+			c.UpdateTable = DefaultUpdateTable
+		}
+
+		if c.BroadcastTable == "" {
+			c.BroadcastTable = DefaultBroadcastTable
+		}
+
 		m.Config = c
 		return SetConfig(prev)
 	}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultOffsetFile is the file where the offset of the updates is kept when polling
+const DefaultOffsetFile = "magnifibot.offset"
+
+// OffsetStore remembers the offset of the next update to get from Telegram when
+// polling, so that the updates handled before a restart are not handled again
+type OffsetStore interface {
+	// GetOffset returns the offset saved, or 0 if none was saved yet
+	GetOffset(ctx context.Context) (int64, error)
+
+	// SetOffset saves the offset of the next update to get
+	SetOffset(ctx context.Context, offset int64) error
+}
+
+// FileOffsetStore is an OffsetStore that keeps the offset in a file
+type FileOffsetStore struct {
+	path string
+}
+
+// NewFileOffsetStore returns an OffsetStore that keeps the offset in the file of the given path
+func NewFileOffsetStore(path string) *FileOffsetStore {
+	return &FileOffsetStore{path: path}
+}
+
+// GetOffset reads the offset from the file, which may not exist yet
+func (s *FileOffsetStore) GetOffset(ctx context.Context) (int64, error) {
+	content, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading offset from %s: %w", s.path, err)
+	}
+
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing offset from %s: %w", s.path, err)
+	}
+	return offset, nil
+}
+
+// SetOffset writes the offset to a temporary file that then replaces the file,
+// so that the offset is not lost if the process stops while writing it
+func (s *FileOffsetStore) SetOffset(ctx context.Context, offset int64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file for offset: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strconv.FormatInt(offset, 10) + "\n"); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing offset to %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing offset to %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("error saving offset to %s: %w", s.path, err)
+	}
+	return nil
+}
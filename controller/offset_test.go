@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileOffsetStore(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileOffsetStore(filepath.Join(dir, DefaultOffsetFile))
+
+	offset, err := s.GetOffset(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), offset)
+
+	assert.NoError(t, s.SetOffset(context.TODO(), 42))
+	offset, err = s.GetOffset(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), offset)
+
+	assert.NoError(t, s.SetOffset(context.TODO(), 43))
+	offset, err = NewFileOffsetStore(filepath.Join(dir, DefaultOffsetFile)).GetOffset(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(43), offset)
+
+	// Only the file of the offset is left
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "invalid"), []byte("invalid"), 0o600))
+	_, err = NewFileOffsetStore(filepath.Join(dir, "invalid")).GetOffset(context.TODO())
+	assert.Error(t, err)
+
+	err = NewFileOffsetStore(filepath.Join(dir, "missing", DefaultOffsetFile)).SetOffset(context.TODO(), 42)
+	assert.Error(t, err)
+}
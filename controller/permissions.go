@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mymmrac/telego"
+)
+
+const (
+	// AdminsPolicy only allows the administrators of a group or channel
+	// to change its subscription
+	AdminsPolicy = "admins"
+
+	// AnyonePolicy allows any member of a group to change its subscription
+	AnyonePolicy = "anyone"
+
+	DefaultPolicy = AdminsPolicy
+)
+
+// IsChatAdmin returns whether the user is the creator or an administrator of the chat
+func (m *Magnifibot) IsChatAdmin(ctx context.Context, chatID, userID int64) (bool, error) {
+	member, err := m.TelegramAPI.GetChatMember(&telego.GetChatMemberParams{
+		ChatID: telego.ChatID{ID: chatID},
+		UserID: userID,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error getting member %d of chat %d: %w", userID, chatID, err)
+	}
+
+	switch member.MemberStatus() {
+	case telego.MemberStatusCreator, telego.MemberStatusAdministrator:
+		return true, nil
+	}
+	return false, nil
+}
+
+// validatePolicy checks the policy that a chat is about to store
+func validatePolicy(chatID int64, policy string) error {
+	if policy != AdminsPolicy && policy != AnyonePolicy {
+		return fmt.Errorf("error setting policy of chat %d: unknown policy %s", chatID, policy)
+	}
+	return nil
+}
+
+// SetPolicy sets who may change the subscription of a subscribed group or channel
+func (s *DynamoDBSubscriberStore) SetPolicy(ctx context.Context, chatID int64, policy string) error {
+	if err := validatePolicy(chatID, policy); err != nil {
+		return err
+	}
+
+	_, err := s.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(ChatID)"),
+		UpdateExpression:    aws.String("SET #policy = :policy, UpdatedAt = :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#policy": "Policy",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":policy": &types.AttributeValueMemberS{Value: policy},
+			":now":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error setting policy of chat %d: %w", chatID, err)
+	}
+	return nil
+}
+
+// GetPolicy returns who may change the subscription of a chat. Chats that are not
+// subscribed or have not chosen any get DefaultPolicy.
+func (s *DynamoDBSubscriberStore) GetPolicy(ctx context.Context, chatID int64) (string, error) {
+	output, err := s.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+		ProjectionExpression: aws.String("#policy"),
+		ExpressionAttributeNames: map[string]string{
+			"#policy": "Policy",
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error getting policy of chat %d: %w", chatID, err)
+	}
+
+	if policy := getString(output.Item, "Policy"); policy != "" {
+		return policy, nil
+	}
+	return DefaultPolicy, nil
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mymmrac/telego"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsChatAdmin(t *testing.T) {
+	tests := []struct {
+		name          string
+		telegram      *MockTelegram
+		expected      bool
+		errorExpected bool
+	}{
+		{
+			name:          "creator",
+			telegram:      &MockTelegram{member: &telego.ChatMemberOwner{Status: telego.MemberStatusCreator}},
+			expected:      true,
+			errorExpected: false,
+		},
+		{
+			name:          "administrator",
+			telegram:      &MockTelegram{member: &telego.ChatMemberAdministrator{Status: telego.MemberStatusAdministrator}},
+			expected:      true,
+			errorExpected: false,
+		},
+		{
+			name:          "member",
+			telegram:      &MockTelegram{member: &telego.ChatMemberMember{Status: telego.MemberStatusMember}},
+			expected:      false,
+			errorExpected: false,
+		},
+		{
+			name:          "telegram error",
+			telegram:      &MockTelegram{errMember: errors.New("Bad Request: user not found")},
+			expected:      false,
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetTelegramClient(test.telegram))
+			actual, err := m.IsChatAdmin(context.TODO(), -12, 34)
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestSetPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        string
+		dynamo        DynamoDBInterface
+		errorExpected bool
+	}{
+		{
+			name:          "valid policy",
+			policy:        AnyonePolicy,
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "unknown policy",
+			policy:        "nobody",
+			dynamo:        &MockDynamoDB{},
+			errorExpected: true,
+		},
+		{
+			name:          "chat not suscribed",
+			policy:        AdminsPolicy,
+			dynamo:        &MockDynamoDB{errUpdateItem: &types.ConditionalCheckFailedException{}},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.SetPolicy(context.TODO(), -12, test.policy)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestGetPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		expected      string
+		errorExpected bool
+	}{
+		{
+			name: "chosen policy",
+			dynamo: &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+				"Policy": &types.AttributeValueMemberS{Value: AnyonePolicy},
+			}}},
+			expected:      AnyonePolicy,
+			errorExpected: false,
+		},
+		{
+			name:          "chat not suscribed",
+			dynamo:        &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{}},
+			expected:      DefaultPolicy,
+			errorExpected: false,
+		},
+		{
+			name:          "dynamodb error",
+			dynamo:        &MockDynamoDB{errGetItem: errors.New("error")},
+			expected:      "",
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			actual, err := m.GetPolicy(context.TODO(), -12)
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
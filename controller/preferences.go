@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/magnifibot/archimadrid"
+)
+
+const (
+	// GospelProfile only sends the Gospel of the day
+	GospelProfile = "gospel"
+
+	// FullProfile sends all the lectures of the day
+	FullProfile = "full"
+)
+
+const (
+	DefaultProfile      = FullProfile
+	DefaultLanguage     = "es"
+	DefaultDeliveryHour = 6
+	DefaultTimezone     = "Europe/Madrid"
+
+	// DefaultPreviewWeekday is the day in which the readings of the next
+	// Sunday are sent to the chats that want them in advance
+	DefaultPreviewWeekday = time.Thursday
+)
+
+// Preferences are the delivery settings chosen by a chat
+type Preferences struct {
+	// Language is the language in which the bot talks to the chat
+	Language string
+
+	// Profile is the set of lectures that the chat receives every day
+	Profile string
+
+	// DeliveryHour is the hour of the day, in DefaultTimezone, at which
+	// the chat receives the Gospel
+	DeliveryHour int
+}
+
+// DefaultPreferences returns the preferences of the chats that have not chosen any
+func DefaultPreferences() *Preferences {
+	return &Preferences{
+		Language:     DefaultLanguage,
+		Profile:      DefaultProfile,
+		DeliveryHour: DefaultDeliveryHour,
+	}
+}
+
+// Readings returns the part of the Magnificat that the chat wants to receive
+func (p *Preferences) Readings(magnificat *archimadrid.Magnificat) *archimadrid.Magnificat {
+	if p.Profile != GospelProfile {
+		return magnificat
+	}
+	// The rest of the Magnificat, like whether it is a preview, is kept
+	readings := *magnificat
+	readings.FirstLecture = nil
+	readings.Psalm = nil
+	readings.SecondLecture = nil
+	return &readings
+}
+
+// validate checks the preferences that a chat is about to store
+func (p *Preferences) validate(chatID int64) error {
+	if p.Profile != GospelProfile && p.Profile != FullProfile {
+		return fmt.Errorf("error setting preferences of chat %d: unknown profile %s", chatID, p.Profile)
+	}
+	if p.DeliveryHour < 0 || p.DeliveryHour > 23 {
+		return fmt.Errorf("error setting preferences of chat %d: invalid hour %d", chatID, p.DeliveryHour)
+	}
+	return nil
+}
+
+// SetPreferences stores the preferences of a subscribed chat
+func (s *DynamoDBSubscriberStore) SetPreferences(ctx context.Context, chatID int64, prefs *Preferences) error {
+	if err := prefs.validate(chatID); err != nil {
+		return err
+	}
+
+	_, err := s.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(ChatID)"),
+		UpdateExpression:    aws.String("SET #language = :language, #profile = :profile, #hour = :hour, UpdatedAt = :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#language": "Language",
+			"#profile":  "Profile",
+			"#hour":     "DeliveryHour",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":language": &types.AttributeValueMemberS{Value: prefs.Language},
+			":profile":  &types.AttributeValueMemberS{Value: prefs.Profile},
+			":hour":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", prefs.DeliveryHour)},
+			":now":      &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error setting preferences of chat %d: %w", chatID, err)
+	}
+	return nil
+}
+
+// GetPreferences returns the preferences of a chat, filling the ones it has not
+// chosen with the default values. It returns nil if the chat is not subscribed.
+func (s *DynamoDBSubscriberStore) GetPreferences(ctx context.Context, chatID int64) (*Preferences, error) {
+	output, err := s.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting preferences of chat %d: %w", chatID, err)
+	}
+	if output.Item == nil {
+		return nil, nil
+	}
+	return preferencesFromItem(output.Item)
+}
+
+// preferencesFromItem reads the preferences stored in an item of the User table
+func preferencesFromItem(item map[string]types.AttributeValue) (*Preferences, error) {
+	prefs := DefaultPreferences()
+	if language := getString(item, "Language"); language != "" {
+		prefs.Language = language
+	}
+	if profile := getString(item, "Profile"); profile != "" {
+		prefs.Profile = profile
+	}
+	if _, ok := item["DeliveryHour"]; ok {
+		hour, err := getNumber(item, "DeliveryHour")
+		if err != nil {
+			return nil, err
+		}
+		prefs.DeliveryHour = int(hour)
+	}
+	return prefs, nil
+}
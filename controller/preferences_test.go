@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/igvaquero18/magnifibot/archimadrid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetPreferences(t *testing.T) {
+	tests := []struct {
+		name          string
+		prefs         *Preferences
+		dynamo        DynamoDBInterface
+		errorExpected bool
+	}{
+		{
+			name:          "valid preferences",
+			prefs:         &Preferences{Language: "en", Profile: GospelProfile, DeliveryHour: 8},
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "unknown profile",
+			prefs:         &Preferences{Language: "es", Profile: "psalms", DeliveryHour: 8},
+			dynamo:        &MockDynamoDB{},
+			errorExpected: true,
+		},
+		{
+			name:          "invalid hour",
+			prefs:         &Preferences{Language: "es", Profile: FullProfile, DeliveryHour: 24},
+			dynamo:        &MockDynamoDB{},
+			errorExpected: true,
+		},
+		{
+			name:          "chat not suscribed",
+			prefs:         DefaultPreferences(),
+			dynamo:        &MockDynamoDB{errUpdateItem: &types.ConditionalCheckFailedException{}},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.SetPreferences(context.TODO(), 12, test.prefs)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestGetPreferences(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		expected      *Preferences
+		errorExpected bool
+	}{
+		{
+			name: "chosen preferences",
+			dynamo: &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+				"ChatID":       &types.AttributeValueMemberN{Value: "12"},
+				"Language":     &types.AttributeValueMemberS{Value: "en"},
+				"Profile":      &types.AttributeValueMemberS{Value: GospelProfile},
+				"DeliveryHour": &types.AttributeValueMemberN{Value: "0"},
+			}}},
+			expected:      &Preferences{Language: "en", Profile: GospelProfile, DeliveryHour: 0},
+			errorExpected: false,
+		},
+		{
+			name: "default preferences",
+			dynamo: &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+				"ChatID": &types.AttributeValueMemberN{Value: "12"},
+			}}},
+			expected:      DefaultPreferences(),
+			errorExpected: false,
+		},
+		{
+			name:          "chat not suscribed",
+			dynamo:        &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{}},
+			expected:      nil,
+			errorExpected: false,
+		},
+		{
+			name:          "error getting preferences",
+			dynamo:        &MockDynamoDB{errGetItem: errors.New("error")},
+			expected:      nil,
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			actual, err := m.GetPreferences(context.TODO(), 12)
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestReadings(t *testing.T) {
+	magnificat := &archimadrid.Magnificat{
+		Date:         "2022-03-18",
+		Day:          "Viernes de la II semana de Cuaresma",
+		FirstLecture: &archimadrid.Gospel{Title: "Lectura del libro del Génesis"},
+		Psalm:        &archimadrid.Gospel{Title: "Sal 104"},
+		Gosp:         &archimadrid.Gospel{Title: "Lectura del santo evangelio según san Mateo"},
+	}
+
+	preview := *magnificat
+	preview.Preview = true
+
+	tests := []struct {
+		name       string
+		profile    string
+		magnificat *archimadrid.Magnificat
+		expected   *archimadrid.Magnificat
+	}{
+		{
+			name:       "full profile",
+			profile:    FullProfile,
+			magnificat: magnificat,
+			expected:   magnificat,
+		},
+		{
+			name:       "gospel profile",
+			profile:    GospelProfile,
+			magnificat: magnificat,
+			expected: &archimadrid.Magnificat{
+				Date: magnificat.Date,
+				Day:  magnificat.Day,
+				Gosp: magnificat.Gosp,
+			},
+		},
+		{
+			name:       "gospel profile preview",
+			profile:    GospelProfile,
+			magnificat: &preview,
+			expected: &archimadrid.Magnificat{
+				Date:    magnificat.Date,
+				Day:     magnificat.Day,
+				Gosp:    magnificat.Gosp,
+				Preview: true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			prefs := &Preferences{Profile: test.profile}
+			assert.Equal(tt, test.expected, prefs.Readings(test.magnificat))
+		})
+	}
+}
@@ -2,6 +2,11 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
@@ -9,21 +14,246 @@ import (
 )
 
 const (
-	DefaultQueueName = "magnifibot"
+	DefaultQueueName        = "magnifibot"
+	DefaultQueueConcurrency = 5
+
+	// maxBatchEntries and maxBatchBytes are the limits that SQS imposes
+	// on the messages sent in a single SendMessageBatch request
+	maxBatchEntries = 10
+	maxBatchBytes   = 256 * 1024
+
+	// maxBatchAttempts is the number of times an entry that failed
+	// because of an SQS error is sent before giving up
+	maxBatchAttempts = 3
+
+	// MaxQueueDelay is the longest time that SQS can hide a new message
+	MaxQueueDelay = 15 * time.Minute
+
+	// envelopeOverhead is the size of an envelope besides its kind and payload,
+	// including the longest chat IDs
+	envelopeOverhead = 64
 )
 
-// SendGospelToQueue sends the gospel for a particular ChatID to the SQS queue configured in
-// the controller. It returns the Message ID on success, and an error on failure.
-func (m *Magnifibot) SendMessageToQueue(ctx context.Context, chatID, message string) (string, error) {
+// SendMessageToQueue sends a message for a particular ChatID to the SQS queue configured in
+// the controller, wrapped in an envelope. On demand messages are the lectures requested by
+// a user. It returns the Message ID on success, and an error on failure.
+func (m *Magnifibot) SendMessageToQueue(ctx context.Context, chatID string, message *Message, onDemand bool) (string, error) {
+	body, err := message.Envelope(chatID, onDemand)
+	if err != nil {
+		return "", err
+	}
+	return m.sendBody(ctx, chatID, body)
+}
+
+// sendBody sends a message whose body is already an envelope to the SQS queue
+func (m *Magnifibot) sendBody(ctx context.Context, chatID, body string) (string, error) {
 	messageOutput, err := m.SQSSendMessageAPI.SendMessage(ctx, &sqs.SendMessageInput{
-		QueueUrl:    aws.String(m.Config.QueueURL),
-		MessageBody: aws.String(message),
-		MessageAttributes: map[string]types.MessageAttributeValue{
-			"chatID": {DataType: aws.String("Number"), StringValue: aws.String(chatID)},
-		},
+		QueueUrl:          aws.String(m.Config.QueueURL),
+		MessageBody:       aws.String(body),
+		MessageAttributes: chatAttributes(chatID),
 	})
 	if err != nil {
 		return "", err
 	}
 	return *messageOutput.MessageId, nil
 }
+
+// DeferEnvelope sends back to the queue an envelope that must not be delivered yet,
+// hidden until its NotBefore time or for MaxQueueDelay, whichever comes first.
+func (m *Magnifibot) DeferEnvelope(ctx context.Context, envelope *Envelope) error {
+	deferred := *envelope
+	delay := time.Until(time.Unix(deferred.NotBefore, 0))
+	if delay > MaxQueueDelay {
+		delay = MaxQueueDelay
+	} else {
+		deferred.NotBefore = 0
+	}
+
+	body, err := json.Marshal(deferred)
+	if err != nil {
+		return fmt.Errorf("error converting envelope into JSON: %w", err)
+	}
+	chatID := strconv.FormatInt(deferred.ChatID, 10)
+	_, err = m.SQSSendMessageAPI.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(m.Config.QueueURL),
+		MessageBody:       aws.String(string(body)),
+		MessageAttributes: chatAttributes(chatID),
+		DelaySeconds:      int32((delay + time.Second - 1) / time.Second),
+	})
+	if err != nil {
+		return fmt.Errorf("error deferring %s message for chat %s: %w", deferred.Kind, chatID, err)
+	}
+	return nil
+}
+
+// SendMessagesToQueue sends the same message for many ChatIDs to the SQS queue configured in
+// the controller, wrapped in an envelope for each one of them, and grouping them in batches
+// that are sent concurrently. Entries that fail because of an SQS error are retried. It
+// returns the error for each one of the ChatIDs that could not be enqueued, or an empty
+// map if all of them succeeded.
+func (m *Magnifibot) SendMessagesToQueue(ctx context.Context, chatIDs []string, message *Message) map[string]error {
+	return m.sendMessages(ctx, chatIDs, message, func(position int) time.Duration { return 0 })
+}
+
+// SendThrottledMessagesToQueue works like SendMessagesToQueue, but every batch is delayed
+// so that no more than rate messages are delivered per second. The ChatIDs are the ones
+// from the given position of a longer delivery, so that it can be enqueued in parts.
+// Messages that must wait longer than MaxQueueDelay carry the time at which they may be
+// delivered, and are sent back to the queue by the consumer until then.
+func (m *Magnifibot) SendThrottledMessagesToQueue(
+	ctx context.Context,
+	chatIDs []string,
+	message *Message,
+	first, rate int,
+) map[string]error {
+	return m.sendMessages(ctx, chatIDs, message, func(position int) time.Duration {
+		return time.Duration(first+position) * time.Second / time.Duration(rate)
+	})
+}
+
+// sendMessages sends the batches of messages concurrently, delaying each of them
+// by the delay of the position of its first ChatID
+func (m *Magnifibot) sendMessages(
+	ctx context.Context,
+	chatIDs []string,
+	message *Message,
+	delay func(position int) time.Duration,
+) map[string]error {
+	concurrency := m.Config.QueueConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultQueueConcurrency
+	}
+
+	failed := map[string]error{}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, concurrency)
+
+	position := 0
+	messageSize := len(message.Kind) + len(message.Payload) + envelopeOverhead
+	for _, batch := range batchChatIDs(chatIDs, messageSize) {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(b []string, d time.Duration) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			for chatID, err := range m.sendBatch(ctx, b, message, d) {
+				mu.Lock()
+				failed[chatID] = err
+				mu.Unlock()
+			}
+		}(batch, delay(position))
+		position += len(batch)
+	}
+	wg.Wait()
+
+	return failed
+}
+
+// sendBatch sends a single batch of messages, retrying the entries that failed
+// because of an SQS error, and returns the error for every ChatID that failed.
+// Delays longer than MaxQueueDelay are completed by the consumer.
+func (m *Magnifibot) sendBatch(ctx context.Context, chatIDs []string, message *Message, delay time.Duration) map[string]error {
+	notBefore := int64(0)
+	if delay > MaxQueueDelay {
+		notBefore = time.Now().Add(delay).Unix()
+		delay = MaxQueueDelay
+	}
+
+	failed := map[string]error{}
+	pending := []string{}
+	bodies := map[string]string{}
+	for _, chatID := range chatIDs {
+		body, err := message.envelope(chatID, false, notBefore)
+		if err != nil {
+			failed[chatID] = err
+			continue
+		}
+		bodies[chatID] = body
+		pending = append(pending, chatID)
+	}
+
+	for attempt := 1; attempt <= maxBatchAttempts && len(pending) > 0; attempt++ {
+		entries := make([]types.SendMessageBatchRequestEntry, len(pending))
+		for i, chatID := range pending {
+			entries[i] = types.SendMessageBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				MessageBody:       aws.String(bodies[chatID]),
+				MessageAttributes: chatAttributes(chatID),
+				DelaySeconds:      int32(delay / time.Second),
+			}
+		}
+
+		output, err := m.SQSSendMessageAPI.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(m.Config.QueueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			for _, chatID := range pending {
+				failed[chatID] = fmt.Errorf("error sending batch to queue: %w", err)
+			}
+			continue
+		}
+
+		retry := []string{}
+		for _, entry := range output.Failed {
+			i, err := strconv.Atoi(aws.ToString(entry.Id))
+			if err != nil || i < 0 || i >= len(pending) {
+				continue
+			}
+			chatID := pending[i]
+			failed[chatID] = fmt.Errorf(
+				"error sending message to queue: %s: %s",
+				aws.ToString(entry.Code),
+				aws.ToString(entry.Message),
+			)
+			if !entry.SenderFault {
+				retry = append(retry, chatID)
+			}
+		}
+		for _, entry := range output.Successful {
+			if i, err := strconv.Atoi(aws.ToString(entry.Id)); err == nil && i >= 0 && i < len(pending) {
+				delete(failed, pending[i])
+			}
+		}
+		pending = retry
+	}
+
+	return failed
+}
+
+// batchChatIDs splits the ChatIDs in groups that respect the limits of
+// SendMessageBatch for a message of the given size.
+func batchChatIDs(chatIDs []string, messageSize int) [][]string {
+	batches := [][]string{}
+	batch := []string{}
+	size := 0
+
+	for _, chatID := range chatIDs {
+		entrySize := messageSize + attributesSize(chatID)
+		if len(batch) == maxBatchEntries || (len(batch) > 0 && size+entrySize > maxBatchBytes) {
+			batches = append(batches, batch)
+			batch = []string{}
+			size = 0
+		}
+		batch = append(batch, chatID)
+		size += entrySize
+	}
+
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+func chatAttributes(chatID string) map[string]types.MessageAttributeValue {
+	return map[string]types.MessageAttributeValue{
+		"chatID": {DataType: aws.String("Number"), StringValue: aws.String(chatID)},
+	}
+}
+
+// attributesSize is the size that SQS accounts for the message attributes
+// of a message, that is, the sum of names, data types and values.
+func attributesSize(chatID string) int {
+	return len("chatID") + len("Number") + len(chatID)
+}
@@ -2,30 +2,87 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/stretchr/testify/assert"
 )
 
 type MockQueue struct {
-	output *sqs.SendMessageOutput
-	err    error
+	output   *sqs.SendMessageOutput
+	err      error
+	errBatch error
+	// failedChatIDs maps the chat IDs whose entries fail in a batch to
+	// whether the failure is the fault of the sender
+	failedChatIDs map[string]bool
+	mu            sync.Mutex
+	batches       [][]string
+	delays        []int32
+	bodies        []string
+	sent          []*sqs.SendMessageInput
 }
 
 func (m *MockQueue) SendMessage(ctx context.Context,
 	params *sqs.SendMessageInput,
 	optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, params)
 	return m.output, m.err
 }
 
+func (m *MockQueue) SendMessageBatch(ctx context.Context,
+	params *sqs.SendMessageBatchInput,
+	optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch := []string{}
+	output := &sqs.SendMessageBatchOutput{}
+	for _, entry := range params.Entries {
+		chatID := *entry.MessageAttributes["chatID"].StringValue
+		batch = append(batch, chatID)
+		m.bodies = append(m.bodies, *entry.MessageBody)
+		if senderFault, ok := m.failedChatIDs[chatID]; ok {
+			output.Failed = append(output.Failed, types.BatchResultErrorEntry{
+				Id:          entry.Id,
+				Code:        aws.String("code"),
+				Message:     aws.String("message"),
+				SenderFault: senderFault,
+			})
+			continue
+		}
+		output.Successful = append(output.Successful, types.SendMessageBatchResultEntry{
+			Id:        entry.Id,
+			MessageId: aws.String("id-" + chatID),
+		})
+	}
+	m.batches = append(m.batches, batch)
+	if len(params.Entries) > 0 {
+		m.delays = append(m.delays, params.Entries[0].DelaySeconds)
+	}
+
+	if m.errBatch != nil {
+		return nil, m.errBatch
+	}
+	return output, nil
+}
+
 func TestSendMessageToQueue(t *testing.T) {
 	tests := []struct {
-		name string
-		chatID,
-		message string
+		name          string
+		chatID        string
+		message       *Message
+		onDemand      bool
 		queue         SQSSendMessageAPI
 		expected      string
 		errorExpected bool
@@ -33,15 +90,24 @@ func TestSendMessageToQueue(t *testing.T) {
 		{
 			name:          "valid send message",
 			chatID:        "12",
-			message:       "message",
+			message:       textMessage("message"),
+			onDemand:      true,
 			queue:         &MockQueue{output: &sqs.SendMessageOutput{MessageId: aws.String("id")}, err: nil},
 			expected:      "id",
 			errorExpected: false,
 		},
+		{
+			name:          "invalid chat ID",
+			chatID:        "invalid",
+			message:       textMessage("message"),
+			queue:         &MockQueue{output: &sqs.SendMessageOutput{MessageId: aws.String("id")}, err: nil},
+			expected:      "",
+			errorExpected: true,
+		},
 		{
 			name:          "error sending message",
 			chatID:        "12",
-			message:       "message",
+			message:       textMessage("message"),
 			queue:         &MockQueue{err: errors.New("error")},
 			expected:      "",
 			errorExpected: true,
@@ -51,7 +117,7 @@ func TestSendMessageToQueue(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(tt *testing.T) {
 			m := NewMagnifibot(SetSQSClient(test.queue))
-			actual, err := m.SendMessageToQueue(context.TODO(), test.chatID, test.message)
+			actual, err := m.SendMessageToQueue(context.TODO(), test.chatID, test.message, test.onDemand)
 			if test.errorExpected {
 				assert.Error(tt, err)
 			} else {
@@ -61,3 +127,222 @@ func TestSendMessageToQueue(t *testing.T) {
 		})
 	}
 }
+
+func chatIDRange(n int) []string {
+	chatIDs := make([]string, n)
+	for i := range chatIDs {
+		chatIDs[i] = fmt.Sprintf("%d", i+1)
+	}
+	return chatIDs
+}
+
+func textMessage(text string) *Message {
+	return &Message{Kind: BroadcastKind, Payload: json.RawMessage(strconv.Quote(text))}
+}
+
+func TestSendMessagesToQueue(t *testing.T) {
+	tests := []struct {
+		name            string
+		chatIDs         []string
+		message         string
+		queue           *MockQueue
+		expectedFailed  []string
+		expectedBatches int
+	}{
+		{
+			name:            "single batch",
+			chatIDs:         chatIDRange(3),
+			message:         "message",
+			queue:           &MockQueue{},
+			expectedFailed:  []string{},
+			expectedBatches: 1,
+		},
+		{
+			name:            "several batches",
+			chatIDs:         chatIDRange(25),
+			message:         "message",
+			queue:           &MockQueue{},
+			expectedFailed:  []string{},
+			expectedBatches: 3,
+		},
+		{
+			name:            "batches limited by size",
+			chatIDs:         chatIDRange(4),
+			message:         strings.Repeat("a", 100*1024),
+			queue:           &MockQueue{},
+			expectedFailed:  []string{},
+			expectedBatches: 2,
+		},
+		{
+			name:            "no chat IDs",
+			chatIDs:         []string{},
+			message:         "message",
+			queue:           &MockQueue{},
+			expectedFailed:  []string{},
+			expectedBatches: 0,
+		},
+		{
+			name:            "invalid chat ID",
+			chatIDs:         []string{"1", "invalid"},
+			message:         "message",
+			queue:           &MockQueue{},
+			expectedFailed:  []string{"invalid"},
+			expectedBatches: 1,
+		},
+		{
+			name:            "sender fault entry is not retried",
+			chatIDs:         chatIDRange(3),
+			message:         "message",
+			queue:           &MockQueue{failedChatIDs: map[string]bool{"2": true}},
+			expectedFailed:  []string{"2"},
+			expectedBatches: 1,
+		},
+		{
+			name:            "server fault entry is retried",
+			chatIDs:         chatIDRange(3),
+			message:         "message",
+			queue:           &MockQueue{failedChatIDs: map[string]bool{"2": false}},
+			expectedFailed:  []string{"2"},
+			expectedBatches: maxBatchAttempts,
+		},
+		{
+			name:            "error sending batch",
+			chatIDs:         chatIDRange(3),
+			message:         "message",
+			queue:           &MockQueue{errBatch: errors.New("error")},
+			expectedFailed:  []string{"1", "2", "3"},
+			expectedBatches: maxBatchAttempts,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetSQSClient(test.queue))
+			failed := m.SendMessagesToQueue(context.TODO(), test.chatIDs, textMessage(test.message))
+			actual := []string{}
+			for chatID, err := range failed {
+				assert.Error(tt, err)
+				actual = append(actual, chatID)
+			}
+			assert.ElementsMatch(tt, test.expectedFailed, actual)
+			assert.Len(tt, test.queue.batches, test.expectedBatches)
+			for _, batch := range test.queue.batches {
+				assert.LessOrEqual(tt, len(batch), maxBatchEntries)
+			}
+		})
+	}
+}
+
+func TestSendThrottledMessagesToQueue(t *testing.T) {
+	tests := []struct {
+		name              string
+		chatIDs           []string
+		first             int
+		rate              int
+		expectedDelays    []int32
+		expectedNotBefore time.Duration
+	}{
+		{
+			name:           "first batches",
+			chatIDs:        chatIDRange(25),
+			first:          0,
+			rate:           10,
+			expectedDelays: []int32{0, 1, 2},
+		},
+		{
+			name:           "later batches",
+			chatIDs:        chatIDRange(25),
+			first:          40,
+			rate:           20,
+			expectedDelays: []int32{2, 2, 3},
+		},
+		{
+			name:              "longer than the queue delay",
+			chatIDs:           chatIDRange(3),
+			first:             3600 * 20,
+			rate:              20,
+			expectedDelays:    []int32{int32(MaxQueueDelay.Seconds())},
+			expectedNotBefore: time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			queue := &MockQueue{}
+			m := NewMagnifibot(SetSQSClient(queue), SetConfig(&MagnifibotConfig{QueueConcurrency: 1}))
+			failed := m.SendThrottledMessagesToQueue(context.TODO(), test.chatIDs, textMessage("message"), test.first, test.rate)
+			assert.Empty(tt, failed)
+			assert.Equal(tt, test.expectedDelays, queue.delays)
+			for _, body := range queue.bodies {
+				envelope, err := ParseEnvelope(body, "")
+				assert.NoError(tt, err)
+				if test.expectedNotBefore == 0 {
+					assert.Zero(tt, envelope.NotBefore)
+					continue
+				}
+				assert.InDelta(tt, time.Now().Add(test.expectedNotBefore).Unix(), envelope.NotBefore, 5)
+			}
+		})
+	}
+}
+
+func TestDeferEnvelope(t *testing.T) {
+	tests := []struct {
+		name              string
+		notBefore         time.Duration
+		queue             *MockQueue
+		expectedDelay     int32
+		expectedNotBefore bool
+		errorExpected     bool
+	}{
+		{
+			name:              "longer than the queue delay",
+			notBefore:         time.Hour,
+			queue:             &MockQueue{output: &sqs.SendMessageOutput{MessageId: aws.String("id")}},
+			expectedDelay:     int32(MaxQueueDelay.Seconds()),
+			expectedNotBefore: true,
+			errorExpected:     false,
+		},
+		{
+			name:              "shorter than the queue delay",
+			notBefore:         10 * time.Minute,
+			queue:             &MockQueue{output: &sqs.SendMessageOutput{MessageId: aws.String("id")}},
+			expectedDelay:     int32((10 * time.Minute).Seconds()),
+			expectedNotBefore: false,
+			errorExpected:     false,
+		},
+		{
+			name:              "error sending message",
+			notBefore:         time.Hour,
+			queue:             &MockQueue{err: errors.New("error")},
+			expectedDelay:     int32(MaxQueueDelay.Seconds()),
+			expectedNotBefore: true,
+			errorExpected:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetSQSClient(test.queue))
+			envelope := &Envelope{
+				Kind:      BroadcastKind,
+				Version:   EnvelopeVersion,
+				ChatID:    1,
+				NotBefore: time.Now().Add(test.notBefore).Unix(),
+				Payload:   json.RawMessage(`"message"`),
+			}
+			err := m.DeferEnvelope(context.TODO(), envelope)
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Len(tt, test.queue.sent, 1)
+			sent := test.queue.sent[0]
+			assert.InDelta(tt, test.expectedDelay, sent.DelaySeconds, 1)
+			deferred, err := ParseEnvelope(aws.ToString(sent.MessageBody), "")
+			assert.NoError(tt, err)
+			assert.Equal(tt, test.expectedNotBefore, deferred.NotBefore != 0)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Schedule are the days in which a chat receives the Gospel. Chats without
+// a schedule receive it every day.
+type Schedule struct {
+	// Weekdays are the days of the week in which the chat receives the Gospel
+	Weekdays []time.Weekday
+
+	// Feasts makes the chat receive the Gospel on solemnities and feasts,
+	// whatever the day of the week
+	Feasts bool
+}
+
+// EveryDay tells whether the schedule includes all the days of the week
+func (s *Schedule) EveryDay() bool {
+	return s == nil || len(s.weekdays()) == 7
+}
+
+// Includes tells whether the chat receives the Gospel on a day of the
+// week, given whether the day is a solemnity or a feast
+func (s *Schedule) Includes(weekday time.Weekday, feast bool) bool {
+	if s.EveryDay() || (feast && s.Feasts) {
+		return true
+	}
+	return strings.ContainsRune(s.encode(), rune('0'+weekday))
+}
+
+// weekdays returns the valid days of the schedule, sorted and without duplicates
+func (s *Schedule) weekdays() []time.Weekday {
+	seen := map[time.Weekday]bool{}
+	weekdays := []time.Weekday{}
+	for _, weekday := range s.Weekdays {
+		if weekday < time.Sunday || weekday > time.Saturday || seen[weekday] {
+			continue
+		}
+		seen[weekday] = true
+		weekdays = append(weekdays, weekday)
+	}
+	sort.Slice(weekdays, func(i, j int) bool { return weekdays[i] < weekdays[j] })
+	return weekdays
+}
+
+// encode returns the days of the week as a string of digits, like "06" for
+// Sundays and Saturdays, which DynamoDB can filter with the contains function
+func (s *Schedule) encode() string {
+	var b strings.Builder
+	for _, weekday := range s.weekdays() {
+		b.WriteString(strconv.Itoa(int(weekday)))
+	}
+	return b.String()
+}
+
+// validate checks the schedule that a chat is about to store
+func (s *Schedule) validate(chatID int64) error {
+	if !s.EveryDay() && len(s.weekdays()) == 0 && !s.Feasts {
+		return fmt.Errorf("error setting schedule of chat %d: no days chosen", chatID)
+	}
+	return nil
+}
+
+// decodeWeekdays returns the days of the week encoded as a string of digits
+func decodeWeekdays(encoded string) []time.Weekday {
+	weekdays := []time.Weekday{}
+	for _, digit := range encoded {
+		weekdays = append(weekdays, time.Weekday(digit-'0'))
+	}
+	return weekdays
+}
+
+// scheduleFromItem reads the schedule stored in an item of the User table,
+// returning nil if the chat receives the Gospel every day
+func scheduleFromItem(item map[string]types.AttributeValue) *Schedule {
+	weekdays, ok := item["Weekdays"].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil
+	}
+
+	schedule := &Schedule{Weekdays: decodeWeekdays(weekdays.Value)}
+	if feasts, ok := item["Feasts"].(*types.AttributeValueMemberBOOL); ok {
+		schedule.Feasts = feasts.Value
+	}
+	return schedule
+}
+
+// SetSchedule stores the days in which a subscribed chat receives the Gospel. A nil
+// schedule, or one including every day of the week, removes any restriction.
+func (s *DynamoDBSubscriberStore) SetSchedule(ctx context.Context, chatID int64, schedule *Schedule) error {
+	if err := schedule.validate(chatID); err != nil {
+		return err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(ChatID)"),
+		UpdateExpression:    aws.String("SET UpdatedAt = :now REMOVE Weekdays, Feasts"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+	}
+	if !schedule.EveryDay() {
+		input.UpdateExpression = aws.String("SET UpdatedAt = :now, Weekdays = :weekdays, Feasts = :feasts")
+		input.ExpressionAttributeValues[":weekdays"] = &types.AttributeValueMemberS{Value: schedule.encode()}
+		input.ExpressionAttributeValues[":feasts"] = &types.AttributeValueMemberBOOL{Value: schedule.Feasts}
+	}
+
+	if _, err := s.UpdateItem(ctx, input); err != nil {
+		return fmt.Errorf("error setting schedule of chat %d: %w", chatID, err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleIncludes(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule *Schedule
+		weekday  time.Weekday
+		feast    bool
+		expected bool
+	}{
+		{
+			name:     "every day",
+			schedule: nil,
+			weekday:  time.Monday,
+			expected: true,
+		},
+		{
+			name:     "sunday",
+			schedule: &Schedule{Weekdays: []time.Weekday{time.Sunday}},
+			weekday:  time.Sunday,
+			expected: true,
+		},
+		{
+			name:     "weekday out of the schedule",
+			schedule: &Schedule{Weekdays: []time.Weekday{time.Sunday}},
+			weekday:  time.Monday,
+			expected: false,
+		},
+		{
+			name:     "feast out of the schedule",
+			schedule: &Schedule{Weekdays: []time.Weekday{time.Sunday}, Feasts: true},
+			weekday:  time.Monday,
+			feast:    true,
+			expected: true,
+		},
+		{
+			name:     "feast not chosen",
+			schedule: &Schedule{Weekdays: []time.Weekday{time.Sunday}},
+			weekday:  time.Monday,
+			feast:    true,
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			assert.Equal(tt, test.expected, test.schedule.Includes(test.weekday, test.feast))
+		})
+	}
+}
+
+func TestScheduleFromItem(t *testing.T) {
+	assert.Nil(t, scheduleFromItem(map[string]types.AttributeValue{}))
+	assert.Equal(
+		t,
+		&Schedule{Weekdays: []time.Weekday{time.Sunday, time.Saturday}, Feasts: true},
+		scheduleFromItem(map[string]types.AttributeValue{
+			"Weekdays": &types.AttributeValueMemberS{Value: (&Schedule{Weekdays: []time.Weekday{time.Saturday, time.Sunday, time.Sunday}}).encode()},
+			"Feasts":   &types.AttributeValueMemberBOOL{Value: true},
+		}),
+	)
+}
+
+func TestSetSchedule(t *testing.T) {
+	tests := []struct {
+		name          string
+		schedule      *Schedule
+		dynamo        DynamoDBInterface
+		errorExpected bool
+	}{
+		{
+			name:          "sundays and feasts",
+			schedule:      &Schedule{Weekdays: []time.Weekday{time.Sunday}, Feasts: true},
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "only feasts",
+			schedule:      &Schedule{Feasts: true},
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "every day",
+			schedule:      nil,
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "no days",
+			schedule:      &Schedule{},
+			dynamo:        &MockDynamoDB{},
+			errorExpected: true,
+		},
+		{
+			name:          "chat not suscribed",
+			schedule:      &Schedule{Weekdays: []time.Weekday{time.Sunday}},
+			dynamo:        &MockDynamoDB{errUpdateItem: &types.ConditionalCheckFailedException{}},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.SetSchedule(context.TODO(), 12, test.schedule)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
@@ -0,0 +1,11 @@
+// Package sqlstore registers the drivers of the databases supported by
+// controller.SQLSubscriberStore. They are kept out of package controller so that
+// the functions deployed to AWS Lambda, which keep the subscribers in DynamoDB,
+// are not built with them; only the builds with the sqlstore tag import it.
+package sqlstore
+
+import (
+	// The drivers of the databases supported by SQLSubscriberStore
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
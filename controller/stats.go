@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// UnknownKind is the kind of the chats subscribed before it was recorded
+const UnknownKind = "unknown"
+
+// SubscriptionStats are the counts of the subscriptions of the User table
+type SubscriptionStats struct {
+	// Active counts the active subscriptions by the type of chat
+	Active map[string]int
+
+	// Inactive counts the subscriptions of the chats that can no longer be reached
+	Inactive int
+
+	// Paused counts the active subscriptions whose delivery is paused
+	Paused int
+}
+
+// Total returns the number of active subscriptions
+func (s *SubscriptionStats) Total() int {
+	total := 0
+	for _, count := range s.Active {
+		total += count
+	}
+	return total
+}
+
+// GetSubscriptionStats counts the subscriptions of the User table
+func (s *DynamoDBSubscriberStore) GetSubscriptionStats(ctx context.Context) (*SubscriptionStats, error) {
+	paginator := dynamodb.NewScanPaginator(s, &dynamodb.ScanInput{
+		TableName:            aws.String(s.UserTable),
+		ProjectionExpression: aws.String("Kind, Active, PausedUntil"),
+	})
+
+	now := time.Now().Unix()
+	stats := &SubscriptionStats{Active: map[string]int{}}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning dynamodb table: %w", err)
+		}
+
+		for _, item := range page.Items {
+			if active, ok := item["Active"].(*types.AttributeValueMemberBOOL); ok && !active.Value {
+				stats.Inactive++
+				continue
+			}
+			kind := getString(item, "Kind")
+			if kind == "" {
+				kind = UnknownKind
+			}
+			stats.Active[kind]++
+			if pausedUntil, err := getNumber(item, "PausedUntil"); err == nil && pausedUntil > now {
+				stats.Paused++
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSubscriptionStats(t *testing.T) {
+	tomorrow := fmt.Sprintf("%d", time.Now().Add(24*time.Hour).Unix())
+	yesterday := fmt.Sprintf("%d", time.Now().Add(-24*time.Hour).Unix())
+
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		expected      *SubscriptionStats
+		errorExpected bool
+	}{
+		{
+			name: "several subscriptions",
+			dynamo: &MockDynamoDB{scanOutput: &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{
+				{"Kind": &types.AttributeValueMemberS{Value: "private"}, "Active": &types.AttributeValueMemberBOOL{Value: true}},
+				{"Kind": &types.AttributeValueMemberS{Value: "private"}, "PausedUntil": &types.AttributeValueMemberN{Value: tomorrow}},
+				{"Kind": &types.AttributeValueMemberS{Value: "group"}, "PausedUntil": &types.AttributeValueMemberN{Value: yesterday}},
+				{"Kind": &types.AttributeValueMemberS{Value: "channel"}, "Active": &types.AttributeValueMemberBOOL{Value: false}},
+				{},
+			}}},
+			expected: &SubscriptionStats{
+				Active:   map[string]int{"private": 2, "group": 1, UnknownKind: 1},
+				Inactive: 1,
+				Paused:   1,
+			},
+			errorExpected: false,
+		},
+		{
+			name:          "empty table",
+			dynamo:        &MockDynamoDB{scanOutput: &dynamodb.ScanOutput{}},
+			expected:      &SubscriptionStats{Active: map[string]int{}},
+			errorExpected: false,
+		},
+		{
+			name:          "scan error",
+			dynamo:        &MockDynamoDB{errScan: errors.New("error")},
+			expected:      nil,
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			actual, err := m.GetSubscriptionStats(context.TODO())
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestSubscriptionStatsTotal(t *testing.T) {
+	stats := &SubscriptionStats{Active: map[string]int{"private": 2, "group": 1}, Inactive: 4}
+	assert.Equal(t, 3, stats.Total())
+}
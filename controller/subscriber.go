@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The kinds of SubscriberStore that can be chosen with OpenSubscriberStore
+const (
+	DynamoDBStore = "dynamodb"
+	SQLiteStore   = "sqlite"
+	PostgresStore = "postgres"
+	MemoryStore   = "memory"
+
+	DefaultSubscriberStore = DynamoDBStore
+)
+
+// ErrNotSubscribed is returned when changing the subscription of a chat that is not subscribed
+var ErrNotSubscribed = errors.New("chat not subscribed")
+
+// errChatFound stops the scan of HasChats at the first page of chats
+var errChatFound = errors.New("chat found")
+
+// SubscriberStore keeps the subscriptions of the chats along with their settings.
+// The Magnifibot controller embeds one, which by default keeps them in the User
+// table of DynamoDB, so that the bot can also be run with other databases.
+type SubscriberStore interface {
+	Suscribe(ctx context.Context, sub *Subscription) error
+	GetSubscription(ctx context.Context, chatID int64) (*Subscription, error)
+	Unsuscribe(ctx context.Context, chatID int64) error
+	Deactivate(ctx context.Context, chatID int64, reason string, date int64) error
+	GetInactiveSubscriptions(ctx context.Context) ([]InactiveSubscription, error)
+	GetSubscriptionStats(ctx context.Context) (*SubscriptionStats, error)
+	ScanChatIDs(ctx context.Context, filter ChatFilter, fn func(chatIDs []string) error) error
+	SetPreferences(ctx context.Context, chatID int64, prefs *Preferences) error
+	GetPreferences(ctx context.Context, chatID int64) (*Preferences, error)
+	SetSchedule(ctx context.Context, chatID int64, schedule *Schedule) error
+	SetPreview(ctx context.Context, chatID int64, enabled bool) error
+	Pause(ctx context.Context, chatID int64, until time.Time) error
+	Resume(ctx context.Context, chatID int64) error
+	RecordDelivery(ctx context.Context, chatID int64, date string) error
+	SetPolicy(ctx context.Context, chatID int64, policy string) error
+	GetPolicy(ctx context.Context, chatID int64) (string, error)
+	MigrateSubscriptions(ctx context.Context) (int, error)
+}
+
+// DynamoDBSubscriberStore is the SubscriberStore that keeps the subscriptions
+// in the User table of DynamoDB
+type DynamoDBSubscriberStore struct {
+	DynamoDBInterface
+
+	// UserTable is the name of the User table
+	UserTable string
+
+	// ScanSegments is the number of segments in which the User table
+	// is split to be scanned in parallel
+	ScanSegments int
+}
+
+// NewDynamoDBSubscriberStore returns a SubscriberStore that keeps the subscriptions in a DynamoDB table
+func NewDynamoDBSubscriberStore(client DynamoDBInterface, table string, segments int) *DynamoDBSubscriberStore {
+	return &DynamoDBSubscriberStore{
+		DynamoDBInterface: client,
+		UserTable:         table,
+		ScanSegments:      segments,
+	}
+}
+
+// OpenSubscriberStore returns the SubscriberStore of the given kind, connected to the
+// database of the data source name, like a file for SQLite or a URL for PostgreSQL.
+// It returns nil for DynamoDBStore, so that Magnifibot uses the User table of its
+// DynamoDB client, which keeps being used for the rest of its tables.
+func OpenSubscriberStore(ctx context.Context, kind, dsn string) (SubscriberStore, error) {
+	switch kind {
+	case "", DynamoDBStore:
+		return nil, nil
+	case MemoryStore:
+		return NewMemorySubscriberStore(), nil
+	case SQLiteStore, PostgresStore:
+		store, err := OpenSQLSubscriberStore(ctx, kind, dsn)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("error opening subscriber store: unknown kind %s", kind)
+}
+
+// HasChats tells whether any active chat matches the filter, stopping the scan
+// as soon as one is found
+func (m *Magnifibot) HasChats(ctx context.Context, filter ChatFilter) (bool, error) {
+	err := m.ScanChatIDs(ctx, filter, func(chatIDs []string) error {
+		return errChatFound
+	})
+	if errors.Is(err, errChatFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error looking for chats: %w", err)
+	}
+	return false, nil
+}
@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// subscriberPageSize is the number of chat IDs passed at once to the function
+// called by ScanChatIDs in the stores that are not DynamoDB
+const subscriberPageSize = 1000
+
+// memorySubscriber is a subscription kept in memory, along with the settings
+// that are not part of Subscription
+type memorySubscriber struct {
+	sub    Subscription
+	policy string
+	reason string
+	since  int64
+}
+
+// MemorySubscriberStore is a SubscriberStore that keeps the subscriptions in memory,
+// for tests and for running the bot in a single process
+type MemorySubscriberStore struct {
+	mu          sync.Mutex
+	subscribers map[int64]*memorySubscriber
+}
+
+// NewMemorySubscriberStore returns an empty SubscriberStore that keeps the subscriptions in memory
+func NewMemorySubscriberStore() *MemorySubscriberStore {
+	return &MemorySubscriberStore{subscribers: map[int64]*memorySubscriber{}}
+}
+
+// Suscribe stores the subscription of a chat, reactivating it if it had been deactivated
+func (s *MemorySubscriberStore) Suscribe(ctx context.Context, sub *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	if existing, ok := s.subscribers[sub.ChatID]; ok {
+		if existing.sub.Active {
+			return fmt.Errorf("error when suscribing chat %d: %w", sub.ChatID, ErrAlreadySubscribed)
+		}
+		existing.sub.Active = true
+		existing.sub.UpdatedAt = now
+		existing.reason = ""
+		existing.since = 0
+		return nil
+	}
+
+	prefs := DefaultPreferences()
+	if sub.Preferences != nil {
+		*prefs = *sub.Preferences
+	}
+	createdAt := sub.CreatedAt
+	if createdAt == 0 {
+		createdAt = now
+	}
+	s.subscribers[sub.ChatID] = &memorySubscriber{
+		sub: Subscription{
+			ChatID:      sub.ChatID,
+			UserID:      sub.UserID,
+			Kind:        sub.Kind,
+			Title:       sub.Title,
+			Username:    sub.Username,
+			Preferences: prefs,
+			Active:      true,
+			CreatedAt:   createdAt,
+			UpdatedAt:   now,
+			Version:     SubscriptionVersion,
+		},
+	}
+	return nil
+}
+
+// GetSubscription returns a copy of the subscription of a chat, or nil if it is not subscribed
+func (s *MemorySubscriberStore) GetSubscription(ctx context.Context, chatID int64) (*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriber, ok := s.subscribers[chatID]
+	if !ok {
+		return nil, nil
+	}
+	sub := subscriber.sub
+	prefs := *sub.Preferences
+	sub.Preferences = &prefs
+	if sub.Schedule != nil {
+		sub.Schedule = &Schedule{Weekdays: sub.Schedule.weekdays(), Feasts: sub.Schedule.Feasts}
+	}
+	return &sub, nil
+}
+
+// Unsuscribe deletes the subscription of a chat
+func (s *MemorySubscriberStore) Unsuscribe(ctx context.Context, chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, chatID)
+	return nil
+}
+
+// Deactivate marks the subscription of a chat as inactive. Chats that are not subscribed are ignored.
+func (s *MemorySubscriberStore) Deactivate(ctx context.Context, chatID int64, reason string, date int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if subscriber, ok := s.subscribers[chatID]; ok {
+		subscriber.sub.Active = false
+		subscriber.reason = reason
+		subscriber.since = date
+	}
+	return nil
+}
+
+// GetInactiveSubscriptions returns the subscriptions that have been deactivated, sorted by chat
+func (s *MemorySubscriberStore) GetInactiveSubscriptions(ctx context.Context) ([]InactiveSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriptions := []InactiveSubscription{}
+	for _, subscriber := range s.subscribers {
+		if subscriber.sub.Active {
+			continue
+		}
+		subscriptions = append(subscriptions, InactiveSubscription{
+			ChatID: subscriber.sub.ChatID,
+			Kind:   subscriber.sub.Kind,
+			Reason: subscriber.reason,
+			Since:  subscriber.since,
+		})
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].ChatID < subscriptions[j].ChatID })
+	return subscriptions, nil
+}
+
+// GetSubscriptionStats counts the subscriptions
+func (s *MemorySubscriberStore) GetSubscriptionStats(ctx context.Context) (*SubscriptionStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	stats := &SubscriptionStats{Active: map[string]int{}}
+	for _, subscriber := range s.subscribers {
+		if !subscriber.sub.Active {
+			stats.Inactive++
+			continue
+		}
+		kind := subscriber.sub.Kind
+		if kind == "" {
+			kind = UnknownKind
+		}
+		stats.Active[kind]++
+		if subscriber.sub.PausedUntil > now {
+			stats.Paused++
+		}
+	}
+	return stats, nil
+}
+
+// ScanChatIDs calls fn with the chat IDs of the active subscriptions matching the
+// filter, sorted and in pages of subscriberPageSize. It stops at the first error returned by fn.
+func (s *MemorySubscriberStore) ScanChatIDs(ctx context.Context, filter ChatFilter, fn func(chatIDs []string) error) error {
+	s.mu.Lock()
+	ids := []int64{}
+	for chatID, subscriber := range s.subscribers {
+		if filter.matches(&subscriber.sub) {
+			ids = append(ids, chatID)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for start := 0; start < len(ids); start += subscriberPageSize {
+		end := start + subscriberPageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chatIDs := make([]string, 0, end-start)
+		for _, id := range ids[start:end] {
+			chatIDs = append(chatIDs, strconv.FormatInt(id, 10))
+		}
+		if err := fn(chatIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches tells whether a subscription is active and matches the filter
+func (f ChatFilter) matches(sub *Subscription) bool {
+	if !sub.Active {
+		return false
+	}
+	if f.DeliveryHour != nil && sub.Preferences.DeliveryHour != *f.DeliveryHour {
+		return false
+	}
+	if f.NotPausedAt != nil && sub.PausedUntil > f.NotPausedAt.Unix() {
+		return false
+	}
+	if f.Preview && !sub.Preview {
+		return false
+	}
+	if f.Weekday != nil && !sub.Schedule.Includes(*f.Weekday, f.Feast) {
+		return false
+	}
+	if f.Kind != "" && sub.Kind != f.Kind {
+		return false
+	}
+	if f.Language != "" && sub.Preferences.Language != f.Language {
+		return false
+	}
+	return true
+}
+
+// SetPreferences stores the preferences of a subscribed chat
+func (s *MemorySubscriberStore) SetPreferences(ctx context.Context, chatID int64, prefs *Preferences) error {
+	if err := prefs.validate(chatID); err != nil {
+		return err
+	}
+	return s.update(chatID, "error setting preferences of chat %d: %w", func(subscriber *memorySubscriber) {
+		p := *prefs
+		subscriber.sub.Preferences = &p
+	})
+}
+
+// GetPreferences returns the preferences of a chat, or nil if it is not subscribed
+func (s *MemorySubscriberStore) GetPreferences(ctx context.Context, chatID int64) (*Preferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriber, ok := s.subscribers[chatID]
+	if !ok {
+		return nil, nil
+	}
+	prefs := *subscriber.sub.Preferences
+	return &prefs, nil
+}
+
+// SetSchedule stores the days in which a subscribed chat receives the Gospel
+func (s *MemorySubscriberStore) SetSchedule(ctx context.Context, chatID int64, schedule *Schedule) error {
+	if err := schedule.validate(chatID); err != nil {
+		return err
+	}
+	return s.update(chatID, "error setting schedule of chat %d: %w", func(subscriber *memorySubscriber) {
+		subscriber.sub.Schedule = nil
+		if !schedule.EveryDay() {
+			subscriber.sub.Schedule = &Schedule{Weekdays: schedule.weekdays(), Feasts: schedule.Feasts}
+		}
+	})
+}
+
+// SetPreview chooses whether a subscribed chat gets the readings of the next Sunday in advance
+func (s *MemorySubscriberStore) SetPreview(ctx context.Context, chatID int64, enabled bool) error {
+	return s.update(chatID, "error setting preview of chat %d: %w", func(subscriber *memorySubscriber) {
+		subscriber.sub.Preview = enabled
+	})
+}
+
+// Pause stops the daily delivery to a subscribed chat until the given time
+func (s *MemorySubscriberStore) Pause(ctx context.Context, chatID int64, until time.Time) error {
+	return s.update(chatID, "error pausing chat %d: %w", func(subscriber *memorySubscriber) {
+		subscriber.sub.PausedUntil = until.Unix()
+	})
+}
+
+// Resume restarts the daily delivery to a chat. Chats that are not subscribed are ignored.
+func (s *MemorySubscriberStore) Resume(ctx context.Context, chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if subscriber, ok := s.subscribers[chatID]; ok {
+		subscriber.sub.PausedUntil = 0
+		subscriber.sub.UpdatedAt = time.Now().Unix()
+	}
+	return nil
+}
+
+// RecordDelivery records the date of the lectures delivered to a chat, unless it
+// already got the lectures of a later day. Chats that are not subscribed are ignored.
+func (s *MemorySubscriberStore) RecordDelivery(ctx context.Context, chatID int64, date string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if subscriber, ok := s.subscribers[chatID]; ok && subscriber.sub.LastDelivery < date {
+		subscriber.sub.LastDelivery = date
+		subscriber.sub.LastDeliveredAt = time.Now().Unix()
+	}
+	return nil
+}
+
+// SetPolicy sets who may change the subscription of a subscribed group or channel
+func (s *MemorySubscriberStore) SetPolicy(ctx context.Context, chatID int64, policy string) error {
+	if err := validatePolicy(chatID, policy); err != nil {
+		return err
+	}
+	return s.update(chatID, "error setting policy of chat %d: %w", func(subscriber *memorySubscriber) {
+		subscriber.policy = policy
+	})
+}
+
+// GetPolicy returns who may change the subscription of a chat, or DefaultPolicy
+func (s *MemorySubscriberStore) GetPolicy(ctx context.Context, chatID int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if subscriber, ok := s.subscribers[chatID]; ok && subscriber.policy != "" {
+		return subscriber.policy, nil
+	}
+	return DefaultPolicy, nil
+}
+
+// MigrateSubscriptions does nothing, since the subscriptions in memory are
+// always of the current version
+func (s *MemorySubscriberStore) MigrateSubscriptions(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// update changes the subscription of a chat, returning ErrNotSubscribed wrapped
+// with the given format if the chat is not subscribed
+func (s *MemorySubscriberStore) update(chatID int64, format string, fn func(subscriber *memorySubscriber)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriber, ok := s.subscribers[chatID]
+	if !ok {
+		return fmt.Errorf(format, chatID, ErrNotSubscribed)
+	}
+	fn(subscriber)
+	subscriber.sub.UpdatedAt = time.Now().Unix()
+	return nil
+}
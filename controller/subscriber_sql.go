@@ -0,0 +1,478 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlDrivers are the names of the drivers of database/sql for each kind of store.
+// They are registered by importing package sqlstore.
+var sqlDrivers = map[string]string{
+	SQLiteStore:   "sqlite",
+	PostgresStore: "postgres",
+}
+
+// sqlSchema creates the table of the subscriptions. The columns are the
+// attributes of the items of the User table, and the types are understood
+// by both SQLite and PostgreSQL.
+const sqlSchema = `CREATE TABLE IF NOT EXISTS subscriptions (
+	chat_id BIGINT PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	kind TEXT NOT NULL,
+	title TEXT NOT NULL,
+	username TEXT NOT NULL,
+	language TEXT NOT NULL,
+	profile TEXT NOT NULL,
+	delivery_hour INTEGER NOT NULL,
+	active BOOLEAN NOT NULL,
+	inactive_reason TEXT NOT NULL DEFAULT '',
+	inactive_since BIGINT NOT NULL DEFAULT 0,
+	weekdays TEXT,
+	feasts BOOLEAN NOT NULL DEFAULT FALSE,
+	preview BOOLEAN NOT NULL DEFAULT FALSE,
+	paused_until BIGINT NOT NULL DEFAULT 0,
+	policy TEXT NOT NULL DEFAULT '',
+	last_delivery TEXT NOT NULL DEFAULT '',
+	last_delivered_at BIGINT NOT NULL DEFAULT 0,
+	created_at BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL,
+	version INTEGER NOT NULL
+)`
+
+// SQLSubscriberStore is a SubscriberStore that keeps the subscriptions in the
+// subscriptions table of a SQLite or PostgreSQL database
+type SQLSubscriberStore struct {
+	db   *sql.DB
+	kind string
+}
+
+// OpenSQLSubscriberStore connects to the database of the data source name, which is a
+// file for SQLiteStore and a connection string for PostgresStore, and creates the
+// subscriptions table if it does not exist
+func OpenSQLSubscriberStore(ctx context.Context, kind, dsn string) (*SQLSubscriberStore, error) {
+	driver, ok := sqlDrivers[kind]
+	if !ok {
+		return nil, fmt.Errorf("error opening subscriber store: unknown database %s", kind)
+	}
+	if !driverRegistered(driver) {
+		return nil, fmt.Errorf("error opening subscriber store: the %s driver is not registered, build with the sqlstore tag", kind)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s database: %w", kind, err)
+	}
+	// SQLite does not allow concurrent writes, and every connection to an
+	// in-memory database would get a different one
+	if kind == SQLiteStore {
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &SQLSubscriberStore{db: db, kind: kind}
+	if _, err := s.db.ExecContext(ctx, sqlSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating subscriptions table in %s database: %w", kind, err)
+	}
+	return s, nil
+}
+
+// Close closes the connections to the database
+func (s *SQLSubscriberStore) Close() error {
+	return s.db.Close()
+}
+
+// Suscribe stores the subscription of a chat, reactivating it if it had been deactivated
+func (s *SQLSubscriberStore) Suscribe(ctx context.Context, sub *Subscription) error {
+	prefs := sub.Preferences
+	if prefs == nil {
+		prefs = DefaultPreferences()
+	}
+	now := time.Now().Unix()
+	createdAt := sub.CreatedAt
+	if createdAt == 0 {
+		createdAt = now
+	}
+
+	inserted, err := s.exec(ctx, "INSERT INTO subscriptions "+
+		"(chat_id, user_id, kind, title, username, language, profile, delivery_hour, active, created_at, updated_at, version) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (chat_id) DO NOTHING",
+		sub.ChatID, sub.UserID, sub.Kind, sub.Title, sub.Username, prefs.Language, prefs.Profile,
+		prefs.DeliveryHour, true, createdAt, now, SubscriptionVersion,
+	)
+	if err != nil {
+		return fmt.Errorf("error when suscribing user %d: %w", sub.UserID, err)
+	}
+	if inserted > 0 {
+		return nil
+	}
+
+	reactivated, err := s.exec(ctx, "UPDATE subscriptions SET active = ?, inactive_reason = '', inactive_since = 0, "+
+		"updated_at = ? WHERE chat_id = ? AND active = ?", true, now, sub.ChatID, false)
+	if err != nil {
+		return fmt.Errorf("error when reactivating chat %d: %w", sub.ChatID, err)
+	}
+	if reactivated == 0 {
+		return fmt.Errorf("error when suscribing chat %d: %w", sub.ChatID, ErrAlreadySubscribed)
+	}
+	return nil
+}
+
+// GetSubscription returns the subscription of a chat, or nil if it is not subscribed
+func (s *SQLSubscriberStore) GetSubscription(ctx context.Context, chatID int64) (*Subscription, error) {
+	sub := &Subscription{ChatID: chatID, Preferences: &Preferences{}}
+	var weekdays sql.NullString
+	var feasts bool
+	err := s.queryRow(ctx, "SELECT user_id, kind, title, username, language, profile, delivery_hour, active, "+
+		"weekdays, feasts, preview, paused_until, last_delivery, last_delivered_at, created_at, updated_at, version "+
+		"FROM subscriptions WHERE chat_id = ?", chatID).Scan(
+		&sub.UserID, &sub.Kind, &sub.Title, &sub.Username,
+		&sub.Preferences.Language, &sub.Preferences.Profile, &sub.Preferences.DeliveryHour, &sub.Active,
+		&weekdays, &feasts, &sub.Preview, &sub.PausedUntil, &sub.LastDelivery, &sub.LastDeliveredAt,
+		&sub.CreatedAt, &sub.UpdatedAt, &sub.Version,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting subscription of chat %d: %w", chatID, err)
+	}
+	if weekdays.Valid {
+		sub.Schedule = &Schedule{Weekdays: decodeWeekdays(weekdays.String), Feasts: feasts}
+	}
+	return sub, nil
+}
+
+// Unsuscribe deletes the subscription of a chat
+func (s *SQLSubscriberStore) Unsuscribe(ctx context.Context, chatID int64) error {
+	if _, err := s.exec(ctx, "DELETE FROM subscriptions WHERE chat_id = ?", chatID); err != nil {
+		return fmt.Errorf("error when deleting chat with id %d: %w", chatID, err)
+	}
+	return nil
+}
+
+// Deactivate marks the subscription of a chat as inactive. Chats that are not subscribed are ignored.
+func (s *SQLSubscriberStore) Deactivate(ctx context.Context, chatID int64, reason string, date int64) error {
+	_, err := s.exec(ctx, "UPDATE subscriptions SET active = ?, inactive_reason = ?, inactive_since = ? WHERE chat_id = ?",
+		false, reason, date, chatID)
+	if err != nil {
+		return fmt.Errorf("error when deactivating chat with id %d: %w", chatID, err)
+	}
+	return nil
+}
+
+// GetInactiveSubscriptions returns the subscriptions that have been deactivated, sorted by chat
+func (s *SQLSubscriberStore) GetInactiveSubscriptions(ctx context.Context) ([]InactiveSubscription, error) {
+	rows, err := s.query(ctx, "SELECT chat_id, kind, inactive_reason, inactive_since FROM subscriptions "+
+		"WHERE active = ? ORDER BY chat_id", false)
+	if err != nil {
+		return []InactiveSubscription{}, fmt.Errorf("error querying inactive subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []InactiveSubscription{}
+	for rows.Next() {
+		subscription := InactiveSubscription{}
+		if err := rows.Scan(&subscription.ChatID, &subscription.Kind, &subscription.Reason, &subscription.Since); err != nil {
+			return []InactiveSubscription{}, fmt.Errorf("error reading inactive subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return []InactiveSubscription{}, fmt.Errorf("error querying inactive subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// GetSubscriptionStats counts the subscriptions
+func (s *SQLSubscriberStore) GetSubscriptionStats(ctx context.Context) (*SubscriptionStats, error) {
+	rows, err := s.query(ctx, "SELECT kind, active, paused_until FROM subscriptions")
+	if err != nil {
+		return nil, fmt.Errorf("error querying subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now().Unix()
+	stats := &SubscriptionStats{Active: map[string]int{}}
+	for rows.Next() {
+		var kind string
+		var active bool
+		var pausedUntil int64
+		if err := rows.Scan(&kind, &active, &pausedUntil); err != nil {
+			return nil, fmt.Errorf("error reading subscription: %w", err)
+		}
+		if !active {
+			stats.Inactive++
+			continue
+		}
+		if kind == "" {
+			kind = UnknownKind
+		}
+		stats.Active[kind]++
+		if pausedUntil > now {
+			stats.Paused++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying subscriptions: %w", err)
+	}
+	return stats, nil
+}
+
+// where returns the condition of the subscriptions that match the filter, along with its arguments
+func (f ChatFilter) where() (string, []interface{}) {
+	conditions := []string{"active = ?"}
+	args := []interface{}{true}
+
+	if f.DeliveryHour != nil {
+		conditions = append(conditions, "delivery_hour = ?")
+		args = append(args, *f.DeliveryHour)
+	}
+
+	if f.NotPausedAt != nil {
+		conditions = append(conditions, "paused_until <= ?")
+		args = append(args, f.NotPausedAt.Unix())
+	}
+
+	if f.Preview {
+		conditions = append(conditions, "preview = ?")
+		args = append(args, true)
+	}
+
+	if f.Weekday != nil {
+		args = append(args, "%"+strconv.Itoa(int(*f.Weekday))+"%")
+		if f.Feast {
+			conditions = append(conditions, "(weekdays IS NULL OR weekdays LIKE ? OR feasts = ?)")
+			args = append(args, true)
+		} else {
+			conditions = append(conditions, "(weekdays IS NULL OR weekdays LIKE ?)")
+		}
+	}
+
+	if f.Kind != "" {
+		conditions = append(conditions, "kind = ?")
+		args = append(args, f.Kind)
+	}
+
+	if f.Language != "" {
+		conditions = append(conditions, "language = ?")
+		args = append(args, f.Language)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// ScanChatIDs calls fn with the chat IDs of the active subscriptions matching the
+// filter, sorted and in pages of subscriberPageSize. It stops at the first error
+// returned either by the database or by fn.
+func (s *SQLSubscriberStore) ScanChatIDs(ctx context.Context, filter ChatFilter, fn func(chatIDs []string) error) error {
+	where, args := filter.where()
+	query := fmt.Sprintf(
+		"SELECT chat_id FROM subscriptions WHERE %s AND chat_id > ? ORDER BY chat_id LIMIT %d",
+		where,
+		subscriberPageSize,
+	)
+
+	// The chats are read in pages that start after the last chat of the previous
+	// one, so that no query is left open while fn is running
+	last := int64(-1 << 63)
+	for {
+		chatIDs, err := s.scanPage(ctx, query, append(args, last)...)
+		if err != nil {
+			return err
+		}
+		if len(chatIDs) == 0 {
+			return nil
+		}
+		if err := fn(chatIDs); err != nil {
+			return err
+		}
+		if len(chatIDs) < subscriberPageSize {
+			return nil
+		}
+		if last, err = strconv.ParseInt(chatIDs[len(chatIDs)-1], 10, 64); err != nil {
+			return fmt.Errorf("error converting chat ID into an integer: %w", err)
+		}
+	}
+}
+
+func (s *SQLSubscriberStore) scanPage(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying chat IDs: %w", err)
+	}
+	defer rows.Close()
+
+	chatIDs := []string{}
+	for rows.Next() {
+		var chatID int64
+		if err := rows.Scan(&chatID); err != nil {
+			return nil, fmt.Errorf("error reading chat ID: %w", err)
+		}
+		chatIDs = append(chatIDs, strconv.FormatInt(chatID, 10))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying chat IDs: %w", err)
+	}
+	return chatIDs, nil
+}
+
+// SetPreferences stores the preferences of a subscribed chat
+func (s *SQLSubscriberStore) SetPreferences(ctx context.Context, chatID int64, prefs *Preferences) error {
+	if err := prefs.validate(chatID); err != nil {
+		return err
+	}
+	return s.update(ctx, chatID, "error setting preferences of chat %d: %w",
+		"language = ?, profile = ?, delivery_hour = ?", prefs.Language, prefs.Profile, prefs.DeliveryHour)
+}
+
+// GetPreferences returns the preferences of a chat, or nil if it is not subscribed
+func (s *SQLSubscriberStore) GetPreferences(ctx context.Context, chatID int64) (*Preferences, error) {
+	prefs := &Preferences{}
+	err := s.queryRow(ctx, "SELECT language, profile, delivery_hour FROM subscriptions WHERE chat_id = ?", chatID).
+		Scan(&prefs.Language, &prefs.Profile, &prefs.DeliveryHour)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting preferences of chat %d: %w", chatID, err)
+	}
+	return prefs, nil
+}
+
+// SetSchedule stores the days in which a subscribed chat receives the Gospel. A nil
+// schedule, or one including every day of the week, removes any restriction.
+func (s *SQLSubscriberStore) SetSchedule(ctx context.Context, chatID int64, schedule *Schedule) error {
+	if err := schedule.validate(chatID); err != nil {
+		return err
+	}
+	weekdays := sql.NullString{}
+	feasts := false
+	if !schedule.EveryDay() {
+		weekdays = sql.NullString{String: schedule.encode(), Valid: true}
+		feasts = schedule.Feasts
+	}
+	return s.update(ctx, chatID, "error setting schedule of chat %d: %w", "weekdays = ?, feasts = ?", weekdays, feasts)
+}
+
+// SetPreview chooses whether a subscribed chat gets the readings of the next Sunday in advance
+func (s *SQLSubscriberStore) SetPreview(ctx context.Context, chatID int64, enabled bool) error {
+	return s.update(ctx, chatID, "error setting preview of chat %d: %w", "preview = ?", enabled)
+}
+
+// Pause stops the daily delivery to a subscribed chat until the given time
+func (s *SQLSubscriberStore) Pause(ctx context.Context, chatID int64, until time.Time) error {
+	return s.update(ctx, chatID, "error pausing chat %d: %w", "paused_until = ?", until.Unix())
+}
+
+// Resume restarts the daily delivery to a chat. Chats that are not subscribed are ignored.
+func (s *SQLSubscriberStore) Resume(ctx context.Context, chatID int64) error {
+	_, err := s.exec(ctx, "UPDATE subscriptions SET paused_until = 0, updated_at = ? WHERE chat_id = ?",
+		time.Now().Unix(), chatID)
+	if err != nil {
+		return fmt.Errorf("error resuming chat %d: %w", chatID, err)
+	}
+	return nil
+}
+
+// RecordDelivery records the date of the lectures delivered to a chat, unless it
+// already got the lectures of a later day. Chats that are not subscribed are ignored.
+func (s *SQLSubscriberStore) RecordDelivery(ctx context.Context, chatID int64, date string) error {
+	_, err := s.exec(ctx,
+		"UPDATE subscriptions SET last_delivery = ?, last_delivered_at = ? WHERE chat_id = ? AND last_delivery < ?",
+		date, time.Now().Unix(), chatID, date)
+	if err != nil {
+		return fmt.Errorf("error recording delivery %s for chat %d: %w", date, chatID, err)
+	}
+	return nil
+}
+
+// SetPolicy sets who may change the subscription of a subscribed group or channel
+func (s *SQLSubscriberStore) SetPolicy(ctx context.Context, chatID int64, policy string) error {
+	if err := validatePolicy(chatID, policy); err != nil {
+		return err
+	}
+	return s.update(ctx, chatID, "error setting policy of chat %d: %w", "policy = ?", policy)
+}
+
+// GetPolicy returns who may change the subscription of a chat. Chats that are not
+// subscribed or have not chosen any get DefaultPolicy.
+func (s *SQLSubscriberStore) GetPolicy(ctx context.Context, chatID int64) (string, error) {
+	var policy string
+	err := s.queryRow(ctx, "SELECT policy FROM subscriptions WHERE chat_id = ?", chatID).Scan(&policy)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("error getting policy of chat %d: %w", chatID, err)
+	}
+	if policy != "" {
+		return policy, nil
+	}
+	return DefaultPolicy, nil
+}
+
+// MigrateSubscriptions does nothing, since the rows of the subscriptions
+// table are always of the current version
+func (s *SQLSubscriberStore) MigrateSubscriptions(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// update sets the columns of the subscription of a chat, along with the time of the
+// update, returning ErrNotSubscribed wrapped with the given format if the chat is not subscribed
+func (s *SQLSubscriberStore) update(ctx context.Context, chatID int64, format, set string, args ...interface{}) error {
+	args = append(args, time.Now().Unix(), chatID)
+	updated, err := s.exec(ctx, "UPDATE subscriptions SET "+set+", updated_at = ? WHERE chat_id = ?", args...)
+	if err != nil {
+		return fmt.Errorf(format, chatID, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf(format, chatID, ErrNotSubscribed)
+	}
+	return nil
+}
+
+// exec runs a statement and returns the number of rows affected by it
+func (s *SQLSubscriberStore) exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLSubscriberStore) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, s.rebind(query), args...)
+}
+
+func (s *SQLSubscriberStore) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRowContext(ctx, s.rebind(query), args...)
+}
+
+// rebind replaces the ? placeholders of a query with the numbered ones of PostgreSQL
+func (s *SQLSubscriberStore) rebind(query string) string {
+	if s.kind != PostgresStore {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// driverRegistered tells whether the driver of database/sql has been registered
+func driverRegistered(driver string) bool {
+	for _, d := range sql.Drivers() {
+		if d == driver {
+			return true
+		}
+	}
+	return false
+}
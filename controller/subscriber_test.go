@@ -0,0 +1,386 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	_ "github.com/igvaquero18/magnifibot/controller/sqlstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenSubscriberStore(t *testing.T) {
+	tests := []struct {
+		name          string
+		kind          string
+		dsn           string
+		expected      interface{}
+		errorExpected bool
+	}{
+		{
+			name:     "dynamodb",
+			kind:     DynamoDBStore,
+			expected: nil,
+		},
+		{
+			name:     "default",
+			kind:     "",
+			expected: nil,
+		},
+		{
+			name:     "memory",
+			kind:     MemoryStore,
+			expected: &MemorySubscriberStore{},
+		},
+		{
+			name:     "sqlite",
+			kind:     SQLiteStore,
+			dsn:      filepath.Join(t.TempDir(), "magnifibot.db"),
+			expected: &SQLSubscriberStore{},
+		},
+		{
+			name:          "unknown kind",
+			kind:          "mongodb",
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			store, err := OpenSubscriberStore(context.TODO(), test.kind, test.dsn)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			if test.expected == nil {
+				assert.Nil(tt, store)
+			} else {
+				assert.IsType(tt, test.expected, store)
+			}
+			if s, ok := store.(*SQLSubscriberStore); ok {
+				s.Close()
+			}
+		})
+	}
+}
+
+func TestNewMagnifibotSubscriberStore(t *testing.T) {
+	m := NewMagnifibot(SetDynamoDBClient(&MockDynamoDB{}), SetConfig(&MagnifibotConfig{UserTable: "Users"}))
+	assert.Equal(t, NewDynamoDBSubscriberStore(&MockDynamoDB{}, "Users", DefaultScanSegments), m.SubscriberStore)
+
+	store := NewMemorySubscriberStore()
+	m = NewMagnifibot(SetDynamoDBClient(&MockDynamoDB{}), SetSubscriberStore(store))
+	assert.Equal(t, store, m.SubscriberStore)
+}
+
+// testSubscriberStores returns the stores that keep the subscriptions without DynamoDB
+func testSubscriberStores(t *testing.T) map[string]SubscriberStore {
+	sqlite, err := OpenSQLSubscriberStore(context.TODO(), SQLiteStore, filepath.Join(t.TempDir(), "magnifibot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+
+	return map[string]SubscriberStore{
+		"memory": NewMemorySubscriberStore(),
+		"sqlite": sqlite,
+	}
+}
+
+func TestSubscriberStoreSubscriptions(t *testing.T) {
+	for name, s := range testSubscriberStores(t) {
+		t.Run(name, func(tt *testing.T) {
+			ctx := context.TODO()
+
+			sub, err := s.GetSubscription(ctx, 42)
+			assert.NoError(tt, err)
+			assert.Nil(tt, sub)
+
+			assert.NoError(tt, s.Suscribe(ctx, &Subscription{ChatID: 42, UserID: 7, Kind: "group", Title: "Parish", CreatedAt: 100}))
+			assert.ErrorIs(tt, s.Suscribe(ctx, &Subscription{ChatID: 42, UserID: 7}), ErrAlreadySubscribed)
+
+			sub, err = s.GetSubscription(ctx, 42)
+			assert.NoError(tt, err)
+			assert.Equal(tt, int64(42), sub.ChatID)
+			assert.Equal(tt, int64(7), sub.UserID)
+			assert.Equal(tt, "group", sub.Kind)
+			assert.Equal(tt, "Parish", sub.Title)
+			assert.Equal(tt, DefaultPreferences(), sub.Preferences)
+			assert.True(tt, sub.Active)
+			assert.Nil(tt, sub.Schedule)
+			assert.Equal(tt, int64(100), sub.CreatedAt)
+			assert.Equal(tt, SubscriptionVersion, sub.Version)
+
+			assert.NoError(tt, s.Deactivate(ctx, 42, "blocked", 200))
+			assert.NoError(tt, s.Deactivate(ctx, 43, "blocked", 200))
+			inactive, err := s.GetInactiveSubscriptions(ctx)
+			assert.NoError(tt, err)
+			assert.Equal(tt, []InactiveSubscription{{ChatID: 42, Kind: "group", Reason: "blocked", Since: 200}}, inactive)
+
+			// Subscribing again reactivates the chat
+			assert.NoError(tt, s.Suscribe(ctx, &Subscription{ChatID: 42, UserID: 7}))
+			inactive, err = s.GetInactiveSubscriptions(ctx)
+			assert.NoError(tt, err)
+			assert.Empty(tt, inactive)
+
+			assert.NoError(tt, s.RecordDelivery(ctx, 42, "2022-03-20"))
+			assert.NoError(tt, s.RecordDelivery(ctx, 43, "2022-03-20"))
+			sub, err = s.GetSubscription(ctx, 42)
+			assert.NoError(tt, err)
+			assert.Equal(tt, "2022-03-20", sub.LastDelivery)
+
+			// Resending older lectures keeps the last delivery
+			assert.NoError(tt, s.RecordDelivery(ctx, 42, "2022-03-15"))
+			sub, err = s.GetSubscription(ctx, 42)
+			assert.NoError(tt, err)
+			assert.Equal(tt, "2022-03-20", sub.LastDelivery)
+
+			assert.NoError(tt, s.Unsuscribe(ctx, 42))
+			sub, err = s.GetSubscription(ctx, 42)
+			assert.NoError(tt, err)
+			assert.Nil(tt, sub)
+
+			migrated, err := s.MigrateSubscriptions(ctx)
+			assert.NoError(tt, err)
+			assert.Equal(tt, 0, migrated)
+		})
+	}
+}
+
+func TestSubscriberStoreSettings(t *testing.T) {
+	for name, s := range testSubscriberStores(t) {
+		t.Run(name, func(tt *testing.T) {
+			ctx := context.TODO()
+			prefs := &Preferences{Language: "en", Profile: GospelProfile, DeliveryHour: 8}
+			schedule := &Schedule{Weekdays: []time.Weekday{time.Saturday, time.Sunday}, Feasts: true}
+
+			// Chats that are not subscribed
+			assert.ErrorIs(tt, s.SetPreferences(ctx, 42, prefs), ErrNotSubscribed)
+			assert.ErrorIs(tt, s.SetSchedule(ctx, 42, schedule), ErrNotSubscribed)
+			assert.ErrorIs(tt, s.SetPreview(ctx, 42, true), ErrNotSubscribed)
+			assert.ErrorIs(tt, s.Pause(ctx, 42, time.Now()), ErrNotSubscribed)
+			assert.ErrorIs(tt, s.SetPolicy(ctx, 42, AnyonePolicy), ErrNotSubscribed)
+			assert.NoError(tt, s.Resume(ctx, 42))
+			actualPrefs, err := s.GetPreferences(ctx, 42)
+			assert.NoError(tt, err)
+			assert.Nil(tt, actualPrefs)
+			policy, err := s.GetPolicy(ctx, 42)
+			assert.NoError(tt, err)
+			assert.Equal(tt, DefaultPolicy, policy)
+
+			assert.NoError(tt, s.Suscribe(ctx, &Subscription{ChatID: 42, Kind: "group"}))
+
+			// Invalid settings
+			assert.Error(tt, s.SetPreferences(ctx, 42, &Preferences{Profile: "unknown"}))
+			assert.Error(tt, s.SetPreferences(ctx, 42, &Preferences{Profile: FullProfile, DeliveryHour: 24}))
+			assert.Error(tt, s.SetSchedule(ctx, 42, &Schedule{Weekdays: []time.Weekday{}}))
+			assert.Error(tt, s.SetPolicy(ctx, 42, "unknown"))
+
+			assert.NoError(tt, s.SetPreferences(ctx, 42, prefs))
+			assert.NoError(tt, s.SetSchedule(ctx, 42, schedule))
+			assert.NoError(tt, s.SetPreview(ctx, 42, true))
+			assert.NoError(tt, s.SetPolicy(ctx, 42, AnyonePolicy))
+			until := time.Now().Add(time.Hour)
+			assert.NoError(tt, s.Pause(ctx, 42, until))
+
+			actualPrefs, err = s.GetPreferences(ctx, 42)
+			assert.NoError(tt, err)
+			assert.Equal(tt, prefs, actualPrefs)
+			policy, err = s.GetPolicy(ctx, 42)
+			assert.NoError(tt, err)
+			assert.Equal(tt, AnyonePolicy, policy)
+			sub, err := s.GetSubscription(ctx, 42)
+			assert.NoError(tt, err)
+			assert.Equal(tt, &Schedule{Weekdays: []time.Weekday{time.Sunday, time.Saturday}, Feasts: true}, sub.Schedule)
+			assert.True(tt, sub.Preview)
+			assert.Equal(tt, until.Unix(), sub.PausedUntil)
+			assert.True(tt, sub.Paused(time.Now()))
+
+			stats, err := s.GetSubscriptionStats(ctx)
+			assert.NoError(tt, err)
+			assert.Equal(tt, &SubscriptionStats{Active: map[string]int{"group": 1}, Paused: 1}, stats)
+
+			assert.NoError(tt, s.Resume(ctx, 42))
+			assert.NoError(tt, s.SetSchedule(ctx, 42, nil))
+			sub, err = s.GetSubscription(ctx, 42)
+			assert.NoError(tt, err)
+			assert.Nil(tt, sub.Schedule)
+			assert.False(tt, sub.Paused(time.Now()))
+		})
+	}
+}
+
+func TestSubscriberStoreScanChatIDs(t *testing.T) {
+	hour := 8
+	defaultHour := DefaultDeliveryHour
+	now := time.Now()
+	saturday := time.Saturday
+	monday := time.Monday
+
+	tests := []struct {
+		name     string
+		filter   ChatFilter
+		expected []string
+	}{
+		{
+			name:     "all the active chats",
+			filter:   ChatFilter{},
+			expected: []string{"-100", "1", "2", "3", "4"},
+		},
+		{
+			name:     "delivery hour",
+			filter:   ChatFilter{DeliveryHour: &hour},
+			expected: []string{"2"},
+		},
+		{
+			name:     "default delivery hour",
+			filter:   ChatFilter{DeliveryHour: &defaultHour},
+			expected: []string{"-100", "1", "3", "4"},
+		},
+		{
+			name:     "not paused",
+			filter:   ChatFilter{NotPausedAt: &now},
+			expected: []string{"-100", "1", "2", "4"},
+		},
+		{
+			name:     "preview",
+			filter:   ChatFilter{Preview: true},
+			expected: []string{"1"},
+		},
+		{
+			name:     "weekday in the schedule",
+			filter:   ChatFilter{Weekday: &saturday},
+			expected: []string{"-100", "1", "2", "3", "4"},
+		},
+		{
+			name:     "weekday not in the schedule",
+			filter:   ChatFilter{Weekday: &monday},
+			expected: []string{"-100", "1", "2", "3"},
+		},
+		{
+			name:     "feast not in the schedule",
+			filter:   ChatFilter{Weekday: &monday, Feast: true},
+			expected: []string{"-100", "1", "2", "3", "4"},
+		},
+		{
+			name:     "kind",
+			filter:   ChatFilter{Kind: "channel"},
+			expected: []string{"-100"},
+		},
+		{
+			name:     "language",
+			filter:   ChatFilter{Language: "en"},
+			expected: []string{"2"},
+		},
+		{
+			name:     "no chat matches",
+			filter:   ChatFilter{Kind: "channel", Language: "en"},
+			expected: []string{},
+		},
+	}
+
+	for name, s := range testSubscriberStores(t) {
+		ctx := context.TODO()
+		for _, sub := range []*Subscription{
+			{ChatID: 1, Kind: "private"},
+			{ChatID: 2, Kind: "private", Preferences: &Preferences{Language: "en", Profile: FullProfile, DeliveryHour: hour}},
+			{ChatID: 3, Kind: "group"},
+			{ChatID: 4, Kind: "group"},
+			{ChatID: 5, Kind: "group"},
+			{ChatID: -100, Kind: "channel"},
+		} {
+			assert.NoError(t, s.Suscribe(ctx, sub))
+		}
+		assert.NoError(t, s.SetPreview(ctx, 1, true))
+		assert.NoError(t, s.Pause(ctx, 3, now.Add(time.Hour)))
+		assert.NoError(t, s.SetSchedule(ctx, 4, &Schedule{Weekdays: []time.Weekday{time.Saturday}, Feasts: true}))
+		assert.NoError(t, s.Deactivate(ctx, 5, "blocked", now.Unix()))
+
+		for _, test := range tests {
+			t.Run(name+"/"+test.name, func(tt *testing.T) {
+				actual := []string{}
+				err := s.ScanChatIDs(ctx, test.filter, func(chatIDs []string) error {
+					actual = append(actual, chatIDs...)
+					return nil
+				})
+				assert.NoError(tt, err)
+				sort.Strings(actual)
+				assert.Equal(tt, test.expected, actual)
+			})
+		}
+
+		t.Run(name+"/error", func(tt *testing.T) {
+			err := s.ScanChatIDs(ctx, ChatFilter{}, func(chatIDs []string) error {
+				return errors.New("error")
+			})
+			assert.Error(tt, err)
+		})
+	}
+}
+
+func TestHasChats(t *testing.T) {
+	hour := 8
+	tests := []struct {
+		name     string
+		filter   ChatFilter
+		expected bool
+	}{
+		{
+			name:     "chats match",
+			filter:   ChatFilter{},
+			expected: true,
+		},
+		{
+			name:     "no chat matches",
+			filter:   ChatFilter{DeliveryHour: &hour},
+			expected: false,
+		},
+	}
+
+	store := NewMemorySubscriberStore()
+	assert.NoError(t, store.Suscribe(context.TODO(), &Subscription{ChatID: 1, Kind: "private"}))
+	m := NewMagnifibot(SetSubscriberStore(store))
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			actual, err := m.HasChats(context.TODO(), test.filter)
+			assert.NoError(tt, err)
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestSQLSubscriberStoreScanChatIDsPages(t *testing.T) {
+	s, err := OpenSQLSubscriberStore(context.TODO(), SQLiteStore, filepath.Join(t.TempDir(), "magnifibot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	total := subscriberPageSize + 1
+	for i := 1; i <= total; i++ {
+		assert.NoError(t, s.Suscribe(context.TODO(), &Subscription{ChatID: int64(i)}))
+	}
+
+	pages := []int{}
+	err = s.ScanChatIDs(context.TODO(), ChatFilter{}, func(chatIDs []string) error {
+		pages = append(pages, len(chatIDs))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{subscriberPageSize, 1}, pages)
+}
+
+func TestSQLSubscriberStoreRebind(t *testing.T) {
+	query := "UPDATE subscriptions SET policy = ? WHERE chat_id = ?"
+	assert.Equal(t, query, (&SQLSubscriberStore{kind: SQLiteStore}).rebind(query))
+	assert.Equal(
+		t,
+		"UPDATE subscriptions SET policy = $1 WHERE chat_id = $2",
+		(&SQLSubscriberStore{kind: PostgresStore}).rebind(query),
+	)
+}
@@ -0,0 +1,294 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// SubscriptionVersion is the version of the items of the User table written by
+// Suscribe. The items of previous versions are upgraded by MigrateSubscriptions.
+//
+// Version 1 only had the ChatID, ID, Date and Kind attributes. Version 2 adds the
+// Title, Username, Language, Profile, DeliveryHour, CreatedAt and UpdatedAt attributes.
+const SubscriptionVersion = 2
+
+// Subscription is the record of a subscribed chat
+type Subscription struct {
+	ChatID int64
+
+	// UserID is the user that subscribed the chat
+	UserID int64
+
+	// Kind is the type of the chat, like private, group or channel
+	Kind string
+
+	// Title and Username identify the chat, when it has them
+	Title    string
+	Username string
+
+	Preferences *Preferences
+
+	// Active is false once the chat can no longer be reached
+	Active bool
+
+	// Schedule are the days in which the chat receives the Gospel,
+	// or nil if it receives it every day
+	Schedule *Schedule
+
+	// Preview tells whether the chat wants the readings of the next
+	// Sunday in advance
+	Preview bool
+
+	// PausedUntil is the Unix timestamp until which the delivery is paused,
+	// or 0 if it is not
+	PausedUntil int64
+
+	// CreatedAt and UpdatedAt are Unix timestamps
+	CreatedAt int64
+	UpdatedAt int64
+
+	// LastDelivery is the date of the last lectures delivered to the chat
+	// by the daily job, and LastDeliveredAt the Unix timestamp when it happened
+	LastDelivery    string
+	LastDeliveredAt int64
+
+	Version int
+}
+
+// GetSubscription returns the subscription of a chat, or nil if it is not subscribed
+func (s *DynamoDBSubscriberStore) GetSubscription(ctx context.Context, chatID int64) (*Subscription, error) {
+	output, err := s.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting subscription of chat %d: %w", chatID, err)
+	}
+	if output.Item == nil {
+		return nil, nil
+	}
+
+	prefs, err := preferencesFromItem(output.Item)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &Subscription{
+		ChatID:       chatID,
+		Kind:         getString(output.Item, "Kind"),
+		Title:        getString(output.Item, "Title"),
+		Username:     getString(output.Item, "Username"),
+		Preferences:  prefs,
+		Schedule:     scheduleFromItem(output.Item),
+		Active:       true,
+		LastDelivery: getString(output.Item, "LastDelivery"),
+		Version:      1,
+	}
+	if active, ok := output.Item["Active"].(*types.AttributeValueMemberBOOL); ok {
+		sub.Active = active.Value
+	}
+	if preview, ok := output.Item["Preview"].(*types.AttributeValueMemberBOOL); ok {
+		sub.Preview = preview.Value
+	}
+	sub.UserID, _ = getNumber(output.Item, "ID")
+	sub.LastDeliveredAt, _ = getNumber(output.Item, "LastDeliveredAt")
+	sub.PausedUntil, _ = getNumber(output.Item, "PausedUntil")
+	if version, err := getNumber(output.Item, "Version"); err == nil {
+		sub.Version = int(version)
+	}
+
+	// Items of version 1 only have the date in which the chat subscribed
+	if sub.CreatedAt, err = getNumber(output.Item, "CreatedAt"); err != nil {
+		sub.CreatedAt, _ = getNumber(output.Item, "Date")
+	}
+	if sub.UpdatedAt, err = getNumber(output.Item, "UpdatedAt"); err != nil {
+		sub.UpdatedAt = sub.CreatedAt
+	}
+	return sub, nil
+}
+
+// Paused returns whether the delivery is paused at the given time
+func (s *Subscription) Paused(at time.Time) bool {
+	return s.PausedUntil > at.Unix()
+}
+
+// SetPreview chooses whether a subscribed chat gets the readings of the next Sunday in advance
+func (s *DynamoDBSubscriberStore) SetPreview(ctx context.Context, chatID int64, enabled bool) error {
+	_, err := s.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(ChatID)"),
+		UpdateExpression:    aws.String("SET Preview = :preview, UpdatedAt = :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":preview": &types.AttributeValueMemberBOOL{Value: enabled},
+			":now":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error setting preview of chat %d: %w", chatID, err)
+	}
+	return nil
+}
+
+// Pause stops the daily delivery to a subscribed chat until the given time
+func (s *DynamoDBSubscriberStore) Pause(ctx context.Context, chatID int64, until time.Time) error {
+	_, err := s.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(ChatID)"),
+		UpdateExpression:    aws.String("SET PausedUntil = :until, UpdatedAt = :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":until": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", until.Unix())},
+			":now":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error pausing chat %d: %w", chatID, err)
+	}
+	return nil
+}
+
+// Resume restarts the daily delivery to a chat whose delivery was paused.
+// Chats that are not subscribed are ignored.
+func (s *DynamoDBSubscriberStore) Resume(ctx context.Context, chatID int64) error {
+	_, err := s.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(ChatID)"),
+		UpdateExpression:    aws.String("SET UpdatedAt = :now REMOVE PausedUntil"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+	})
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error resuming chat %d: %w", chatID, err)
+	}
+	return nil
+}
+
+// RecordDelivery records in the subscription of a chat the date of the lectures
+// that have just been delivered to it, unless it already got the lectures of a
+// later day, like when older lectures are resent. Chats that are not subscribed
+// are ignored.
+func (s *DynamoDBSubscriberStore) RecordDelivery(ctx context.Context, chatID int64, date string) error {
+	_, err := s.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+		ConditionExpression: aws.String(
+			"attribute_exists(ChatID) AND (attribute_not_exists(LastDelivery) OR LastDelivery < :date)",
+		),
+		UpdateExpression: aws.String("SET LastDelivery = :date, LastDeliveredAt = :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":date": &types.AttributeValueMemberS{Value: date},
+			":now":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+	})
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error recording delivery %s for chat %d: %w", date, chatID, err)
+	}
+	return nil
+}
+
+// MigrateSubscriptions upgrades the items of the User table written by previous
+// versions to SubscriptionVersion, filling the new attributes with the values they
+// implicitly had. It returns the number of items upgraded, and can be run again
+// safely, since the values already set are kept.
+func (s *DynamoDBSubscriberStore) MigrateSubscriptions(ctx context.Context) (int, error) {
+	paginator := dynamodb.NewScanPaginator(s, &dynamodb.ScanInput{
+		TableName:                aws.String(s.UserTable),
+		ProjectionExpression:     aws.String("ChatID, #date"),
+		FilterExpression:         aws.String("attribute_not_exists(Version) OR Version < :version"),
+		ExpressionAttributeNames: map[string]string{"#date": "Date"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":version": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", SubscriptionVersion)},
+		},
+	})
+
+	migrated := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return migrated, fmt.Errorf("error scanning dynamodb table: %w", err)
+		}
+
+		for _, item := range page.Items {
+			chatID, err := getNumber(item, "ChatID")
+			if err != nil {
+				return migrated, err
+			}
+			date, err := getNumber(item, "Date")
+			if err != nil {
+				date = time.Now().Unix()
+			}
+			if err := s.migrateSubscription(ctx, chatID, date); err != nil {
+				return migrated, err
+			}
+			migrated++
+		}
+	}
+	return migrated, nil
+}
+
+func (s *DynamoDBSubscriberStore) migrateSubscription(ctx context.Context, chatID, date int64) error {
+	prefs := DefaultPreferences()
+	_, err := s.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(ChatID)"),
+		UpdateExpression: aws.String("SET Version = :version, " +
+			"CreatedAt = if_not_exists(CreatedAt, :date), " +
+			"UpdatedAt = if_not_exists(UpdatedAt, :date), " +
+			"#language = if_not_exists(#language, :language), " +
+			"#profile = if_not_exists(#profile, :profile), " +
+			"#hour = if_not_exists(#hour, :hour)"),
+		ExpressionAttributeNames: map[string]string{
+			"#language": "Language",
+			"#profile":  "Profile",
+			"#hour":     "DeliveryHour",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":version":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", SubscriptionVersion)},
+			":date":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", date)},
+			":language": &types.AttributeValueMemberS{Value: prefs.Language},
+			":profile":  &types.AttributeValueMemberS{Value: prefs.Profile},
+			":hour":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", prefs.DeliveryHour)},
+		},
+	})
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error migrating subscription of chat %d: %w", chatID, err)
+	}
+	return nil
+}
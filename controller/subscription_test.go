@@ -0,0 +1,300 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSubscription(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		expected      *Subscription
+		errorExpected bool
+	}{
+		{
+			name: "current version",
+			dynamo: &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+				"ChatID":          &types.AttributeValueMemberN{Value: "-12"},
+				"ID":              &types.AttributeValueMemberN{Value: "10"},
+				"Kind":            &types.AttributeValueMemberS{Value: "group"},
+				"Title":           &types.AttributeValueMemberS{Value: "Parroquia"},
+				"Language":        &types.AttributeValueMemberS{Value: "en"},
+				"Profile":         &types.AttributeValueMemberS{Value: GospelProfile},
+				"DeliveryHour":    &types.AttributeValueMemberN{Value: "8"},
+				"CreatedAt":       &types.AttributeValueMemberN{Value: "1647588056"},
+				"UpdatedAt":       &types.AttributeValueMemberN{Value: "1647600000"},
+				"LastDelivery":    &types.AttributeValueMemberS{Value: "2022-03-18"},
+				"LastDeliveredAt": &types.AttributeValueMemberN{Value: "1647590000"},
+				"Version":         &types.AttributeValueMemberN{Value: "2"},
+			}}},
+			expected: &Subscription{
+				ChatID:          -12,
+				UserID:          10,
+				Kind:            "group",
+				Title:           "Parroquia",
+				Preferences:     &Preferences{Language: "en", Profile: GospelProfile, DeliveryHour: 8},
+				Active:          true,
+				CreatedAt:       1647588056,
+				UpdatedAt:       1647600000,
+				LastDelivery:    "2022-03-18",
+				LastDeliveredAt: 1647590000,
+				Version:         2,
+			},
+			errorExpected: false,
+		},
+		{
+			name: "first version",
+			dynamo: &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+				"ChatID": &types.AttributeValueMemberN{Value: "-12"},
+				"ID":     &types.AttributeValueMemberN{Value: "10"},
+				"Kind":   &types.AttributeValueMemberS{Value: "group"},
+				"Date":   &types.AttributeValueMemberN{Value: "1647588056"},
+				"Active": &types.AttributeValueMemberBOOL{Value: false},
+			}}},
+			expected: &Subscription{
+				ChatID:      -12,
+				UserID:      10,
+				Kind:        "group",
+				Preferences: DefaultPreferences(),
+				Active:      false,
+				CreatedAt:   1647588056,
+				UpdatedAt:   1647588056,
+				Version:     1,
+			},
+			errorExpected: false,
+		},
+		{
+			name:          "chat not suscribed",
+			dynamo:        &MockDynamoDB{getItemOutput: &dynamodb.GetItemOutput{}},
+			expected:      nil,
+			errorExpected: false,
+		},
+		{
+			name:          "dynamodb error",
+			dynamo:        &MockDynamoDB{errGetItem: errors.New("error")},
+			expected:      nil,
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			actual, err := m.GetSubscription(context.TODO(), -12)
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestRecordDelivery(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		errorExpected bool
+	}{
+		{
+			name:          "suscribed chat",
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "chat not suscribed or with a later delivery",
+			dynamo:        &MockDynamoDB{errUpdateItem: &types.ConditionalCheckFailedException{}},
+			errorExpected: false,
+		},
+		{
+			name:          "dynamodb error",
+			dynamo:        &MockDynamoDB{errUpdateItem: errors.New("error")},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.RecordDelivery(context.TODO(), 12, "2022-03-18")
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestMigrateSubscriptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		expected      int
+		errorExpected bool
+	}{
+		{
+			name: "items of the first version",
+			dynamo: &MockDynamoDB{scanOutput: &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{
+				{
+					"ChatID": &types.AttributeValueMemberN{Value: "12"},
+					"Date":   &types.AttributeValueMemberN{Value: "1647588056"},
+				},
+				{
+					"ChatID": &types.AttributeValueMemberN{Value: "-12"},
+				},
+			}}},
+			expected:      2,
+			errorExpected: false,
+		},
+		{
+			name:          "nothing to migrate",
+			dynamo:        &MockDynamoDB{scanOutput: &dynamodb.ScanOutput{}},
+			expected:      0,
+			errorExpected: false,
+		},
+		{
+			name:          "scan error",
+			dynamo:        &MockDynamoDB{errScan: errors.New("error")},
+			expected:      0,
+			errorExpected: true,
+		},
+		{
+			name: "update error",
+			dynamo: &MockDynamoDB{
+				scanOutput: &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{
+					{"ChatID": &types.AttributeValueMemberN{Value: "12"}},
+				}},
+				errUpdateItem: errors.New("error"),
+			},
+			expected:      0,
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			actual, err := m.MigrateSubscriptions(context.TODO())
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestPause(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		errorExpected bool
+	}{
+		{
+			name:          "suscribed chat",
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "chat not suscribed",
+			dynamo:        &MockDynamoDB{errUpdateItem: &types.ConditionalCheckFailedException{}},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.Pause(context.TODO(), 12, time.Now().AddDate(0, 0, 7))
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		errorExpected bool
+	}{
+		{
+			name:          "paused chat",
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "chat not suscribed",
+			dynamo:        &MockDynamoDB{errUpdateItem: &types.ConditionalCheckFailedException{}},
+			errorExpected: false,
+		},
+		{
+			name:          "dynamodb error",
+			dynamo:        &MockDynamoDB{errUpdateItem: errors.New("error")},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.Resume(context.TODO(), 12)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestSubscriptionPaused(t *testing.T) {
+	now := time.Now()
+	assert.False(t, (&Subscription{}).Paused(now))
+	assert.True(t, (&Subscription{PausedUntil: now.Add(time.Hour).Unix()}).Paused(now))
+	assert.False(t, (&Subscription{PausedUntil: now.Add(-time.Hour).Unix()}).Paused(now))
+}
+
+func TestSetPreview(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		errorExpected bool
+	}{
+		{
+			name:          "suscribed chat",
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "chat not suscribed",
+			dynamo:        &MockDynamoDB{errUpdateItem: &types.ConditionalCheckFailedException{}},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.SetPreview(context.TODO(), 12, true)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
@@ -2,10 +2,15 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/igvaquero18/magnifibot/api"
 	"github.com/mymmrac/telego"
+	"github.com/mymmrac/telego/telegoapi"
 )
 
 const (
@@ -13,17 +18,86 @@ const (
 )
 
 func (m *Magnifibot) SendTelegram(ctx context.Context, chatID string, message string) (int, error) {
+	return m.SendTelegramKeyboard(ctx, chatID, message, nil)
+}
+
+// SendTelegramKeyboard sends a message with an inline keyboard below it. The
+// data of the buttons must have been already signed.
+func (m *Magnifibot) SendTelegramKeyboard(
+	ctx context.Context,
+	chatID string,
+	message string,
+	keyboard *api.InlineKeyboardMarkup,
+) (int, error) {
 	id, err := strconv.ParseInt(chatID, 10, 64)
 	if err != nil {
 		return 0, fmt.Errorf("error converting chat ID from string to integer: %w", err)
 	}
-	telegramMessage, err := m.TelegramAPI.SendMessage(&telego.SendMessageParams{
+
+	params := &telego.SendMessageParams{
 		ChatID:    telego.ChatID{ID: id},
 		ParseMode: telegramParseMode,
 		Text:      message,
-	})
+	}
+	if keyboard != nil {
+		params.ReplyMarkup = toTelegoKeyboard(keyboard)
+	}
+
+	telegramMessage, err := m.TelegramAPI.SendMessage(params)
 	if err != nil {
 		return 0, fmt.Errorf("error sending telegram message: %w", err)
 	}
 	return telegramMessage.MessageID, nil
 }
+
+// AnswerCallback answers a callback query, so that the Telegram client stops waiting
+// for it, showing the text, if any, as a notification.
+func (m *Magnifibot) AnswerCallback(ctx context.Context, callbackID, text string) error {
+	if err := m.TelegramAPI.AnswerCallbackQuery(&telego.AnswerCallbackQueryParams{
+		CallbackQueryID: callbackID,
+		Text:            text,
+	}); err != nil {
+		return fmt.Errorf("error answering callback query %s: %w", callbackID, err)
+	}
+	return nil
+}
+
+func toTelegoKeyboard(keyboard *api.InlineKeyboardMarkup) *telego.InlineKeyboardMarkup {
+	rows := [][]telego.InlineKeyboardButton{}
+	for _, row := range keyboard.InlineKeyboard {
+		buttons := []telego.InlineKeyboardButton{}
+		for _, button := range row {
+			buttons = append(buttons, telego.InlineKeyboardButton{
+				Text:         button.Text,
+				URL:          button.URL,
+				CallbackData: button.CallbackData,
+			})
+		}
+		rows = append(rows, buttons)
+	}
+	return &telego.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// UnreachableReason classifies an error returned by the Telegram API, and reports
+// whether it means that messages can no longer be delivered to the chat, as it
+// happens when the user blocks the bot or the group is deleted. In that case it
+// also returns the description given by Telegram, to be used as the reason.
+func UnreachableReason(err error) (string, bool) {
+	var apiErr *telegoapi.Error
+	if !errors.As(err, &apiErr) {
+		return "", false
+	}
+
+	switch apiErr.ErrorCode {
+	case http.StatusForbidden:
+		return apiErr.Description, true
+	case http.StatusBadRequest:
+		description := strings.ToLower(apiErr.Description)
+		for _, gone := range []string{"chat not found", "group chat was deleted", "user not found"} {
+			if strings.Contains(description, gone) {
+				return apiErr.Description, true
+			}
+		}
+	}
+	return "", false
+}
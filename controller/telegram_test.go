@@ -3,23 +3,41 @@ package controller
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 
+	"github.com/igvaquero18/magnifibot/api"
 	"github.com/mymmrac/telego"
+	"github.com/mymmrac/telego/telegoapi"
 	"github.com/stretchr/testify/assert"
 )
 
 type MockTelegram struct {
-	messageID int
-	err       error
+	messageID   int
+	err         error
+	errCallback error
+	member      telego.ChatMember
+	errMember   error
+	texts       []string
+	markups     []telego.ReplyMarkup
 }
 
 func (m *MockTelegram) SendMessage(params *telego.SendMessageParams) (*telego.Message, error) {
+	m.texts = append(m.texts, params.Text)
+	m.markups = append(m.markups, params.ReplyMarkup)
 	return &telego.Message{
 		MessageID: m.messageID,
 	}, m.err
 }
 
+func (m *MockTelegram) AnswerCallbackQuery(*telego.AnswerCallbackQueryParams) error {
+	return m.errCallback
+}
+
+func (m *MockTelegram) GetChatMember(*telego.GetChatMemberParams) (telego.ChatMember, error) {
+	return m.member, m.errMember
+}
+
 func TestSetTelegram(t *testing.T) {
 	tests := []struct {
 		name,
@@ -70,7 +88,7 @@ func TestSetTelegram(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(tt *testing.T) {
-			m := NewMagnifibot(SetTelegramClient(&MockTelegram{test.expected, test.err}))
+			m := NewMagnifibot(SetTelegramClient(&MockTelegram{messageID: test.expected, err: test.err}))
 			actual, err := m.SendTelegram(context.TODO(), test.chatID, test.message)
 			if test.errorExpected {
 				assert.Error(tt, err)
@@ -81,3 +99,164 @@ func TestSetTelegram(t *testing.T) {
 		})
 	}
 }
+
+func TestUnreachableReason(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		expected    string
+		unreachable bool
+	}{
+		{
+			name: "bot blocked by the user",
+			err: fmt.Errorf(
+				"error sending telegram message: %w",
+				fmt.Errorf("api: %w", &telegoapi.Error{
+					ErrorCode:   403,
+					Description: "Forbidden: bot was blocked by the user",
+				}),
+			),
+			expected:    "Forbidden: bot was blocked by the user",
+			unreachable: true,
+		},
+		{
+			name: "group deleted",
+			err: fmt.Errorf("api: %w", &telegoapi.Error{
+				ErrorCode:   400,
+				Description: "Bad Request: group chat was deleted",
+			}),
+			expected:    "Bad Request: group chat was deleted",
+			unreachable: true,
+		},
+		{
+			name: "chat not found",
+			err: fmt.Errorf("api: %w", &telegoapi.Error{
+				ErrorCode:   400,
+				Description: "Bad Request: chat not found",
+			}),
+			expected:    "Bad Request: chat not found",
+			unreachable: true,
+		},
+		{
+			name: "malformed message",
+			err: fmt.Errorf("api: %w", &telegoapi.Error{
+				ErrorCode:   400,
+				Description: "Bad Request: can't parse entities",
+			}),
+			expected:    "",
+			unreachable: false,
+		},
+		{
+			name: "too many requests",
+			err: fmt.Errorf("api: %w", &telegoapi.Error{
+				ErrorCode:   429,
+				Description: "Too Many Requests: retry after 5",
+			}),
+			expected:    "",
+			unreachable: false,
+		},
+		{
+			name:        "non telegram error",
+			err:         errors.New("error"),
+			expected:    "",
+			unreachable: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			actual, unreachable := UnreachableReason(test.err)
+			assert.Equal(tt, test.unreachable, unreachable)
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestSendTelegramKeyboard(t *testing.T) {
+	keyboard := &api.InlineKeyboardMarkup{
+		InlineKeyboard: [][]api.InlineKeyboardButton{
+			{
+				{Text: "Sí", CallbackData: "unsub:yes:abcdefgh"},
+				{Text: "No", CallbackData: "unsub:no:abcdefgh"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		keyboard      *api.InlineKeyboardMarkup
+		expected      telego.ReplyMarkup
+		err           error
+		errorExpected bool
+	}{
+		{
+			name:     "message with keyboard",
+			keyboard: keyboard,
+			expected: &telego.InlineKeyboardMarkup{
+				InlineKeyboard: [][]telego.InlineKeyboardButton{
+					{
+						{Text: "Sí", CallbackData: "unsub:yes:abcdefgh"},
+						{Text: "No", CallbackData: "unsub:no:abcdefgh"},
+					},
+				},
+			},
+			errorExpected: false,
+		},
+		{
+			name:          "message without keyboard",
+			keyboard:      nil,
+			expected:      nil,
+			errorExpected: false,
+		},
+		{
+			name:          "error sending message",
+			keyboard:      keyboard,
+			err:           errors.New("error"),
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			telegram := &MockTelegram{messageID: 3, err: test.err}
+			m := NewMagnifibot(SetTelegramClient(telegram))
+			_, err := m.SendTelegramKeyboard(context.TODO(), "12", "¿Seguro?", test.keyboard)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+			assert.Equal(tt, []telego.ReplyMarkup{test.expected}, telegram.markups)
+		})
+	}
+}
+
+func TestAnswerCallback(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		errorExpected bool
+	}{
+		{
+			name:          "valid answer",
+			errorExpected: false,
+		},
+		{
+			name:          "error answering",
+			err:           errors.New("error"),
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetTelegramClient(&MockTelegram{errCallback: test.err}))
+			err := m.AnswerCallback(context.TODO(), "4382bfdwdsb323b2d9", "¡Hecho!")
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	DefaultUpdateTable = "MagnifibotUpdate"
+	DefaultUpdateTTL   = time.Hour
+)
+
+// UpdateStore remembers the updates of Telegram already handled, so that the
+// deliveries retried by Telegram when the webhook is slow are handled only once
+type UpdateStore interface {
+	// ClaimUpdate returns true the first time it is called with an update,
+	// and false for as long as the update is remembered
+	ClaimUpdate(ctx context.Context, updateID int64) (bool, error)
+}
+
+// ClaimUpdate records an update in the Update table, which forgets it after DefaultUpdateTTL
+func (m *Magnifibot) ClaimUpdate(ctx context.Context, updateID int64) (bool, error) {
+	now := time.Now()
+	_, err := m.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(m.Config.UpdateTable),
+		Item: map[string]types.AttributeValue{
+			"UpdateID":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", updateID)},
+			"ExpiresAt": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(DefaultUpdateTTL).Unix())},
+		},
+		// Expired items may still be in the table until DynamoDB deletes them
+		ConditionExpression: aws.String("attribute_not_exists(UpdateID) OR ExpiresAt < :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Unix())},
+		},
+	})
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error claiming update %d: %w", updateID, err)
+	}
+	return true, nil
+}
+
+// MemoryUpdateStore is an UpdateStore that keeps the updates in memory,
+// for tests and for running the bot in a single process
+type MemoryUpdateStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	updates map[int64]time.Time
+}
+
+// NewMemoryUpdateStore returns an UpdateStore that remembers the updates during the given time
+func NewMemoryUpdateStore(ttl time.Duration) *MemoryUpdateStore {
+	return &MemoryUpdateStore{
+		ttl:     ttl,
+		updates: map[int64]time.Time{},
+	}
+}
+
+// ClaimUpdate records an update in memory, forgetting the expired ones
+func (s *MemoryUpdateStore) ClaimUpdate(ctx context.Context, updateID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, expiresAt := range s.updates {
+		if expiresAt.Before(now) {
+			delete(s.updates, id)
+		}
+	}
+
+	if _, ok := s.updates[updateID]; ok {
+		return false, nil
+	}
+	s.updates[updateID] = now.Add(s.ttl)
+	return true, nil
+}
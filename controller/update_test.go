@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimUpdate(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		expected      bool
+		errorExpected bool
+	}{
+		{
+			name:          "new update",
+			dynamo:        &MockDynamoDB{},
+			expected:      true,
+			errorExpected: false,
+		},
+		{
+			name:          "update already handled",
+			dynamo:        &MockDynamoDB{errPutItem: &types.ConditionalCheckFailedException{}},
+			expected:      false,
+			errorExpected: false,
+		},
+		{
+			name:          "dynamodb error",
+			dynamo:        &MockDynamoDB{errPutItem: errors.New("error")},
+			expected:      false,
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			actual, err := m.ClaimUpdate(context.TODO(), 42)
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestMemoryUpdateStore(t *testing.T) {
+	s := NewMemoryUpdateStore(time.Hour)
+
+	claimed, err := s.ClaimUpdate(context.TODO(), 42)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	claimed, err = s.ClaimUpdate(context.TODO(), 42)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+
+	claimed, err = s.ClaimUpdate(context.TODO(), 43)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	expired := NewMemoryUpdateStore(-time.Second)
+	claimed, _ = expired.ClaimUpdate(context.TODO(), 42)
+	assert.True(t, claimed)
+	claimed, _ = expired.ClaimUpdate(context.TODO(), 42)
+	assert.True(t, claimed)
+}
@@ -2,7 +2,11 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -10,54 +14,345 @@ import (
 )
 
 const (
-	DefaultUserTable = "MagnifibotUser"
+	DefaultUserTable    = "MagnifibotUser"
+	DefaultScanSegments = 4
 )
 
-func (m *Magnifibot) Suscribe(ctx context.Context, chatID, userID, date int64, kind string) error {
-	_, err := m.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(m.Config.UserTable),
+// ErrAlreadySubscribed is returned by Suscribe when the chat already has an active subscription
+var ErrAlreadySubscribed = errors.New("chat already subscribed")
+
+// InactiveSubscription is a subscription whose chat can no longer be reached
+type InactiveSubscription struct {
+	ChatID int64
+	Kind   string
+	Reason string
+	Since  int64
+}
+
+// Suscribe stores the subscription of a chat as a record of the current version.
+// Missing preferences get the default values. The record of a chat that is already
+// subscribed is kept as it is, only reactivating it if it had been deactivated, and
+// ErrAlreadySubscribed is returned if it was active.
+func (s *DynamoDBSubscriberStore) Suscribe(ctx context.Context, sub *Subscription) error {
+	prefs := sub.Preferences
+	if prefs == nil {
+		prefs = DefaultPreferences()
+	}
+	now := time.Now().Unix()
+	createdAt := sub.CreatedAt
+	if createdAt == 0 {
+		createdAt = now
+	}
+
+	_, err := s.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.UserTable),
 		Item: map[string]types.AttributeValue{
+			"ChatID":       &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", sub.ChatID)},
+			"ID":           &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", sub.UserID)},
+			"Kind":         &types.AttributeValueMemberS{Value: sub.Kind},
+			"Title":        &types.AttributeValueMemberS{Value: sub.Title},
+			"Username":     &types.AttributeValueMemberS{Value: sub.Username},
+			"Language":     &types.AttributeValueMemberS{Value: prefs.Language},
+			"Profile":      &types.AttributeValueMemberS{Value: prefs.Profile},
+			"DeliveryHour": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", prefs.DeliveryHour)},
+			"CreatedAt":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", createdAt)},
+			"UpdatedAt":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now)},
+			"Version":      &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", SubscriptionVersion)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(ChatID)"),
+	})
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return s.reactivate(ctx, sub.ChatID, now)
+	}
+	if err != nil {
+		return fmt.Errorf("error when suscribing user %d: %w", sub.UserID, err)
+	}
+	return nil
+}
+
+// reactivate marks the deactivated subscription of a chat as active again,
+// returning ErrAlreadySubscribed if it was active
+func (s *DynamoDBSubscriberStore) reactivate(ctx context.Context, chatID, date int64) error {
+	_, err := s.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
 			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
-			"ID":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", userID)},
-			"Date":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", date)},
-			"Kind":   &types.AttributeValueMemberS{Value: kind},
+		},
+		ConditionExpression: aws.String("attribute_exists(ChatID) AND Active = :inactive"),
+		UpdateExpression:    aws.String("SET Active = :active, UpdatedAt = :date REMOVE InactiveReason, InactiveSince"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":active":   &types.AttributeValueMemberBOOL{Value: true},
+			":inactive": &types.AttributeValueMemberBOOL{Value: false},
+			":date":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", date)},
 		},
 	})
 
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return fmt.Errorf("error when suscribing chat %d: %w", chatID, ErrAlreadySubscribed)
+	}
 	if err != nil {
-		return fmt.Errorf("error when suscribing user %d: %w", userID, err)
+		return fmt.Errorf("error when reactivating chat %d: %w", chatID, err)
 	}
 	return nil
 }
 
-func (m *Magnifibot) Unsuscribe(ctx context.Context, chatID int64) error {
-	if err := m.delete(ctx, "ChatID", &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)}, m.Config.UserTable); err != nil {
+func (s *DynamoDBSubscriberStore) Unsuscribe(ctx context.Context, chatID int64) error {
+	_, err := s.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+	})
+	if err != nil {
 		return fmt.Errorf("error when deleting chat with id %d: %w", chatID, err)
 	}
 	return nil
 }
 
-func (m *Magnifibot) GetChatIDs(ctx context.Context) ([]string, error) {
-	scanOutput, err := m.Scan(ctx, &dynamodb.ScanInput{
-		TableName:            aws.String(m.Config.UserTable),
-		ProjectionExpression: aws.String("ChatID"),
+// Deactivate marks the subscription of a chat as inactive, so that it stops receiving
+// the Gospel, keeping the reason and the date in which it happened. Chats that are
+// not subscribed are ignored.
+func (s *DynamoDBSubscriberStore) Deactivate(ctx context.Context, chatID int64, reason string, date int64) error {
+	_, err := s.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.UserTable),
+		Key: map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", chatID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(ChatID)"),
+		UpdateExpression:    aws.String("SET Active = :active, InactiveReason = :reason, InactiveSince = :date"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":active": &types.AttributeValueMemberBOOL{Value: false},
+			":reason": &types.AttributeValueMemberS{Value: reason},
+			":date":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", date)},
+		},
 	})
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return nil
+	}
 	if err != nil {
-		return []string{}, fmt.Errorf("error scanning dynamodb table: %w", err)
+		return fmt.Errorf("error when deactivating chat with id %d: %w", chatID, err)
 	}
+	return nil
+}
+
+// GetInactiveSubscriptions returns the subscriptions that have been deactivated
+// because their chats became unreachable.
+func (s *DynamoDBSubscriberStore) GetInactiveSubscriptions(ctx context.Context) ([]InactiveSubscription, error) {
+	paginator := dynamodb.NewScanPaginator(s, &dynamodb.ScanInput{
+		TableName:                 aws.String(s.UserTable),
+		ProjectionExpression:      aws.String("ChatID, Kind, InactiveReason, InactiveSince"),
+		FilterExpression:          aws.String("Active = :active"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":active": &types.AttributeValueMemberBOOL{Value: false}},
+	})
+
+	subscriptions := []InactiveSubscription{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return []InactiveSubscription{}, fmt.Errorf("error scanning dynamodb table: %w", err)
+		}
 
-	chatIDs := []string{}
-	for _, item := range scanOutput.Items {
-		if chatID, ok := item["ChatID"]; ok {
-			id, ok := chatID.(*types.AttributeValueMemberN)
-			if !ok {
-				return []string{}, fmt.Errorf("error converting ChatID into an AttributeValueMemberN: %w", err)
+		for _, item := range page.Items {
+			subscription := InactiveSubscription{}
+			if subscription.ChatID, err = getNumber(item, "ChatID"); err != nil {
+				return []InactiveSubscription{}, err
 			}
-			chatIDs = append(chatIDs, id.Value)
+			if subscription.Since, err = getNumber(item, "InactiveSince"); err != nil {
+				return []InactiveSubscription{}, err
+			}
+			subscription.Kind = getString(item, "Kind")
+			subscription.Reason = getString(item, "InactiveReason")
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return subscriptions, nil
+}
+
+// ChatFilter selects the subscriptions returned by ScanChatIDs. Inactive
+// subscriptions are never returned.
+type ChatFilter struct {
+	// DeliveryHour, when set, only matches the chats that receive the
+	// Gospel at that hour
+	DeliveryHour *int
+
+	// NotPausedAt, when set, only matches the chats whose delivery is
+	// not paused at that time
+	NotPausedAt *time.Time
+
+	// Weekday, when set, only matches the chats whose schedule includes
+	// that day of the week
+	Weekday *time.Weekday
+
+	// Preview only matches the chats that want the readings of the next
+	// Sunday in advance
+	Preview bool
+
+	// Feast tells whether the day is a solemnity or a feast, so that the
+	// chats that receive the Gospel on them also match the Weekday filter
+	Feast bool
+
+	// Kind, when set, only matches the chats of that type, like private or group
+	Kind string
+
+	// Language, when set, only matches the chats that chose that language
+	Language string
+}
+
+func (f ChatFilter) expression() (string, map[string]string, map[string]types.AttributeValue) {
+	expression := "(attribute_not_exists(Active) OR Active = :active)"
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{":active": &types.AttributeValueMemberBOOL{Value: true}}
+
+	if f.DeliveryHour != nil {
+		names["#hour"] = "DeliveryHour"
+		values[":hour"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", *f.DeliveryHour)}
+		if *f.DeliveryHour == DefaultDeliveryHour {
+			expression += " AND (#hour = :hour OR attribute_not_exists(#hour))"
 		} else {
-			return []string{}, fmt.Errorf("error getting ChatID for item %v: %w", item, err)
+			expression += " AND #hour = :hour"
 		}
 	}
 
-	return chatIDs, nil
+	if f.NotPausedAt != nil {
+		values[":now"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", f.NotPausedAt.Unix())}
+		expression += " AND (attribute_not_exists(PausedUntil) OR PausedUntil <= :now)"
+	}
+
+	if f.Preview {
+		values[":preview"] = &types.AttributeValueMemberBOOL{Value: true}
+		expression += " AND Preview = :preview"
+	}
+
+	if f.Weekday != nil {
+		values[":weekday"] = &types.AttributeValueMemberS{Value: strconv.Itoa(int(*f.Weekday))}
+		if f.Feast {
+			values[":feasts"] = &types.AttributeValueMemberBOOL{Value: true}
+			expression += " AND (attribute_not_exists(Weekdays) OR contains(Weekdays, :weekday) OR Feasts = :feasts)"
+		} else {
+			expression += " AND (attribute_not_exists(Weekdays) OR contains(Weekdays, :weekday))"
+		}
+	}
+
+	if f.Kind != "" {
+		names["#kind"] = "Kind"
+		values[":kind"] = &types.AttributeValueMemberS{Value: f.Kind}
+		expression += " AND #kind = :kind"
+	}
+
+	if f.Language != "" {
+		names["#language"] = "Language"
+		values[":language"] = &types.AttributeValueMemberS{Value: f.Language}
+		if f.Language == DefaultLanguage {
+			expression += " AND (#language = :language OR attribute_not_exists(#language))"
+		} else {
+			expression += " AND #language = :language"
+		}
+	}
+
+	if len(names) == 0 {
+		names = nil
+	}
+	return expression, names, values
+}
+
+// ScanChatIDs pages through the User table calling fn with the chat IDs of the
+// active subscriptions matching the filter found in every page, so that callers can start working
+// before the whole table has been read. The table is split in ScanSegments
+// segments that are scanned in parallel, which means fn may be called concurrently.
+// The scan stops at the first error returned either by DynamoDB or by fn.
+func (s *DynamoDBSubscriberStore) ScanChatIDs(ctx context.Context, filter ChatFilter, fn func(chatIDs []string) error) error {
+	segments := s.ScanSegments
+	if segments < 1 {
+		segments = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, segments)
+	var wg sync.WaitGroup
+	for segment := 0; segment < segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			if err := s.scanSegment(ctx, filter, int32(segment), int32(segments), fn); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(segment)
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+func (s *DynamoDBSubscriberStore) scanSegment(
+	ctx context.Context,
+	filter ChatFilter,
+	segment, totalSegments int32,
+	fn func(chatIDs []string) error,
+) error {
+	expression, names, values := filter.expression()
+	paginator := dynamodb.NewScanPaginator(s, &dynamodb.ScanInput{
+		TableName:                 aws.String(s.UserTable),
+		ProjectionExpression:      aws.String("ChatID"),
+		FilterExpression:          aws.String(expression),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		Segment:                   aws.Int32(segment),
+		TotalSegments:             aws.Int32(totalSegments),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error scanning segment %d of dynamodb table: %w", segment, err)
+		}
+
+		chatIDs := make([]string, 0, len(page.Items))
+		for _, item := range page.Items {
+			chatID, err := getNumber(item, "ChatID")
+			if err != nil {
+				return err
+			}
+			chatIDs = append(chatIDs, strconv.FormatInt(chatID, 10))
+		}
+
+		if len(chatIDs) == 0 {
+			continue
+		}
+		if err := fn(chatIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getNumber(item map[string]types.AttributeValue, name string) (int64, error) {
+	value, ok := item[name]
+	if !ok {
+		return 0, fmt.Errorf("error getting %s for item %v", name, item)
+	}
+	n, ok := value.(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, fmt.Errorf("error converting %s into an AttributeValueMemberN", name)
+	}
+	number, err := strconv.ParseInt(n.Value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error converting %s into an integer: %w", name, err)
+	}
+	return number, nil
+}
+
+func getString(item map[string]types.AttributeValue, name string) string {
+	if s, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
 }
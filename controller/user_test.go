@@ -3,8 +3,12 @@ package controller
 import (
 	"context"
 	"errors"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/stretchr/testify/assert"
@@ -15,9 +19,12 @@ type MockDynamoDB struct {
 	errGetItem       error
 	putItemOutput    *dynamodb.PutItemOutput
 	errPutItem       error
+	updateItemOutput *dynamodb.UpdateItemOutput
+	errUpdateItem    error
 	deleteItemOutput *dynamodb.DeleteItemOutput
 	errDeleteItem    error
 	scanOutput       *dynamodb.ScanOutput
+	scanPages        map[int32][]*dynamodb.ScanOutput
 	errScan          error
 }
 
@@ -37,6 +44,14 @@ func (m *MockDynamoDB) PutItem(
 	return m.putItemOutput, m.errPutItem
 }
 
+func (m *MockDynamoDB) UpdateItem(
+	context.Context,
+	*dynamodb.UpdateItemInput,
+	...func(*dynamodb.Options),
+) (*dynamodb.UpdateItemOutput, error) {
+	return m.updateItemOutput, m.errUpdateItem
+}
+
 func (m *MockDynamoDB) DeleteItem(
 	context.Context,
 	*dynamodb.DeleteItemInput,
@@ -45,39 +60,95 @@ func (m *MockDynamoDB) DeleteItem(
 	return m.deleteItemOutput, m.errDeleteItem
 }
 
+// Scan returns scanOutput, unless scanPages is set, in which case it returns the
+// pages of the requested segment, using the "page" key to paginate through them
 func (m *MockDynamoDB) Scan(
-	context.Context,
-	*dynamodb.ScanInput,
-	...func(*dynamodb.Options),
+	_ context.Context,
+	input *dynamodb.ScanInput,
+	_ ...func(*dynamodb.Options),
 ) (*dynamodb.ScanOutput, error) {
-	return m.scanOutput, m.errScan
+	if m.scanPages == nil {
+		return m.scanOutput, m.errScan
+	}
+
+	page := 0
+	if key, ok := input.ExclusiveStartKey["page"].(*types.AttributeValueMemberN); ok {
+		page, _ = strconv.Atoi(key.Value)
+	}
+	return m.scanPages[aws.ToInt32(input.Segment)][page], m.errScan
+}
+
+// scanPage builds a page of a Scan with the given chat IDs, pointing to the
+// next page when there is one
+func scanPage(next int, chatIDs ...string) *dynamodb.ScanOutput {
+	output := &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{}}
+	for _, chatID := range chatIDs {
+		output.Items = append(output.Items, map[string]types.AttributeValue{
+			"ChatID": &types.AttributeValueMemberN{Value: chatID},
+		})
+	}
+	if next > 0 {
+		output.LastEvaluatedKey = map[string]types.AttributeValue{
+			"page": &types.AttributeValueMemberN{Value: strconv.Itoa(next)},
+		}
+	}
+	return output
 }
 
 func TestSuscribe(t *testing.T) {
 	tests := []struct {
-		name string
-		chatID,
-		userID,
-		date int64
-		kind          string
-		dynamo        DynamoDBInterface
-		errorExpected bool
+		name              string
+		sub               *Subscription
+		dynamo            DynamoDBInterface
+		alreadySubscribed bool
+		errorExpected     bool
 	}{
 		{
 			name:          "valid suscribe",
-			chatID:        12,
-			userID:        10,
-			date:          1647588056,
-			kind:          "private",
+			sub:           &Subscription{ChatID: 12, UserID: 10, CreatedAt: 1647588056, Kind: "private"},
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name: "valid suscribe with preferences",
+			sub: &Subscription{
+				ChatID:      -12,
+				UserID:      10,
+				Kind:        "group",
+				Title:       "Parroquia",
+				Preferences: &Preferences{Language: "en", Profile: GospelProfile, DeliveryHour: 8},
+			},
 			dynamo:        &MockDynamoDB{},
 			errorExpected: false,
 		},
+		{
+			name:          "inactive subscription",
+			sub:           &Subscription{ChatID: 12, UserID: 10, CreatedAt: 1647588056, Kind: "private"},
+			dynamo:        &MockDynamoDB{errPutItem: &types.ConditionalCheckFailedException{}},
+			errorExpected: false,
+		},
+		{
+			name: "already suscribed",
+			sub:  &Subscription{ChatID: 12, UserID: 10, CreatedAt: 1647588056, Kind: "private"},
+			dynamo: &MockDynamoDB{
+				errPutItem:    &types.ConditionalCheckFailedException{},
+				errUpdateItem: &types.ConditionalCheckFailedException{},
+			},
+			alreadySubscribed: true,
+			errorExpected:     true,
+		},
+		{
+			name: "error when reactivating",
+			sub:  &Subscription{ChatID: 12, UserID: 10, CreatedAt: 1647588056, Kind: "private"},
+			dynamo: &MockDynamoDB{
+				errPutItem:    &types.ConditionalCheckFailedException{},
+				errUpdateItem: errors.New("error"),
+			},
+			errorExpected: true,
+		},
 		{
 			name:          "error when suscribing",
-			chatID:        12,
-			userID:        10,
-			date:          1647588056,
-			kind:          "private",
+			sub:           &Subscription{ChatID: 12, UserID: 10, CreatedAt: 1647588056, Kind: "private"},
 			dynamo:        &MockDynamoDB{errPutItem: errors.New("error")},
 			errorExpected: true,
 		},
@@ -86,7 +157,8 @@ func TestSuscribe(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(tt *testing.T) {
 			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
-			err := m.Suscribe(context.TODO(), test.chatID, test.userID, test.date, test.kind)
+			err := m.Suscribe(context.TODO(), test.sub)
+			assert.Equal(tt, test.alreadySubscribed, errors.Is(err, ErrAlreadySubscribed))
 			if test.errorExpected {
 				assert.Error(tt, err)
 				return
@@ -130,52 +202,205 @@ func TestUnsuscribe(t *testing.T) {
 	}
 }
 
-func TestGetChatIDs(t *testing.T) {
+func TestDeactivate(t *testing.T) {
 	tests := []struct {
 		name          string
 		dynamo        DynamoDBInterface
-		expected      []string
 		errorExpected bool
 	}{
 		{
-			name: "valid chat IDs",
+			name:          "valid deactivate",
+			dynamo:        &MockDynamoDB{},
+			errorExpected: false,
+		},
+		{
+			name:          "chat not suscribed",
+			dynamo:        &MockDynamoDB{errUpdateItem: &types.ConditionalCheckFailedException{}},
+			errorExpected: false,
+		},
+		{
+			name:          "error when deactivating",
+			dynamo:        &MockDynamoDB{errUpdateItem: errors.New("error")},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			err := m.Deactivate(context.TODO(), 12, "Forbidden: bot was blocked by the user", 1647588056)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				return
+			}
+			assert.NoError(tt, err)
+		})
+	}
+}
+
+func TestGetInactiveSubscriptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		dynamo        DynamoDBInterface
+		expected      []InactiveSubscription
+		errorExpected bool
+	}{
+		{
+			name: "valid inactive subscriptions",
 			dynamo: &MockDynamoDB{
 				scanOutput: &dynamodb.ScanOutput{
 					Items: []map[string]types.AttributeValue{
 						{
-							"ChatID": &types.AttributeValueMemberN{
-								Value: "12",
-							},
-						},
-						{
-							"ChatID": &types.AttributeValueMemberN{
-								Value: "13",
-							},
+							"ChatID":         &types.AttributeValueMemberN{Value: "12"},
+							"Kind":           &types.AttributeValueMemberS{Value: "private"},
+							"InactiveReason": &types.AttributeValueMemberS{Value: "Forbidden: bot was blocked by the user"},
+							"InactiveSince":  &types.AttributeValueMemberN{Value: "1647588056"},
 						},
 					},
 				},
 			},
+			expected: []InactiveSubscription{
+				{
+					ChatID: 12,
+					Kind:   "private",
+					Reason: "Forbidden: bot was blocked by the user",
+					Since:  1647588056,
+				},
+			},
+			errorExpected: false,
+		},
+		{
+			name: "missing inactive date",
+			dynamo: &MockDynamoDB{
+				scanOutput: &dynamodb.ScanOutput{
+					Items: []map[string]types.AttributeValue{
+						{"ChatID": &types.AttributeValueMemberN{Value: "12"}},
+					},
+				},
+			},
+			expected:      []InactiveSubscription{},
+			errorExpected: true,
+		},
+		{
+			name:          "error getting inactive subscriptions",
+			dynamo:        &MockDynamoDB{errScan: errors.New("error")},
+			expected:      []InactiveSubscription{},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
+			actual, err := m.GetInactiveSubscriptions(context.TODO())
+			if test.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}
+
+func TestScanChatIDs(t *testing.T) {
+	tests := []struct {
+		name          string
+		segments      int
+		dynamo        DynamoDBInterface
+		errCallback   error
+		expected      []string
+		errorExpected bool
+	}{
+		{
+			name:     "valid chat IDs",
+			segments: 1,
+			dynamo: &MockDynamoDB{
+				scanPages: map[int32][]*dynamodb.ScanOutput{
+					0: {scanPage(0, "12", "13")},
+				},
+			},
 			expected:      []string{"12", "13"},
 			errorExpected: false,
 		},
+		{
+			name:     "several pages",
+			segments: 1,
+			dynamo: &MockDynamoDB{
+				scanPages: map[int32][]*dynamodb.ScanOutput{
+					0: {scanPage(1, "12", "13"), scanPage(2), scanPage(0, "14")},
+				},
+			},
+			expected:      []string{"12", "13", "14"},
+			errorExpected: false,
+		},
+		{
+			name:     "several segments",
+			segments: 3,
+			dynamo: &MockDynamoDB{
+				scanPages: map[int32][]*dynamodb.ScanOutput{
+					0: {scanPage(1, "12"), scanPage(0, "13")},
+					1: {scanPage(0, "14", "15")},
+					2: {scanPage(0)},
+				},
+			},
+			expected:      []string{"12", "13", "14", "15"},
+			errorExpected: false,
+		},
 		{
 			name:          "empty chat IDs",
+			segments:      1,
 			dynamo:        &MockDynamoDB{scanOutput: &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{}}},
 			expected:      []string{},
 			errorExpected: false,
 		},
+		{
+			name:     "invalid chat ID",
+			segments: 1,
+			dynamo: &MockDynamoDB{
+				scanPages: map[int32][]*dynamodb.ScanOutput{
+					0: {{Items: []map[string]types.AttributeValue{{"ID": &types.AttributeValueMemberN{Value: "10"}}}}},
+				},
+			},
+			expected:      []string{},
+			errorExpected: true,
+		},
 		{
 			name:          "error getting chat IDs",
+			segments:      2,
 			dynamo:        &MockDynamoDB{errScan: errors.New("error")},
 			expected:      []string{},
 			errorExpected: true,
 		},
+		{
+			name:     "error in callback",
+			segments: 1,
+			dynamo: &MockDynamoDB{
+				scanPages: map[int32][]*dynamodb.ScanOutput{
+					0: {scanPage(1, "12"), scanPage(0, "13")},
+				},
+			},
+			errCallback:   errors.New("error"),
+			expected:      []string{"12"},
+			errorExpected: true,
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(tt *testing.T) {
-			m := NewMagnifibot(SetDynamoDBClient(test.dynamo))
-			actual, err := m.GetChatIDs(context.TODO())
+			m := NewMagnifibot(
+				SetDynamoDBClient(test.dynamo),
+				SetConfig(&MagnifibotConfig{ScanSegments: test.segments}),
+			)
+
+			var mu sync.Mutex
+			actual := []string{}
+			err := m.ScanChatIDs(context.TODO(), ChatFilter{}, func(chatIDs []string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				actual = append(actual, chatIDs...)
+				return test.errCallback
+			})
 			if test.errorExpected {
 				assert.Error(tt, err)
 			} else {
@@ -185,3 +410,85 @@ func TestGetChatIDs(t *testing.T) {
 		})
 	}
 }
+
+func TestChatFilterExpression(t *testing.T) {
+	now := time.Now()
+	sunday := time.Sunday
+	tests := []struct {
+		name       string
+		filter     ChatFilter
+		expression string
+		names      map[string]string
+	}{
+		{
+			name:       "any hour",
+			filter:     ChatFilter{},
+			expression: "(attribute_not_exists(Active) OR Active = :active)",
+			names:      nil,
+		},
+		{
+			name:       "default hour",
+			filter:     ChatFilter{DeliveryHour: aws.Int(DefaultDeliveryHour)},
+			expression: "(attribute_not_exists(Active) OR Active = :active) AND (#hour = :hour OR attribute_not_exists(#hour))",
+			names:      map[string]string{"#hour": "DeliveryHour"},
+		},
+		{
+			name:       "other hour",
+			filter:     ChatFilter{DeliveryHour: aws.Int(9)},
+			expression: "(attribute_not_exists(Active) OR Active = :active) AND #hour = :hour",
+			names:      map[string]string{"#hour": "DeliveryHour"},
+		},
+		{
+			name:   "not paused",
+			filter: ChatFilter{DeliveryHour: aws.Int(9), NotPausedAt: &now},
+			expression: "(attribute_not_exists(Active) OR Active = :active) AND #hour = :hour" +
+				" AND (attribute_not_exists(PausedUntil) OR PausedUntil <= :now)",
+			names: map[string]string{"#hour": "DeliveryHour"},
+		},
+		{
+			name:       "preview",
+			filter:     ChatFilter{Preview: true},
+			expression: "(attribute_not_exists(Active) OR Active = :active) AND Preview = :preview",
+			names:      nil,
+		},
+		{
+			name:       "weekday",
+			filter:     ChatFilter{Weekday: &sunday},
+			expression: "(attribute_not_exists(Active) OR Active = :active) AND (attribute_not_exists(Weekdays) OR contains(Weekdays, :weekday))",
+			names:      nil,
+		},
+		{
+			name:   "weekday of a feast",
+			filter: ChatFilter{Weekday: &sunday, Feast: true},
+			expression: "(attribute_not_exists(Active) OR Active = :active)" +
+				" AND (attribute_not_exists(Weekdays) OR contains(Weekdays, :weekday) OR Feasts = :feasts)",
+			names: nil,
+		},
+		{
+			name:       "kind",
+			filter:     ChatFilter{Kind: "group"},
+			expression: "(attribute_not_exists(Active) OR Active = :active) AND #kind = :kind",
+			names:      map[string]string{"#kind": "Kind"},
+		},
+		{
+			name:       "default language",
+			filter:     ChatFilter{Language: DefaultLanguage},
+			expression: "(attribute_not_exists(Active) OR Active = :active) AND (#language = :language OR attribute_not_exists(#language))",
+			names:      map[string]string{"#language": "Language"},
+		},
+		{
+			name:       "other language",
+			filter:     ChatFilter{Kind: "private", Language: "en"},
+			expression: "(attribute_not_exists(Active) OR Active = :active) AND #kind = :kind AND #language = :language",
+			names:      map[string]string{"#kind": "Kind", "#language": "Language"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			expression, names, _ := test.filter.expression()
+			assert.Equal(tt, test.expression, expression)
+			assert.Equal(tt, test.names, names)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// maxReceivedMessages is the largest number of messages that SQS returns at once
+	maxReceivedMessages = 10
+
+	// receiveWaitSeconds is the time a receive waits for messages to arrive
+	receiveWaitSeconds = 20
+)
+
+// schedule runs the getgospelandnotify function at the start of every hour,
+// like the scheduled event of the deployed function
+func (r *runtime) schedule(ctx context.Context) {
+	for {
+		next := time.Now().Truncate(time.Hour).Add(time.Hour)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := r.notify(ctx, next); err != nil {
+			sugar.Errorw("error running scheduled job", "time", next, "error", err.Error())
+		}
+	}
+}
+
+// notify invokes the getgospelandnotify function with a scheduled event at the given time
+func (r *runtime) notify(ctx context.Context, at time.Time) error {
+	payload, err := json.Marshal(events.CloudWatchEvent{
+		Version:    "0",
+		ID:         newRequestID(),
+		DetailType: "Scheduled Event",
+		Source:     "aws.events",
+		Time:       at,
+		Region:     r.region,
+		Detail:     json.RawMessage("{}"),
+	})
+	if err != nil {
+		return fmt.Errorf("error converting scheduled event into JSON: %w", err)
+	}
+	sugar.Infow("running scheduled job", "time", at)
+	_, err = r.functions[getGospelAndNotifyFunction].invoke(ctx, payload)
+	return err
+}
+
+// consume receives the messages of the queue and invokes the sendgospel function
+// with them, like the SQS event source of the deployed function. The messages that
+// the function reports as failed are left in the queue, to be received again or
+// moved to the dead-letter queue.
+func (r *runtime) consume(ctx context.Context) {
+	for ctx.Err() == nil {
+		output, err := r.sqs.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(r.queueURL),
+			MaxNumberOfMessages:   maxReceivedMessages,
+			WaitTimeSeconds:       receiveWaitSeconds,
+			MessageAttributeNames: []string{"All"},
+			AttributeNames:        []types.QueueAttributeName{types.QueueAttributeNameAll},
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			sugar.Warnw("error receiving messages from the queue", "queue_url", r.queueURL, "error", err.Error())
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if len(output.Messages) > 0 {
+			r.deliver(ctx, output.Messages)
+		}
+	}
+}
+
+// deliver invokes the sendgospel function with a batch of messages of the queue,
+// and deletes the ones that were delivered
+func (r *runtime) deliver(ctx context.Context, messages []types.Message) {
+	event := events.SQSEvent{Records: make([]events.SQSMessage, len(messages))}
+	for i, message := range messages {
+		attributes := map[string]events.SQSMessageAttribute{}
+		for name, attribute := range message.MessageAttributes {
+			attributes[name] = events.SQSMessageAttribute{
+				StringValue: attribute.StringValue,
+				BinaryValue: attribute.BinaryValue,
+				DataType:    aws.ToString(attribute.DataType),
+			}
+		}
+		event.Records[i] = events.SQSMessage{
+			MessageId:         aws.ToString(message.MessageId),
+			ReceiptHandle:     aws.ToString(message.ReceiptHandle),
+			Body:              aws.ToString(message.Body),
+			Md5OfBody:         aws.ToString(message.MD5OfBody),
+			Attributes:        message.Attributes,
+			MessageAttributes: attributes,
+			EventSource:       "aws:sqs",
+			AWSRegion:         r.region,
+		}
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		sugar.Errorw("error converting sqs event into JSON", "error", err.Error())
+		return
+	}
+	output, err := r.functions[sendGospelFunction].invoke(ctx, payload)
+	if err != nil {
+		sugar.Errorw("error delivering messages", "messages", len(messages), "error", err.Error())
+		return
+	}
+
+	var response events.SQSEventResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		sugar.Errorw("error reading the response of the delivery", "error", err.Error())
+		return
+	}
+	failed := map[string]bool{}
+	for _, failure := range response.BatchItemFailures {
+		failed[failure.ItemIdentifier] = true
+	}
+
+	for _, message := range messages {
+		if failed[aws.ToString(message.MessageId)] {
+			continue
+		}
+		_, err := r.sqs.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(r.queueURL),
+			ReceiptHandle: message.ReceiptHandle,
+		})
+		if err != nil {
+			sugar.Warnw("error deleting delivered message", "sqs_message_id", aws.ToString(message.MessageId), "error", err.Error())
+		}
+	}
+}
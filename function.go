@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda/messages"
+)
+
+const (
+	// functionStartTimeout is the time a function has to start listening
+	functionStartTimeout = 30 * time.Second
+
+	// functionARNPrefix is the prefix of the ARN of the local functions
+	functionARNPrefix = "arn:aws:lambda:local:000000000000:function:"
+)
+
+// function is a Lambda function of the bot running as a local process. Functions
+// are invoked through the RPC protocol of the go1.x runtime of AWS Lambda, so
+// they run the same code as when they are deployed.
+type function struct {
+	name    string
+	path    string
+	port    int
+	env     []string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	client *rpc.Client
+
+	// exited is closed when the process of the function exits
+	exited chan struct{}
+}
+
+// newFunction returns the function built as the binary called name in the directory
+func newFunction(name, dir string, port int, env []string, timeout time.Duration) *function {
+	return &function{
+		name:    name,
+		path:    filepath.Join(dir, name),
+		port:    port,
+		env:     env,
+		timeout: timeout,
+	}
+}
+
+// start runs the process of the function and waits until it accepts invocations
+func (f *function) start() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.startLocked()
+}
+
+// stop ends the process of the function
+func (f *function) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopLocked()
+}
+
+// restart replaces the process of the function whose client failed, unless
+// another invocation already replaced it, and returns the new client
+func (f *function) restart(failed *rpc.Client) (*rpc.Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.client != nil && f.client != failed {
+		return f.client, nil
+	}
+	f.stopLocked()
+	if err := f.startLocked(); err != nil {
+		return nil, err
+	}
+	return f.client, nil
+}
+
+func (f *function) startLocked() error {
+	cmd := exec.Command(f.path)
+	cmd.Env = append(append([]string{}, f.env...), fmt.Sprintf("_LAMBDA_SERVER_PORT=%d", f.port))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting function %s: %w", f.name, err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	address := fmt.Sprintf("localhost:%d", f.port)
+	deadline := time.Now().Add(functionStartTimeout)
+	for {
+		client, err := rpc.Dial("tcp", address)
+		if err == nil {
+			if err = client.Call("Function.Ping", &messages.PingRequest{}, &messages.PingResponse{}); err == nil {
+				f.cmd = cmd
+				f.client = client
+				f.exited = exited
+				return nil
+			}
+			client.Close()
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			<-exited
+			return fmt.Errorf("error waiting for function %s to listen on %s: %w", f.name, address, err)
+		}
+		select {
+		case <-exited:
+			return fmt.Errorf("error starting function %s: %s", f.name, cmd.ProcessState.String())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (f *function) stopLocked() {
+	if f.client != nil {
+		f.client.Close()
+		f.client = nil
+	}
+	if f.cmd != nil {
+		f.cmd.Process.Signal(os.Interrupt)
+		<-f.exited
+		f.cmd = nil
+	}
+}
+
+// invoke runs the function with the payload and returns its response. Like
+// Lambda, a new process replaces the one of a function that has exited, but
+// the invocation that made it exit is not run again.
+func (f *function) invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	f.mu.Lock()
+	client := f.client
+	f.mu.Unlock()
+
+	response, err := f.call(ctx, client, payload)
+	if errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.ErrUnexpectedEOF) {
+		sugar.Warnw("function exited, restarting it", "function", f.name, "error", err.Error())
+		if _, restartErr := f.restart(client); restartErr != nil {
+			sugar.Errorw("error restarting function", "function", f.name, "error", restartErr.Error())
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("error in function %s: %s", f.name, response.Error.Message)
+	}
+	return response.Payload, nil
+}
+
+func (f *function) call(ctx context.Context, client *rpc.Client, payload []byte) (*messages.InvokeResponse, error) {
+	if client == nil {
+		return nil, rpc.ErrShutdown
+	}
+
+	deadline := time.Now().Add(f.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	request := &messages.InvokeRequest{
+		Payload:   payload,
+		RequestId: newRequestID(),
+		Deadline: messages.InvokeRequest_Timestamp{
+			Seconds: deadline.Unix(),
+			Nanos:   int64(deadline.Nanosecond()),
+		},
+		InvokedFunctionArn: functionARNPrefix + f.name,
+	}
+
+	response := &messages.InvokeResponse{}
+	call := client.Go("Function.Invoke", request, response, nil)
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("error invoking function %s: %w", f.name, ctx.Err())
+	case <-call.Done:
+		if call.Error != nil {
+			return nil, fmt.Errorf("error invoking function %s: %w", f.name, call.Error)
+		}
+		return response, nil
+	}
+}
+
+// newRequestID returns a random identifier for an invocation
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
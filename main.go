@@ -1,69 +1,211 @@
+// The magnifibot command runs the whole bot locally. Every Lambda function runs
+// as a process built from its own package, and this runtime plays the part of AWS:
+// it hosts the webhook of the bot, runs the scheduled job every hour, consumes the
+// queue and serves the Lambda API that the functions use to invoke each other.
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"regexp"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/igvaquero18/magnifibot/api"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/igvaquero18/magnifibot/controller"
+	"github.com/igvaquero18/magnifibot/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
 )
 
+const (
+	verboseEnv             = "MAGNIFIBOT_VERBOSE"
+	awsRegionEnv           = "MAGNIFIBOT_AWS_REGION"
+	sqsEndpointEnv         = "MAGNIFIBOT_SQS_ENDPOINT"
+	sqsQueueNameEnv        = "MAGNIFIBOT_SQS_QUEUE_NAME"
+	localAddressEnv        = "MAGNIFIBOT_LOCAL_ADDRESS"
+	localFunctionsEnv      = "MAGNIFIBOT_LOCAL_FUNCTIONS_DIR"
+	localFirstPortEnv      = "MAGNIFIBOT_LOCAL_FUNCTIONS_PORT"
+	localTimeoutEnv        = "MAGNIFIBOT_LOCAL_TIMEOUT"
+	localScheduleEnv       = "MAGNIFIBOT_LOCAL_SCHEDULE"
+	lambdaEndpointEnv      = "MAGNIFIBOT_LAMBDA_ENDPOINT"
+	onDemandLambdaEnv      = "MAGNIFIBOT_ON_DEMAND_LAMBDA_FUNCTION_NAME"
+	sendBroadcastLambdaEnv = "MAGNIFIBOT_SEND_BROADCAST_LAMBDA_FUNCTION_NAME"
+)
+
+const (
+	verboseFlag        = "logging.verbose"
+	awsRegionFlag      = "aws.region"
+	sqsEndpointFlag    = "aws.sqs.endpoint"
+	sqsQueueNameFlag   = "aws.sqs.queue_name"
+	localAddressFlag   = "local.address"
+	localFunctionsFlag = "local.functions.dir"
+	localFirstPortFlag = "local.functions.port"
+	localTimeoutFlag   = "local.timeout"
+	localScheduleFlag  = "local.schedule"
+)
+
+// The names of the functions, as in serverless.yml
+const (
+	handleTelegramFunction     = "handletelegram"
+	getGospelAndNotifyFunction = "getgospelandnotify"
+	sendGospelFunction         = "sendgospel"
+	onDemandFunction           = "ondemand"
+	sendBroadcastFunction      = "sendbroadcast"
+	deadLettersFunction        = "deadletters"
+)
+
+var functionNames = []string{
+	handleTelegramFunction,
+	getGospelAndNotifyFunction,
+	sendGospelFunction,
+	onDemandFunction,
+	sendBroadcastFunction,
+	deadLettersFunction,
+}
+
+var sugar *zap.SugaredLogger
+
+// runtime runs the functions of the bot and the events that invoke them
+type runtime struct {
+	ctx       context.Context
+	region    string
+	functions map[string]*function
+	sqs       *sqs.Client
+	queueURL  string
+}
+
+func init() {
+	viper.SetDefault(verboseFlag, false)
+	viper.SetDefault(awsRegionFlag, "eu-west-3")
+	viper.SetDefault(sqsEndpointFlag, "")
+	viper.SetDefault(sqsQueueNameFlag, controller.DefaultQueueName)
+	viper.SetDefault(localAddressFlag, "localhost:8080")
+	viper.SetDefault(localFunctionsFlag, "bin/local")
+	viper.SetDefault(localFirstPortFlag, 9001)
+	viper.SetDefault(localTimeoutFlag, "30s")
+	viper.SetDefault(localScheduleFlag, true)
+	viper.BindEnv(verboseFlag, verboseEnv)
+	viper.BindEnv(awsRegionFlag, awsRegionEnv)
+	viper.BindEnv(sqsEndpointFlag, sqsEndpointEnv)
+	viper.BindEnv(sqsQueueNameFlag, sqsQueueNameEnv)
+	viper.BindEnv(localAddressFlag, localAddressEnv)
+	viper.BindEnv(localFunctionsFlag, localFunctionsEnv)
+	viper.BindEnv(localFirstPortFlag, localFirstPortEnv)
+	viper.BindEnv(localTimeoutFlag, localTimeoutEnv)
+	viper.BindEnv(localScheduleFlag, localScheduleEnv)
+
+	var err error
+
+	sugar, err = utils.InitSugaredLogger(viper.GetBool(verboseFlag))
+	if err != nil {
+		fmt.Printf("error when initializing logger: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
 func main() {
-	http.HandleFunc("/", func(resp http.ResponseWriter, req *http.Request) {
-		var u api.Update
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		if err := json.NewDecoder(req.Body).Decode(&u); err != nil {
-			resp.Write([]byte(fmt.Sprintf("error decoding the request: %s", err.Error())))
-			return
-		}
+	if err := run(ctx); err != nil {
+		sugar.Fatalw("error running the bot", "error", err.Error())
+	}
+}
 
-		defer req.Body.Close()
-
-		if u.Message != nil {
-			re := regexp.MustCompile(`/(\w*)@?\w*`)
-			if !re.Match([]byte(u.Message.Text)) {
-				return
-			}
-			submatch := re.FindStringSubmatch(u.Message.Text)
-			text := ""
-			if len(submatch) > 1 {
-				text = submatch[1]
-			}
-			fmt.Printf(
-				"Chat ID: %d\nUser ID: %d\nDate: %d\nText: %s\nType: %s\n\n",
-				u.Message.Chat.ID,
-				u.Message.From.ID,
-				u.Message.Date,
-				text,
-				u.Message.Chat.Type,
-			)
-			return
-		}
+// run starts the functions and the events that invoke them, until the context is done
+func run(ctx context.Context) error {
+	address := viper.GetString(localAddressFlag)
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("error parsing address %s: %w", address, err)
+	}
+	timeout, err := time.ParseDuration(viper.GetString(localTimeoutFlag))
+	if err != nil {
+		return fmt.Errorf("error parsing timeout %s: %w", viper.GetString(localTimeoutFlag), err)
+	}
 
-		if u.ChannelPost != nil {
-			name := "magnifibot_bot"
-			re := regexp.MustCompile(fmt.Sprintf(`/(\w*)@%s`, name))
-			if !re.Match([]byte(u.ChannelPost.Text)) {
-				return
-			}
-			submatch := re.FindStringSubmatch(u.ChannelPost.Text)
-			text := ""
-			if len(submatch) > 1 {
-				text = submatch[1]
-			}
-			if re.Match([]byte(u.ChannelPost.Text)) {
-				fmt.Printf(
-					"Chat ID: %d\nUser ID: %d\nDate: %d\nText: %s\nType: %s\n\n",
-					u.ChannelPost.Chat.ID,
-					u.ChannelPost.SenderChat.ID,
-					u.ChannelPost.Date,
-					text,
-					u.ChannelPost.Chat.Type,
-				)
-			}
-			fmt.Printf("")
-		}
+	region := viper.GetString(awsRegionFlag)
+	sqsEndpoint := viper.GetString(sqsEndpointFlag)
+	sugar.Infow("creating SQS client", "region", region, "url", sqsEndpoint)
+	sqsClient, err := utils.InitSQSClient(region, sqsEndpoint)
+	if err != nil {
+		return fmt.Errorf("error creating SQS client: %w", err)
+	}
+	queueURL, err := sqsClient.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(viper.GetString(sqsQueueNameFlag)),
 	})
-	http.ListenAndServe(":80", nil)
+	if err != nil {
+		return fmt.Errorf("error getting the URL of queue %s: %w", viper.GetString(sqsQueueNameFlag), err)
+	}
+
+	r := &runtime{
+		ctx:       ctx,
+		region:    region,
+		functions: map[string]*function{},
+		sqs:       sqsClient,
+		queueURL:  aws.ToString(queueURL.QueueUrl),
+	}
+
+	// The functions invoke each other through this runtime
+	env := append(
+		os.Environ(),
+		fmt.Sprintf("%s=http://localhost:%s", lambdaEndpointEnv, port),
+		fmt.Sprintf("%s=%s", onDemandLambdaEnv, onDemandFunction),
+		fmt.Sprintf("%s=%s", sendBroadcastLambdaEnv, sendBroadcastFunction),
+	)
+	for i, name := range functionNames {
+		f := newFunction(name, viper.GetString(localFunctionsFlag), viper.GetInt(localFirstPortFlag)+i, env, timeout)
+		sugar.Infow("starting function", "function", name, "path", f.path, "port", f.port)
+		if err := f.start(); err != nil {
+			r.stop()
+			return err
+		}
+		r.functions[name] = f
+	}
+	defer r.stop()
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.consume(ctx)
+	}()
+	if viper.GetBool(localScheduleFlag) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.schedule(ctx)
+		}()
+	}
+
+	server := &http.Server{Addr: address, Handler: r}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	sugar.Infow("listening for telegram updates and lambda invocations", "address", address)
+	err = server.ListenAndServe()
+	wg.Wait()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving on %s: %w", address, err)
+	}
+	return nil
+}
+
+// stop ends the processes of the functions
+func (r *runtime) stop() {
+	for name, f := range r.functions {
+		sugar.Infow("stopping function", "function", name)
+		f.stop()
+	}
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	// lambdaInvokePath is the prefix of the path of the Invoke action of the Lambda API
+	lambdaInvokePath = "/2015-03-31/functions/"
+
+	// notifyPath runs the scheduled job right away
+	notifyPath = "/notify"
+)
+
+// ServeHTTP routes the requests to the local runtime. Telegram updates are sent to any
+// path other than the ones of the Lambda API and of the scheduled job.
+func (r *runtime) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case strings.HasPrefix(req.URL.Path, lambdaInvokePath):
+		r.serveInvoke(w, req)
+	case req.URL.Path == notifyPath:
+		r.serveNotify(w, req)
+	default:
+		r.serveWebhook(w, req)
+	}
+}
+
+// serveWebhook passes the updates sent by Telegram to the handletelegram function,
+// as the HTTP API of API Gateway does
+func (r *runtime) serveWebhook(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	headers := map[string]string{}
+	for name, values := range req.Header {
+		headers[name] = strings.Join(values, ",")
+	}
+	payload, err := json.Marshal(events.APIGatewayProxyRequest{
+		HTTPMethod: req.Method,
+		Path:       req.URL.Path,
+		Headers:    headers,
+		Body:       string(body),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	output, err := r.functions[handleTelegramFunction].invoke(req.Context(), payload)
+	if err != nil {
+		sugar.Errorw("error handling update", "error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	var response events.APIGatewayProxyResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	w.WriteHeader(response.StatusCode)
+	w.Write([]byte(response.Body))
+}
+
+// serveInvoke serves the Invoke action of the Lambda API, so that the functions
+// invoke each other through MAGNIFIBOT_LAMBDA_ENDPOINT. Asynchronous invocations
+// are answered right away, and their errors are only logged.
+func (r *runtime) serveInvoke(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, lambdaInvokePath), "/invocations")
+	// The name can be the ARN of the function too
+	name = name[strings.LastIndex(name, ":")+1:]
+	f, ok := r.functions[name]
+	if req.Method != http.MethodPost || !ok {
+		http.Error(w, fmt.Sprintf("function %s not found", name), http.StatusNotFound)
+		return
+	}
+
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	if req.Header.Get("X-Amz-Invocation-Type") == "Event" {
+		go func() {
+			ctx, cancel := context.WithTimeout(r.ctx, f.timeout)
+			defer cancel()
+			if _, err := f.invoke(ctx, payload); err != nil {
+				sugar.Errorw("error in asynchronous invocation", "function", name, "error", err.Error())
+			}
+		}()
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	output, err := f.invoke(req.Context(), payload)
+	if err != nil {
+		w.Header().Set("X-Amz-Function-Error", "Unhandled")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"errorMessage": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(output)
+}
+
+// serveNotify runs the scheduled job, as if its hour had come
+func (r *runtime) serveNotify(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.notify(req.Context(), time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,11 @@
+package utils
+
+import "regexp"
+
+var markdownV2SpecialChars = regexp.MustCompile(`([_\*\[\]\(\)\~\>#\+\-\=\|\{\}\.!])`)
+
+// EscapeMarkdownV2 escapes all the characters that have a special meaning
+// in Telegram's MarkdownV2 parse mode, so that text can be sent verbatim.
+func EscapeMarkdownV2(text string) string {
+	return markdownV2SpecialChars.ReplaceAllString(text, `\$1`)
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapeMarkdownV2(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{
+			name:     "No special characters",
+			text:     "En aquel tiempo",
+			expected: "En aquel tiempo",
+		},
+		{
+			name:     "Gospel reference",
+			text:     "Lectura del santo Evangelio según san Mateo 20, 17-28",
+			expected: `Lectura del santo Evangelio según san Mateo 20, 17\-28`,
+		},
+		{
+			name:     "Day with dots and slashes",
+			text:     "16/03/2022 - Miércoles de la 2ª semana de Cuaresma.",
+			expected: `16/03/2022 \- Miércoles de la 2ª semana de Cuaresma\.`,
+		},
+		{
+			name:     "All special characters",
+			text:     "_*[]()~>#+-=|{}.!",
+			expected: `\_\*\[\]\(\)\~\>\#\+\-\=\|\{\}\.\!`,
+		},
+		{
+			name:     "Empty text",
+			text:     "",
+			expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			actual := EscapeMarkdownV2(tc.text)
+			assert.Equal(tt, tc.expected, actual)
+		})
+	}
+}
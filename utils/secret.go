@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// DeriveSecret returns the secret to use for a given purpose. When no secret has
+// been configured, one is derived from the bot token, so that every function of
+// the same bot agrees on it without any extra setting.
+func DeriveSecret(secret, botToken, purpose string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+	mac := hmac.New(sha256.New, []byte(botToken))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveSecret(t *testing.T) {
+	tests := []struct {
+		name        string
+		secret      string
+		botToken    string
+		purpose     string
+		sameAs      []byte
+		differentTo []byte
+	}{
+		{
+			name:     "configured secret",
+			secret:   "secret",
+			botToken: "123:token",
+			purpose:  "callbacks",
+			sameAs:   []byte("secret"),
+		},
+		{
+			name:        "derived secret",
+			botToken:    "123:token",
+			purpose:     "callbacks",
+			sameAs:      DeriveSecret("", "123:token", "callbacks"),
+			differentTo: DeriveSecret("", "123:token", "webhook"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			actual := DeriveSecret(test.secret, test.botToken, test.purpose)
+			assert.Equal(tt, test.sameAs, actual)
+			if test.differentTo != nil {
+				assert.NotEqual(tt, test.differentTo, actual)
+			}
+		})
+	}
+}
@@ -1,5 +1,12 @@
 package utils
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
 // AllButOne receives an array of strings as a parameter and returns the same
 // array without one of its items, which is passed as the second parameter.
 func AllButOne(items []string, item string) []string {
@@ -12,3 +19,17 @@ func AllButOne(items []string, item string) []string {
 	}
 	return result
 }
+
+// ParseIDs parses a list of numeric identifiers, like Telegram user IDs,
+// separated by commas or spaces.
+func ParseIDs(list string) ([]int64, error) {
+	ids := []int64{}
+	for _, field := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing identifier %s: %w", field, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -46,3 +46,46 @@ func TestAllButOne(t *testing.T) {
 		})
 	}
 }
+
+func TestParseIDs(t *testing.T) {
+	testCases := []struct {
+		name          string
+		list          string
+		expected      []int64
+		errorExpected bool
+	}{
+		{
+			name:     "Comma separated identifiers",
+			list:     "12,-34, 56",
+			expected: []int64{12, -34, 56},
+		},
+		{
+			name:     "Space separated identifiers",
+			list:     "12 34",
+			expected: []int64{12, 34},
+		},
+		{
+			name:     "Empty list",
+			list:     "",
+			expected: []int64{},
+		},
+		{
+			name:          "Invalid identifier",
+			list:          "12,abc",
+			expected:      nil,
+			errorExpected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			actual, err := ParseIDs(tc.list)
+			if tc.errorExpected {
+				assert.Error(tt, err)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, tc.expected, actual)
+		})
+	}
+}
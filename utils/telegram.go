@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// CallBotAPI calls a method of the Telegram Bot API with the given parameters, and
+// decodes its result into result, unless it is nil. The endpoint is the format of
+// the URL of the methods, with the token and the method. Since the URL holds the
+// token, it is left out of the errors, which end up in the logs.
+func CallBotAPI(ctx context.Context, endpoint, token, method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("error marshalling parameters of %s: %w", method, err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		fmt.Sprintf(endpoint, token, method),
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating request of %s: %w", method, withoutURL(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling %s: %w", method, withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("error decoding response of %s: %w", method, err)
+	}
+	if !response.OK {
+		return fmt.Errorf("error calling %s: %s", method, response.Description)
+	}
+	if result != nil {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("error decoding result of %s: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// withoutURL returns the error wrapped by a *url.Error, whose message includes the URL
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
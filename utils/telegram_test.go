@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testBotToken = "123456:secret-token"
+
+func TestCallBotAPI(t *testing.T) {
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		closed        bool
+		expected      []int
+		errorExpected bool
+	}{
+		{
+			name: "result",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"ok":true,"result":[1,2]}`)
+			},
+			expected:      []int{1, 2},
+			errorExpected: false,
+		},
+		{
+			name: "method failed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"ok":false,"description":"Conflict"}`)
+			},
+			expected:      nil,
+			errorExpected: true,
+		},
+		{
+			name: "invalid response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `not json`)
+			},
+			expected:      nil,
+			errorExpected: true,
+		},
+		{
+			name:          "network error",
+			handler:       func(w http.ResponseWriter, r *http.Request) {},
+			closed:        true,
+			expected:      nil,
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(tt *testing.T) {
+			server := httptest.NewServer(test.handler)
+			if test.closed {
+				server.Close()
+			} else {
+				defer server.Close()
+			}
+
+			var actual []int
+			err := CallBotAPI(context.TODO(), server.URL+"/bot%s/%s", testBotToken, "getUpdates", map[string]interface{}{}, &actual)
+			if test.errorExpected {
+				assert.Error(tt, err)
+				// The errors end up in the logs, so they must not reveal the token
+				assert.NotContains(tt, err.Error(), testBotToken)
+			} else {
+				assert.NoError(tt, err)
+			}
+			assert.Equal(tt, test.expected, actual)
+		})
+	}
+}